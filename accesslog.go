@@ -0,0 +1,167 @@
+package gost
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AccessRecord is the structured record of a proxy session.
+type AccessRecord struct {
+	ID        uint64    `json:"id"`
+	Time      time.Time `json:"time"`
+	Node      string    `json:"node,omitempty"` // the serve node
+	Handler   string    `json:"handler"`
+	Client    string    `json:"client"`
+	User      string    `json:"user,omitempty"`
+	Host      string    `json:"host"`
+	Route     []int     `json:"route,omitempty"`  // IDs of the nodes in the selected route
+	BytesUp   uint64    `json:"bytes_up"`         // bytes read from the client
+	BytesDown uint64    `json:"bytes_down"`       // bytes written to the client
+	Duration  float64   `json:"duration"`         // in seconds
+	Reason    string    `json:"reason,omitempty"` // why the session is closed
+}
+
+// AccessLogger writes one JSON line per finished proxy session.
+type AccessLogger struct {
+	w   io.Writer
+	mux sync.Mutex
+}
+
+// NewAccessLogger creates an AccessLogger that writes the records to w.
+func NewAccessLogger(w io.Writer) *AccessLogger {
+	return &AccessLogger{w: w}
+}
+
+// Log writes the record rec.
+func (l *AccessLogger) Log(rec *AccessRecord) error {
+	if l == nil || rec == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	_, err = l.w.Write(b)
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (l *AccessLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// RotateFile is a file writer that rotates the file when its size exceeds the max size.
+// The rotated files are renamed to name.1, name.2 ... name.N, the oldest ones exceeding the backups are removed.
+type RotateFile struct {
+	name    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+	mux     sync.Mutex
+}
+
+// NewRotateFile opens the file name for appending.
+// A non-positive maxSize disables the rotation.
+func NewRotateFile(name string, maxSize int64, backups int) (*RotateFile, error) {
+	f := &RotateFile{
+		name:    name,
+		maxSize: maxSize,
+		backups: backups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetRotation changes the max size and the number of backups of the file,
+// they take effect on the next write.
+func (f *RotateFile) SetRotation(maxSize int64, backups int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.maxSize = maxSize
+	f.backups = backups
+}
+
+func (f *RotateFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotateFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.backups <= 0 {
+		os.Remove(f.name)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", f.name, f.backups))
+		for i := f.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.name, i), fmt.Sprintf("%s.%d", f.name, i+1))
+		}
+		if err := os.Rename(f.name, f.name+".1"); err != nil {
+			return err
+		}
+	}
+	return f.open()
+}
+
+// Write implements io.Writer.
+func (f *RotateFile) Write(b []byte) (n int, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		if err = f.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = f.file.Write(b)
+	f.size += int64(n)
+	return
+}
+
+// Close closes the file.
+func (f *RotateFile) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
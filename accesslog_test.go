@@ -0,0 +1,256 @@
+package gost
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type chanWriter chan []byte
+
+func (w chanWriter) Write(b []byte) (int, error) {
+	w <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func TestAccessLogSOCKS5(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := make(chanWriter, 1)
+	client := &Client{
+		Connector:   SOCKS5Connector(url.UserPassword("admin", "123456")),
+		Transporter: TCPTransporter(),
+	}
+	server := &Server{
+		Listener: ln,
+		Handler: SOCKS5Handler(
+			UsersHandlerOption(url.UserPassword("admin", "123456")),
+			AccessLogHandlerOption(NewAccessLogger(records)),
+		),
+	}
+	go server.Run()
+	defer server.Close()
+
+	if err := proxyRoundtrip(client, server, httpSrv.URL, sendData); err != nil {
+		t.Fatal(err)
+	}
+
+	var b []byte
+	select {
+	case b = <-records: // the record is written after the session is finished.
+	case <-time.After(time.Second):
+		t.Fatal("no record is written")
+	}
+	var rec AccessRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf("invalid record %q: %v", b, err)
+	}
+	u, _ := url.Parse(httpSrv.URL)
+	if rec.Handler != "socks5" || rec.User != "admin" || rec.Host != u.Host {
+		t.Errorf("wrong record %+v", rec)
+	}
+	if rec.BytesUp == 0 || rec.BytesDown == 0 {
+		t.Errorf("bytes should be counted, got %+v", rec)
+	}
+	if rec.Reason != "client closed" {
+		t.Errorf("wrong close reason %s", rec.Reason)
+	}
+}
+
+func readRecord(t *testing.T, records chanWriter) AccessRecord {
+	var b []byte
+	select {
+	case b = <-records:
+	case <-time.After(time.Second):
+		t.Fatal("no record is written")
+	}
+	var rec AccessRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf("invalid record %q: %v", b, err)
+	}
+	return rec
+}
+
+func TestAccessLogHTTPForward(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	upLn, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &Server{Listener: upLn, Handler: HTTPHandler()}
+	go upstream.Run()
+	defer upstream.Close()
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make(chanWriter, 1)
+	chain := NewChain(Node{
+		ID:       1,
+		Protocol: "http",
+		Addr:     upLn.Addr().String(),
+		Client: &Client{
+			Connector:   HTTPConnector(nil),
+			Transporter: TCPTransporter(),
+		},
+	})
+	server := &Server{
+		Listener: ln,
+		Handler: HTTPHandler(
+			ChainHandlerOption(chain),
+			AccessLogHandlerOption(NewAccessLogger(records)),
+		),
+	}
+	go server.Run()
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the plain HTTP request is forwarded to the HTTP proxy at the end of the chain.
+	req, _ := http.NewRequest(http.MethodGet, httpSrv.URL, bytes.NewReader([]byte("ping")))
+	if err := req.WriteProxy(conn); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+	conn.Close()
+
+	rec := readRecord(t, records)
+	u, _ := url.Parse(httpSrv.URL)
+	if rec.Handler != "http" || rec.Host != u.Host || len(rec.Route) != 1 || rec.Route[0] != 1 {
+		t.Errorf("wrong record %+v", rec)
+	}
+	if rec.BytesDown == 0 {
+		t.Errorf("bytes should be counted, got %+v", rec)
+	}
+}
+
+func TestAccessLogSOCKS5UDP(t *testing.T) {
+	udpSrv := newUDPTestServer(udpTestHandler)
+	udpSrv.Start()
+	defer udpSrv.Close()
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make(chanWriter, 1)
+	client := &Client{
+		Connector:   SOCKS5UDPConnector(url.UserPassword("admin", "123456")),
+		Transporter: TCPTransporter(),
+	}
+	server := &Server{
+		Listener: ln,
+		Handler: SOCKS5Handler(
+			UsersHandlerOption(url.UserPassword("admin", "123456")),
+			AccessLogHandlerOption(NewAccessLogger(records)),
+		),
+	}
+	go server.Run()
+	defer server.Close()
+
+	if err := udpRoundtrip(t, client, server, udpSrv.Addr(), []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := readRecord(t, records)
+	if rec.Handler != "socks5-udp" || rec.User != "admin" {
+		t.Errorf("wrong record %+v", rec)
+	}
+	if rec.Reason != "client closed" {
+		t.Errorf("wrong close reason %s", rec.Reason)
+	}
+}
+
+func TestAccessLoggerClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := NewRotateFile(filepath.Join(dir, "access.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := NewAccessLogger(f)
+	if err := logger.Log(&AccessRecord{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Log(&AccessRecord{ID: 2}); err != os.ErrClosed {
+		t.Errorf("the file should be closed, got %v", err)
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "access.log")
+
+	f, err := NewRotateFile(name, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, s := range []string{"1111111\n", "2222222\n", "3333333\n", "4444444\n"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		name:        "4444444\n",
+		name + ".1": "3333333\n",
+		name + ".2": "2222222\n",
+	} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q, want %q", name, b, want)
+		}
+	}
+	if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Error("the oldest backup should be removed")
+	}
+}
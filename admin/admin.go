@@ -0,0 +1,371 @@
+// Package admin implements an HTTP API for inspecting and mutating the running routers.
+//
+// The API:
+//
+//	GET    /routers                                  list the routers
+//	GET    /routers/{id}                             get the router
+//	PUT    /routers/{id}/groups/{gid}/nodes          replace the nodes of the chain node group, the body is a JSON array of nodes
+//	POST   /routers/{id}/groups/{gid}/nodes          append the nodes to the chain node group, the body is a JSON array of nodes
+//	DELETE /routers/{id}/groups/{gid}/nodes/{nid}    remove the node from the chain node group
+//	PUT    /routers/{id}/bypass                      replace the bypass list, the body is in the bypass file format
+//	PUT    /routers/{id}/whitelist                   replace the whitelist permissions, an empty body removes it
+//	PUT    /routers/{id}/blacklist                   replace the blacklist permissions, an empty body removes it
+//	GET    /sessions                                 list the active sessions
+//	DELETE /sessions/{id}                            kill the session
+//
+// The router ID is the index of the router, starting from 0,
+// and the group ID is the position of the node group in the chain, starting from 1.
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/far4599/gost-minimal"
+	"github.com/far4599/gost-minimal/config"
+)
+
+var (
+	errNotFound = errors.New("not found")
+)
+
+// Server is the admin API server.
+type Server struct {
+	Routers       []*config.Router
+	Sessions      *gost.Sessions
+	Authenticator gost.Authenticator
+	mux           sync.Mutex // serializes the mutations
+}
+
+type nodeInfo struct {
+	ID        int    `json:"id"`
+	Node      string `json:"node"`
+	FailCount uint32 `json:"fail_count"`
+	FailTime  int64  `json:"fail_time,omitempty"`
+	Conns     int64  `json:"conns"`
+	Latency   int64  `json:"latency,omitempty"` // in milliseconds
+}
+
+type groupInfo struct {
+	ID    int        `json:"id"`
+	Nodes []nodeInfo `json:"nodes"`
+}
+
+type bypassInfo struct {
+	Reversed bool     `json:"reversed"`
+	Matchers []string `json:"matchers"`
+}
+
+type routerInfo struct {
+	ID        int         `json:"id"`
+	Node      string      `json:"node"`
+	Addr      string      `json:"addr,omitempty"`
+	Chain     []groupInfo `json:"chain"`
+	Bypass    *bypassInfo `json:"bypass,omitempty"`
+	Rules     []string    `json:"rules,omitempty"`
+	Whitelist string      `json:"whitelist,omitempty"`
+	Blacklist string      `json:"blacklist,omitempty"`
+}
+
+// SetRouters replaces the routers, such as after the config is reloaded.
+func (s *Server) SetRouters(routers []*config.Router) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.Routers = routers
+}
+
+func (s *Server) routers() []*config.Router {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.Routers
+}
+
+func (s *Server) routerInfo(id int, r *config.Router) routerInfo {
+	info := routerInfo{
+		ID:        id,
+		Node:      r.Node.String(),
+		Chain:     []groupInfo{},
+		Whitelist: r.Whitelist,
+		Blacklist: r.Blacklist,
+	}
+	if r.Server != nil && r.Server.Listener != nil {
+		info.Addr = r.Server.Addr().String()
+	}
+	for i, group := range r.Chain.NodeGroups() {
+		gi := groupInfo{ID: i + 1, Nodes: []nodeInfo{}}
+		for _, node := range group.Nodes() {
+			gi.Nodes = append(gi.Nodes, nodeInfo{
+				ID:        node.ID,
+				Node:      node.String(),
+				FailCount: node.FailCount(),
+				FailTime:  node.FailTime(),
+				Conns:     node.Conns(),
+				Latency:   node.Latency().Milliseconds(),
+			})
+		}
+		info.Chain = append(info.Chain, gi)
+	}
+	if r.Rules != nil {
+		for _, rule := range r.Rules.Rules() {
+			info.Rules = append(info.Rules, rule.String())
+		}
+	}
+	if r.Bypass != nil {
+		info.Bypass = &bypassInfo{Reversed: r.Bypass.Reversed(), Matchers: []string{}}
+		for _, m := range r.Bypass.Matchers() {
+			info.Bypass.Matchers = append(info.Bypass.Matchers, m.String())
+		}
+	}
+	return info
+}
+
+// authenticate checks the user of the API, the client is passed to the AuthenticatorV2.
+func (s *Server) authenticate(user, password, client string) bool {
+	if au, ok := s.Authenticator.(gost.AuthenticatorV2); ok {
+		_, ok = au.AuthenticateV2(&gost.AuthRequest{
+			User:     user,
+			Password: password,
+			Client:   client,
+			Protocol: "admin",
+		})
+		return ok
+	}
+	return s.Authenticator.Authenticate(user, password)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Authenticator != nil {
+		u, p, _ := r.BasicAuth()
+		if !s.authenticate(u, p, r.RemoteAddr) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gost"`)
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch path[0] {
+	case "routers":
+		s.serveRouters(w, r, path[1:])
+	case "sessions":
+		s.serveSessions(w, r, path[1:])
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+	}
+}
+
+func (s *Server) serveRouters(w http.ResponseWriter, r *http.Request, path []string) {
+	if len(path) == 0 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+			return
+		}
+		routers := []routerInfo{}
+		for i, rt := range s.routers() {
+			routers = append(routers, s.routerInfo(i, rt))
+		}
+		writeJSON(w, http.StatusOK, routers)
+		return
+	}
+
+	routers := s.routers()
+	id, err := strconv.Atoi(path[0])
+	if err != nil || id < 0 || id >= len(routers) {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	rt := routers[id]
+
+	switch {
+	case len(path) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.routerInfo(id, rt))
+		return
+
+	case len(path) >= 4 && path[1] == "groups" && path[3] == "nodes":
+		var group *gost.NodeGroup
+		for i, g := range rt.Chain.NodeGroups() {
+			if strconv.Itoa(i+1) == path[2] {
+				group = g
+			}
+		}
+		if group == nil {
+			writeError(w, http.StatusNotFound, errNotFound)
+			return
+		}
+		err = s.updateNodes(r, group, path[4:])
+
+	case len(path) == 2 && path[1] == "bypass" && r.Method == http.MethodPut:
+		err = s.updateBypass(r, rt)
+
+	case len(path) == 2 && (path[1] == "whitelist" || path[1] == "blacklist") && r.Method == http.MethodPut:
+		err = s.updatePermissions(r, rt, path[1])
+
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == errNotFound {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.routerInfo(id, rt))
+}
+
+func (s *Server) updateNodes(r *http.Request, group *gost.NodeGroup, path []string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	nodes := group.Nodes()
+
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		var nl []gost.Node
+		var removed *gost.Node
+		for i, node := range nodes {
+			if strconv.Itoa(node.ID) != path[0] {
+				nl = append(nl, node)
+			} else {
+				removed = &nodes[i]
+			}
+		}
+		if removed == nil {
+			return errNotFound
+		}
+		group.SetNodes(nl...)
+		removed.Pool.Stop()
+		return nil
+	}
+	if len(path) > 0 || (r.Method != http.MethodPut && r.Method != http.MethodPost) {
+		return errNotFound
+	}
+
+	var ss []string
+	if err := json.NewDecoder(r.Body).Decode(&ss); err != nil {
+		return err
+	}
+
+	var nl []gost.Node
+	if r.Method == http.MethodPost {
+		nl = nodes
+	}
+	nid := 0
+	for _, node := range nl {
+		if node.ID > nid {
+			nid = node.ID
+		}
+	}
+	base := len(nl)
+	for _, ns := range ss {
+		parsed, err := config.ParseChainNode(ns)
+		if err != nil {
+			for _, node := range nl[base:] {
+				node.Pool.Stop() // drop the parsed nodes
+			}
+			return fmt.Errorf("%s: %v", gost.Redact(ns), err)
+		}
+		for i := range parsed {
+			nid++
+			parsed[i].ID = nid
+		}
+		nl = append(nl, parsed...)
+	}
+	group.SetNodes(nl...)
+	for _, node := range nl[base:] {
+		node.Pool.Start(node)
+	}
+	if r.Method == http.MethodPut {
+		for _, node := range nodes {
+			node.Pool.Stop()
+		}
+	}
+	return nil
+}
+
+func (s *Server) updateBypass(r *http.Request, rt *config.Router) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// the bypass of the router may be shared or live reloaded from its file, it is replaced.
+	bp := gost.NewBypass(false)
+	if err := bp.Reload(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	rt.SetBypass(bp)
+	return nil
+}
+
+func (s *Server) updatePermissions(r *http.Request, rt *config.Router, name string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	var ps *gost.Permissions
+	spec := strings.TrimSpace(string(data))
+	if spec != "" {
+		if ps, err = gost.ParsePermissions(spec); err != nil {
+			return err
+		}
+	}
+
+	if name == "whitelist" {
+		rt.SetWhitelist(spec, ps)
+	} else {
+		rt.SetBlacklist(spec, ps)
+	}
+	return nil
+}
+
+func (s *Server) serveSessions(w http.ResponseWriter, r *http.Request, path []string) {
+	switch {
+	case len(path) == 0 && r.Method == http.MethodGet:
+		sessions := s.Sessions.List()
+		if sessions == nil {
+			sessions = []gost.AccessRecord{}
+		}
+		writeJSON(w, http.StatusOK, sessions)
+
+	case len(path) == 1 && r.Method == http.MethodDelete:
+		id, _ := strconv.ParseUint(path[0], 10, 64)
+		if !s.Sessions.Kill(id) {
+			writeError(w, http.StatusNotFound, errNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
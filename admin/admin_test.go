@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/far4599/gost-minimal"
+	"github.com/far4599/gost-minimal/config"
+)
+
+func init() {
+	gost.SetLogger(&gost.NopLogger{})
+}
+
+func request(t *testing.T, srv *httptest.Server, method, path, body string, v interface{}) int {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("admin", "123456")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		json.NewDecoder(resp.Body).Decode(v)
+	}
+	return resp.StatusCode
+}
+
+func newTestRouter(t *testing.T, chainNodes ...string) *config.Router {
+	route := &config.Route{ChainNodes: chainNodes}
+	chain, err := route.ParseChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ := gost.ParseNode("http://:0")
+	return &config.Router{
+		Node:    node,
+		Handler: gost.HTTPHandler(gost.ChainHandlerOption(chain)),
+		Chain:   chain,
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	srv := httptest.NewServer(&Server{
+		Authenticator: gost.NewLocalAuthenticator(map[string]string{"admin": "654321"}),
+	})
+	defer srv.Close()
+
+	if status := request(t, srv, http.MethodGet, "/routers", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", status)
+	}
+}
+
+func TestAdminNodes(t *testing.T) {
+	rt := newTestRouter(t, "socks5://127.0.0.1:1080")
+	srv := httptest.NewServer(&Server{Routers: []*config.Router{rt}})
+	defer srv.Close()
+
+	var routers []routerInfo
+	if status := request(t, srv, http.MethodGet, "/routers", "", &routers); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if len(routers) != 1 || len(routers[0].Chain) != 1 || len(routers[0].Chain[0].Nodes) != 1 {
+		t.Fatalf("wrong routers %+v", routers)
+	}
+
+	var info routerInfo
+	request(t, srv, http.MethodPost, "/routers/0/groups/1/nodes", `["http://127.0.0.1:8080"]`, &info)
+	if nodes := info.Chain[0].Nodes; len(nodes) != 2 || nodes[1].ID != 2 || nodes[1].Node != "http://127.0.0.1:8080" {
+		t.Errorf("wrong nodes %+v", nodes)
+	}
+
+	request(t, srv, http.MethodDelete, "/routers/0/groups/1/nodes/1", "", &info)
+	if nodes := info.Chain[0].Nodes; len(nodes) != 1 || nodes[0].ID != 2 {
+		t.Errorf("wrong nodes %+v", nodes)
+	}
+	if nodes := rt.Chain.NodeGroups()[0].Nodes(); len(nodes) != 1 || nodes[0].Client == nil {
+		t.Errorf("wrong nodes of the group %+v", nodes)
+	}
+
+	request(t, srv, http.MethodPut, "/routers/0/groups/1/nodes", `["socks5://127.0.0.1:1081", "socks5://127.0.0.1:1082"]`, &info)
+	if nodes := info.Chain[0].Nodes; len(nodes) != 2 || nodes[0].ID != 1 || nodes[1].ID != 2 {
+		t.Errorf("wrong nodes %+v", nodes)
+	}
+
+	for _, path := range []string{"/routers/1", "/routers/0/groups/2/nodes", "/routers/0/groups/1/nodes/3"} {
+		if status := request(t, srv, http.MethodDelete, path, "", nil); status != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want 404", path, status)
+		}
+	}
+	if status := request(t, srv, http.MethodPut, "/routers/0/groups/1/nodes", `{"node"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", status)
+	}
+}
+
+func TestAdminPermissions(t *testing.T) {
+	route := &config.Route{ServeNodes: []string{"http://127.0.0.1:0?bypass=*.example.net"}}
+	rts, err := route.GenRouters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &rts[0]
+	go rt.Serve()
+	defer rt.Close()
+
+	srv := httptest.NewServer(&Server{Routers: []*config.Router{rt}})
+	defer srv.Close()
+
+	proxy := func(host string) int {
+		conn, err := net.Dial("tcp", rt.Server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		req, _ := http.NewRequest(http.MethodConnect, "http://"+host, nil)
+		req.Write(conn)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	var info routerInfo
+	request(t, srv, http.MethodPut, "/routers/0/blacklist", "tcp:*.example.com:*", &info)
+	if info.Blacklist != "tcp:*.example.com:*" {
+		t.Errorf("wrong blacklist %s", info.Blacklist)
+	}
+	if status := proxy("www.example.com:80"); status != http.StatusForbidden {
+		t.Errorf("got status %d, want 403", status)
+	}
+
+	request(t, srv, http.MethodPut, "/routers/0/blacklist", "", &info)
+	old := rt.Bypass
+	request(t, srv, http.MethodPut, "/routers/0/bypass", "reverse true\n*.example.org", &info)
+	if info.Bypass == nil || !info.Bypass.Reversed || len(info.Bypass.Matchers) != 1 {
+		t.Errorf("wrong bypass %+v", info.Bypass)
+	}
+	// the old bypass may be shared, it is replaced instead of being changed in place.
+	if rt.Bypass == old || old.Reversed() || !old.Contains("www.example.net") {
+		t.Error("bypass is changed in place")
+	}
+	if status := proxy("www.example.com:80"); status != http.StatusForbidden {
+		t.Errorf("got status %d, want 403", status)
+	}
+
+	if status := request(t, srv, http.MethodPut, "/routers/0/whitelist", "tcp", nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", status)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	srv := httptest.NewServer(&Server{Sessions: gost.NewSessions()})
+	defer srv.Close()
+
+	var sessions []gost.AccessRecord
+	if status := request(t, srv, http.MethodGet, "/sessions", "", &sessions); status != http.StatusOK || sessions == nil {
+		t.Errorf("got status %d, sessions %v", status, sessions)
+	}
+	if status := request(t, srv, http.MethodDelete, "/sessions/1", "", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", status)
+	}
+	if status := request(t, srv, http.MethodPost, "/sessions", "", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", status)
+	}
+}
@@ -2,6 +2,7 @@ package gost
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"strings"
 	"sync"
@@ -13,9 +14,103 @@ type Authenticator interface {
 	Authenticate(user, password string) bool
 }
 
+// AuthRequest is the authentication request with the context of the connection.
+type AuthRequest struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Client   string `json:"client,omitempty"`   // the address of the client
+	Node     string `json:"node,omitempty"`     // the serve node, such as socks5://:1080
+	Protocol string `json:"protocol,omitempty"` // the proxy protocol, such as http, socks5 and socks4
+	Target   string `json:"target,omitempty"`   // the requested target address, it is unknown to SOCKS5 on authentication
+}
+
+// Principal is the authenticated user and its attributes.
+type Principal struct {
+	User string `json:"user,omitempty"`
+	// Chains are the named chains that the rules can route the user to, empty for any.
+	Chains []string `json:"chains,omitempty"`
+	// ReadLimit and WriteLimit are the bandwidth limits of each connection of the user in bytes per second.
+	ReadLimit  int64 `json:"rlimit,omitempty"`
+	WriteLimit int64 `json:"wlimit,omitempty"`
+	// Whitelist and Blacklist are the permissions of the user, they are merged with the permissions of the node.
+	Whitelist *Permissions      `json:"whitelist,omitempty"`
+	Blacklist *Permissions      `json:"blacklist,omitempty"`
+	Attrs     map[string]string `json:"attrs,omitempty"`
+}
+
+// Name returns the name of the user, empty for the anonymous user.
+func (p *Principal) Name() string {
+	if p == nil {
+		return ""
+	}
+	return p.User
+}
+
+// Can tests whether the given action and address is allowed by the permissions of the principal p,
+// the anonymous user is allowed.
+func (p *Principal) Can(action string, addr string) bool {
+	if p == nil {
+		return true
+	}
+	return Can(action, addr, p.Whitelist, p.Blacklist)
+}
+
+// selectContext returns the context to select the route of the principal p from client to host.
+func (p *Principal) selectContext(client, host string) SelectContext {
+	ctx := SelectContext{
+		Client: client,
+		Host:   host,
+	}
+	if p != nil {
+		ctx.User = p.User
+		ctx.Chains = p.Chains
+	}
+	return ctx
+}
+
+// AuthenticatorV2 is an Authenticator that makes decisions from the context of the connection,
+// and returns the principal of the authenticated user.
+type AuthenticatorV2 interface {
+	Authenticator
+	AuthenticateV2(req *AuthRequest) (*Principal, bool)
+}
+
+// authenticate authenticates req by au, AuthenticatorV2 is preferred.
+// The principal of the anonymous user is returned if au is nil.
+func authenticate(au Authenticator, req *AuthRequest) (*Principal, bool) {
+	var p *Principal
+	switch v := au.(type) {
+	case nil:
+		// the user is not verified, so it is not trusted for the limits and quotas.
+		return &Principal{}, true
+	case AuthenticatorV2:
+		var ok bool
+		if p, ok = v.AuthenticateV2(req); !ok {
+			return nil, false
+		}
+	default:
+		if !au.Authenticate(req.User, req.Password) {
+			return nil, false
+		}
+	}
+
+	if p == nil {
+		p = &Principal{}
+	}
+	if p.User == "" {
+		// the principal may be shared, such as the cached one.
+		cp := *p
+		cp.User = req.User
+		p = &cp
+	}
+	return p, true
+}
+
 // LocalAuthenticator is an Authenticator that authenticates client by local key-value pairs.
+// It is an AuthenticatorV2 with the per-user permissions.
 type LocalAuthenticator struct {
 	kvs     map[string]string
+	perms   map[string]*userPermissions
 	period  time.Duration
 	stopped chan struct{}
 	mux     sync.RWMutex
@@ -46,6 +141,68 @@ func (au *LocalAuthenticator) Authenticate(user, password string) bool {
 	return ok && (v == "" || password == v)
 }
 
+// AuthenticateV2 checks the user-password pair of the request, the permissions of the user are returned.
+func (au *LocalAuthenticator) AuthenticateV2(req *AuthRequest) (*Principal, bool) {
+	if !au.Authenticate(req.User, req.Password) {
+		return nil, false
+	}
+	p := &Principal{User: req.User}
+	if au == nil {
+		return p, true
+	}
+
+	au.mux.RLock()
+	defer au.mux.RUnlock()
+
+	if perm := au.perms[req.User]; perm != nil {
+		p.Whitelist, p.Blacklist = perm.whitelist, perm.blacklist
+	}
+	return p, true
+}
+
+type userPermissions struct {
+	whitelist *Permissions
+	blacklist *Permissions
+}
+
+// parseUserPermissions parses the permission options of a user, such as whitelist=tcp:*:443.
+// The options can be repeated, the rules are joined.
+func parseUserPermissions(opts []string) (*userPermissions, error) {
+	var whitelist, blacklist []string
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "#") { // the trailing comment
+			break
+		}
+		switch {
+		case strings.HasPrefix(opt, "whitelist="):
+			whitelist = append(whitelist, strings.TrimPrefix(opt, "whitelist="))
+		case strings.HasPrefix(opt, "blacklist="):
+			blacklist = append(blacklist, strings.TrimPrefix(opt, "blacklist="))
+		default:
+			return nil, fmt.Errorf("unknown option %s", opt)
+		}
+	}
+
+	perm := &userPermissions{}
+	var err error
+	if len(whitelist) > 0 {
+		if perm.whitelist, err = ParsePermissions(strings.Join(whitelist, " ")); err != nil {
+			return nil, fmt.Errorf("whitelist: %v", err)
+		}
+	}
+	if len(blacklist) > 0 {
+		if perm.blacklist, err = ParsePermissions(strings.Join(blacklist, " ")); err != nil {
+			return nil, fmt.Errorf("blacklist: %v", err)
+		}
+	}
+	return perm, nil
+}
+
+// IsUserOption checks whether s is an option of the user in the secrets file, rather than the password.
+func IsUserOption(s string) bool {
+	return strings.HasPrefix(s, "whitelist=") || strings.HasPrefix(s, "blacklist=")
+}
+
 // Add adds a key-value pair to the Authenticator.
 func (au *LocalAuthenticator) Add(k, v string) {
 	au.mux.Lock()
@@ -57,9 +214,13 @@ func (au *LocalAuthenticator) Add(k, v string) {
 }
 
 // Reload parses config from r, then live reloads the Authenticator.
+// Each line is a user, its optional password and the optional permissions in the format of ParsePermissions:
+//
+//	admin 123456 whitelist=tcp:*.example.com:443 whitelist=udp:*:53 blacklist=tcp:*:25
 func (au *LocalAuthenticator) Reload(r io.Reader) error {
 	var period time.Duration
 	kvs := make(map[string]string)
+	perms := make(map[string]*userPermissions)
 
 	if r == nil || au.Stopped() {
 		return nil
@@ -102,11 +263,18 @@ func (au *LocalAuthenticator) Reload(r io.Reader) error {
 			}
 		default:
 			var k, v string
-			k = ss[0]
-			if len(ss) > 1 {
-				v = ss[1]
+			k, ss = ss[0], ss[1:]
+			if len(ss) > 0 && !IsUserOption(ss[0]) {
+				v, ss = ss[0], ss[1:]
 			}
 			kvs[k] = v
+			if len(ss) > 0 {
+				perm, err := parseUserPermissions(ss)
+				if err != nil {
+					return fmt.Errorf("%s: %v", k, err)
+				}
+				perms[k] = perm
+			}
 		}
 	}
 
@@ -119,6 +287,7 @@ func (au *LocalAuthenticator) Reload(r io.Reader) error {
 
 	au.period = period
 	au.kvs = kvs
+	au.perms = perms
 
 	return nil
 }
@@ -2,11 +2,17 @@ package gost
 
 import (
 	"bytes"
+	"crypto/rand"
 	"fmt"
 	"io"
+	"net"
+	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/ginuerzh/gosocks4"
 )
 
 var localAuthenticatorTests = []struct {
@@ -189,3 +195,270 @@ func TestLocalAuthenticatorReload(t *testing.T) {
 		})
 	}
 }
+
+// testAuthenticatorV2 accepts the users in the allowed map and records the requests.
+type testAuthenticatorV2 struct {
+	allowed map[string]*Principal
+	reqs    []AuthRequest
+	mux     sync.Mutex
+}
+
+func (au *testAuthenticatorV2) Authenticate(user, password string) bool {
+	_, ok := au.AuthenticateV2(&AuthRequest{User: user, Password: password})
+	return ok
+}
+
+func (au *testAuthenticatorV2) AuthenticateV2(req *AuthRequest) (*Principal, bool) {
+	au.mux.Lock()
+	defer au.mux.Unlock()
+	au.reqs = append(au.reqs, *req)
+	p, ok := au.allowed[req.User]
+	return p, ok
+}
+
+func (au *testAuthenticatorV2) last() AuthRequest {
+	au.mux.Lock()
+	defer au.mux.Unlock()
+	if len(au.reqs) == 0 {
+		return AuthRequest{}
+	}
+	return au.reqs[len(au.reqs)-1]
+}
+
+func TestAuthenticate(t *testing.T) {
+	shared := &Principal{Chains: []string{"us"}}
+	v2 := &testAuthenticatorV2{allowed: map[string]*Principal{"admin": shared, "nil": nil}}
+
+	tests := []struct {
+		au    Authenticator
+		user  string
+		ok    bool
+		name  string
+		chain int
+	}{
+		{nil, "", true, "", 0},
+		{nil, "admin", true, "", 0}, // the user is not verified
+		{NewLocalAuthenticator(map[string]string{"admin": "123456"}), "admin", true, "admin", 0},
+		{NewLocalAuthenticator(map[string]string{"admin": "654321"}), "admin", false, "", 0},
+		{v2, "admin", true, "admin", 1},
+		{v2, "nil", true, "nil", 0},
+		{v2, "test", false, "", 0},
+	}
+	for i, tc := range tests {
+		p, ok := authenticate(tc.au, &AuthRequest{User: tc.user, Password: "123456"})
+		if ok != tc.ok {
+			t.Errorf("#%d test failed: authenticated %v, want %v", i, ok, tc.ok)
+			continue
+		}
+		if p.Name() != tc.name || (p != nil && len(p.Chains) != tc.chain) {
+			t.Errorf("#%d test failed: got principal %+v", i, p)
+		}
+	}
+	if shared.User != "" {
+		t.Error("the principal returned by the authenticator is modified")
+	}
+}
+
+func TestHandlerAuthenticatorV2(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+	target, _ := url.Parse(httpSrv.URL)
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	au := &testAuthenticatorV2{allowed: map[string]*Principal{
+		"admin": {ReadLimit: 1024 * 1024},
+	}}
+	tests := []struct {
+		protocol  string
+		connector Connector
+		handler   Handler
+		target    string
+		ok        bool
+	}{
+		{"http", HTTPConnector(url.UserPassword("admin", "123456")), HTTPHandler(AuthenticatorHandlerOption(au)), target.Host, true},
+		{"http", HTTPConnector(url.UserPassword("test", "123456")), HTTPHandler(AuthenticatorHandlerOption(au)), target.Host, false},
+		{"socks5", SOCKS5Connector(url.UserPassword("admin", "123456")), SOCKS5Handler(AuthenticatorHandlerOption(au)), "", true},
+		{"socks5", SOCKS5Connector(url.UserPassword("test", "123456")), SOCKS5Handler(AuthenticatorHandlerOption(au)), "", false},
+	}
+	for i, tc := range tests {
+		ln, err := TCPListener("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &Client{Connector: tc.connector, Transporter: TCPTransporter()}
+		server := &Server{Listener: ln, Handler: tc.handler}
+		go server.Run()
+
+		err = proxyRoundtrip(client, server, httpSrv.URL, sendData)
+		server.Close()
+		if (err == nil) != tc.ok {
+			t.Errorf("#%d test failed: %v", i, err)
+		}
+
+		req := au.last()
+		if req.Protocol != tc.protocol || req.Target != tc.target || req.Client == "" {
+			t.Errorf("#%d test failed: got request %+v", i, req)
+		}
+	}
+}
+
+func TestSOCKS4UserID(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	// SOCKS4 is refused by any authenticator, as the user ID carries no password.
+	local := NewLocalAuthenticator(map[string]string{"admin": "", "test": "123456"})
+	htpasswd := NewHTPasswdAuthenticator()
+	v2 := &testAuthenticatorV2{allowed: map[string]*Principal{"admin": {}}}
+
+	u, _ := url.Parse(httpSrv.URL)
+	addr, _ := net.ResolveTCPAddr("tcp", u.Host)
+	for i, tc := range []struct {
+		au     Authenticator
+		userid string
+		code   uint8
+	}{
+		{nil, "admin", gosocks4.Granted},
+		{nil, "", gosocks4.Granted},
+		{local, "admin", gosocks4.RejectedUserid},
+		{local, "test", gosocks4.RejectedUserid},
+		{htpasswd, "admin", gosocks4.RejectedUserid},
+		{htpasswd, "", gosocks4.RejectedUserid},
+		{v2, "admin", gosocks4.RejectedUserid},
+	} {
+		ln, err := TCPListener("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &Server{Listener: ln, Handler: SOCKS4Handler(AuthenticatorHandlerOption(tc.au))}
+		go server.Run()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := gosocks4.NewRequest(gosocks4.CmdConnect,
+			&gosocks4.Addr{Type: gosocks4.AddrIPv4, Host: addr.IP.String(), Port: uint16(addr.Port)},
+			[]byte(tc.userid))
+		if err := req.Write(conn); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := gosocks4.ReadReply(conn)
+		conn.Close()
+		server.Close()
+		if err != nil {
+			t.Errorf("#%d test failed: %v", i, err)
+			continue
+		}
+		if reply.Code != tc.code {
+			t.Errorf("#%d test failed: got reply %d, want %d", i, reply.Code, tc.code)
+		}
+	}
+	if req := v2.last(); req.Protocol != "" {
+		t.Errorf("the authenticator is consulted: %+v", req)
+	}
+}
+
+func TestLocalAuthenticatorPermissions(t *testing.T) {
+	au := NewLocalAuthenticator(nil)
+	err := au.Reload(bytes.NewBufferString(`
+	admin 123456 whitelist=tcp:*.example.com:443 whitelist=udp:*:53
+	guest blacklist=tcp:*:25 # no password
+	test 123456 # comment
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user, password string
+		action, addr   string
+		ok, can        bool
+	}{
+		{"admin", "123456", "tcp", "www.example.com:443", true, true},
+		{"admin", "123456", "udp", "8.8.8.8:53", true, true},
+		{"admin", "123456", "tcp", "www.example.com:80", true, false},
+		{"admin", "654321", "tcp", "www.example.com:443", false, false},
+		{"guest", "", "tcp", "mail.example.com:25", true, false},
+		{"guest", "any", "tcp", "www.example.com:80", true, true},
+		{"test", "123456", "tcp", "mail.example.com:25", true, true},
+	}
+	for i, tc := range tests {
+		p, ok := au.AuthenticateV2(&AuthRequest{User: tc.user, Password: tc.password})
+		if ok != tc.ok {
+			t.Errorf("#%d test failed: authenticated %v, want %v", i, ok, tc.ok)
+			continue
+		}
+		if ok && p.Can(tc.action, tc.addr) != tc.can {
+			t.Errorf("#%d test failed: %s %s allowed %v, want %v", i, tc.action, tc.addr, !tc.can, tc.can)
+		}
+	}
+
+	for _, s := range []string{"admin 123456 whitelist=tcp:*", "admin 123456 allow=tcp:*:*"} {
+		if err := au.Reload(bytes.NewBufferString(s)); err == nil {
+			t.Errorf("invalid permissions %s are loaded", s)
+		}
+	}
+	// the last valid config is kept
+	if !au.Authenticate("guest", "") {
+		t.Error("the config is changed by the invalid config")
+	}
+}
+
+func TestUserPermissionsProxy(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	au := NewLocalAuthenticator(nil)
+	au.Reload(bytes.NewBufferString(`
+	admin 123456 whitelist=tcp:127.0.0.1:*
+	test 123456 blacklist=tcp:127.0.0.1:*
+	`))
+	nodeBlacklist, _ := ParsePermissions("tcp:localhost:*")
+	nodeWhitelist, _ := ParsePermissions("tcp:*:1-1024")
+
+	tests := []struct {
+		user      string
+		whitelist *Permissions
+		ok        bool
+	}{
+		{"admin", nil, true},
+		{"test", nil, false},
+		{"admin", nodeWhitelist, false}, // merged with the node whitelist
+	}
+	for i, tc := range tests {
+		for _, proto := range []string{"http", "socks5"} {
+			opts := []HandlerOption{
+				AuthenticatorHandlerOption(au),
+				WhitelistHandlerOption(tc.whitelist),
+				BlacklistHandlerOption(nodeBlacklist),
+			}
+			client := &Client{Transporter: TCPTransporter()}
+			var handler Handler
+			if proto == "http" {
+				client.Connector = HTTPConnector(url.UserPassword(tc.user, "123456"))
+				handler = HTTPHandler(opts...)
+			} else {
+				client.Connector = SOCKS5Connector(url.UserPassword(tc.user, "123456"))
+				handler = SOCKS5Handler(opts...)
+			}
+
+			ln, err := TCPListener("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := &Server{Listener: ln, Handler: handler}
+			go server.Run()
+			err = proxyRoundtrip(client, server, httpSrv.URL, sendData)
+			server.Close()
+			if (err == nil) != tc.ok {
+				t.Errorf("#%d %s test failed: %v", i, proto, err)
+			}
+		}
+	}
+}
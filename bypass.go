@@ -86,6 +86,7 @@ func (m *cidrMatcher) String() string {
 type domainMatcher struct {
 	pattern string
 	glob    glob.Glob
+	suffix  bool // the special wildcard '.example.com'
 }
 
 // DomainMatcher creates a Matcher for a specific domain pattern,
@@ -93,13 +94,15 @@ type domainMatcher struct {
 // a wildcard such as '*.exmaple.com' or a special wildcard '.example.com'.
 func DomainMatcher(pattern string) Matcher {
 	p := pattern
-	if strings.HasPrefix(pattern, ".") {
+	suffix := strings.HasPrefix(pattern, ".")
+	if suffix {
 		p = pattern[1:] // trim the prefix '.'
 		pattern = "*" + p
 	}
 	return &domainMatcher{
 		pattern: p,
 		glob:    glob.MustCompile(pattern),
+		suffix:  suffix,
 	}
 }
 
@@ -119,9 +122,10 @@ func (m *domainMatcher) String() string {
 }
 
 // Bypass is a filter for address (IP or domain).
-// It contains a list of matchers.
+// It contains a list of matchers, which are indexed for the large lists.
 type Bypass struct {
 	matchers []Matcher
+	index    *bypassIndex
 	period   time.Duration // the period for live reloading
 	reversed bool
 	stopped  chan struct{}
@@ -133,6 +137,7 @@ type Bypass struct {
 func NewBypass(reversed bool, matchers ...Matcher) *Bypass {
 	return &Bypass{
 		matchers: matchers,
+		index:    newBypassIndex(matchers...),
 		reversed: reversed,
 		stopped:  make(chan struct{}),
 	}
@@ -172,16 +177,7 @@ func (bp *Bypass) Contains(addr string) bool {
 		return false
 	}
 
-	var matched bool
-	for _, matcher := range bp.matchers {
-		if matcher == nil {
-			continue
-		}
-		if matcher.Match(addr) {
-			matched = true
-			break
-		}
-	}
+	matched := bp.index.match(addr)
 	return !bp.reversed && matched ||
 		bp.reversed && !matched
 }
@@ -192,6 +188,10 @@ func (bp *Bypass) AddMatchers(matchers ...Matcher) {
 	defer bp.mux.Unlock()
 
 	bp.matchers = append(bp.matchers, matchers...)
+	if bp.index == nil {
+		bp.index = newBypassIndex()
+	}
+	bp.index.add(matchers...)
 }
 
 // Matchers return the bypass matcher list.
@@ -244,11 +244,14 @@ func (bp *Bypass) Reload(r io.Reader) error {
 	if err := scanner.Err(); err != nil {
 		return err
 	}
+	// the index is built before locking, so the large lists do not block the lookups.
+	index := newBypassIndex(matchers...)
 
 	bp.mux.Lock()
 	defer bp.mux.Unlock()
 
 	bp.matchers = matchers
+	bp.index = index
 	bp.period = period
 	bp.reversed = reversed
 
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 	"time"
 )
@@ -47,6 +48,14 @@ var bypassContainTests = []struct {
 	{[]string{"192.168.1.1/32"}, true, "192.168.1.1", false},
 	{[]string{"192.168.1.1/32"}, false, "192.168.1.2", false},
 	{[]string{"192.168.1.1/32"}, true, "192.168.1.2", true},
+	{[]string{"192.168.1.0/24", "192.168.0.0/16"}, false, "192.168.2.1", true},
+	{[]string{"192.168.1.0/24", "192.168.1.128/25"}, false, "192.168.1.200", true},
+	{[]string{"192.168.1.0/25", "192.168.1.192/26"}, false, "192.168.1.130", false},
+	{[]string{"192.168.1.0/24"}, false, "::ffff:192.168.1.1", true},
+	{[]string{"fd00::/8", "2001:db8::/32"}, false, "2001:db8::1", true},
+	{[]string{"fd00::/8", "2001:db8::/32"}, false, "2001:db9::1", false},
+	{[]string{"fd00::/8"}, false, "[fd12::1]:80", true},
+	{[]string{"::/0"}, false, "192.168.1.1", false},
 
 	// plain domain
 	{[]string{"www.example.com"}, false, "www.example.com", true},
@@ -139,6 +148,8 @@ var bypassContainTests = []struct {
 	{[]string{".example.com"}, false, "www.example.com", true},
 	{[]string{".example.com"}, false, "example.com", true},
 	{[]string{".example.com"}, false, "www.example.com.cn", false},
+	{[]string{".example.com"}, false, "badexample.com", true},
+	{[]string{".example.com"}, false, "http://www.example.com", true},
 
 	{[]string{"example.com*"}, false, "example.com", true},
 	{[]string{"example.com:*"}, false, "example.com", false},
@@ -301,3 +312,97 @@ func TestByapssReload(t *testing.T) {
 		}
 	}
 }
+
+// linearContains is the plain scan of the matchers, the baseline of the bypass index.
+func linearContains(matchers []Matcher, addr string) bool {
+	for _, m := range matchers {
+		if m != nil && m.Match(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBypassIndex(t *testing.T) {
+	patterns := []string{
+		"192.168.1.1", "::1", "10.0.0.0/8", "10.1.0.0/16", "172.16.0.0/12", "fd00::/8", "2001:db8::/48",
+		"example.com", ".example.org", "*.example.net", "*.a.example.net", "www.*.com", "**.example.io", "*",
+	}
+	addrs := []string{
+		"192.168.1.1", "192.168.1.2", "::1", "::2", "10.255.0.1", "11.0.0.1", "172.31.255.255", "172.32.0.0",
+		"fd00::1", "fe00::1", "2001:db8::1", "2001:db8:1::1", "::ffff:10.0.0.1",
+		"example.com", "www.example.com", "example.org", "a.b.example.org", "badexample.org",
+		"example.net", "www.example.net", "a.example.net", "www.example.io", "example.io", "", "example.org.cn",
+		"http://www.example.org", "org",
+	}
+
+	for n := 1; n <= len(patterns); n++ {
+		var matchers []Matcher
+		for _, p := range patterns[:n] {
+			matchers = append(matchers, NewMatcher(p))
+		}
+		idx := newBypassIndex(matchers...)
+		for _, addr := range addrs {
+			if idx.match(addr) != linearContains(matchers, addr) {
+				t.Errorf("%v: %s: got %v", patterns[:n], addr, idx.match(addr))
+			}
+		}
+	}
+}
+
+func genBypassPatterns(n int) []string {
+	patterns := make([]string, 0, n)
+	for i := 0; len(patterns) < n; i++ {
+		switch i % 4 {
+		case 0:
+			patterns = append(patterns, fmt.Sprintf("ads%d.example.com", i))
+		case 1:
+			patterns = append(patterns, fmt.Sprintf(".tracker%d.com", i))
+		case 2:
+			patterns = append(patterns, fmt.Sprintf("10.%d.%d.0/24", i>>8&0xff, i&0xff))
+		case 3:
+			patterns = append(patterns, fmt.Sprintf("172.%d.%d.%d", 16+i>>16&0xf, i>>8&0xff, i&0xff))
+		}
+	}
+	return patterns
+}
+
+func benchmarkBypass(b *testing.B, n int, contains func(bp *Bypass, addr string) bool) {
+	bp := NewBypassPatterns(false, genBypassPatterns(n)...)
+	addrs := []string{
+		"www.google.com:443", "cdn.tracker1.com", "ads4.example.com", "10.0.2.1", "192.168.1.1:80", "172.16.0.3",
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		contains(bp, addrs[i%len(addrs)])
+	}
+}
+
+func BenchmarkBypassContains(b *testing.B) {
+	indexed := func(bp *Bypass, addr string) bool {
+		return bp.Contains(addr)
+	}
+	linear := func(bp *Bypass, addr string) bool {
+		return linearContains(bp.Matchers(), addr)
+	}
+	for _, n := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("index-%dk", n/1000), func(b *testing.B) {
+			benchmarkBypass(b, n, indexed)
+		})
+		b.Run(fmt.Sprintf("linear-%dk", n/1000), func(b *testing.B) {
+			benchmarkBypass(b, n, linear)
+		})
+	}
+}
+
+func BenchmarkBypassReload(b *testing.B) {
+	for _, n := range []int{1000, 100000} {
+		data := []byte(strings.Join(genBypassPatterns(n), "\n"))
+		b.Run(fmt.Sprintf("%dk", n/1000), func(b *testing.B) {
+			bp := NewBypass(false)
+			for i := 0; i < b.N; i++ {
+				bp.Reload(bytes.NewReader(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package gost
+
+import (
+	"math/bits"
+	"net"
+	"strings"
+)
+
+// bypassIndex indexes the matchers of a Bypass, so the large lists can be matched
+// without scanning every matcher:
+// the IP matchers are kept in a hash set, the CIDR matchers in the radix trees,
+// the plain domains and *.example.com domains in a reversed-label suffix trie,
+// and the special wildcards .example.com, which match any name ending with example.com
+// like the glob *example.com, in a reversed-character suffix trie.
+// The other matchers, such as the glob patterns, are matched one by one.
+type bypassIndex struct {
+	ips      map[[net.IPv6len]byte]struct{}
+	cidrs4   *cidrNode
+	cidrs6   *cidrNode
+	domains  *domainNode
+	suffixes *suffixNode
+	others   []Matcher
+}
+
+func newBypassIndex(matchers ...Matcher) *bypassIndex {
+	idx := &bypassIndex{
+		ips:      make(map[[net.IPv6len]byte]struct{}),
+		domains:  &domainNode{},
+		suffixes: &suffixNode{},
+	}
+	idx.add(matchers...)
+	return idx
+}
+
+func (idx *bypassIndex) add(matchers ...Matcher) {
+	for _, m := range matchers {
+		switch m := m.(type) {
+		case nil:
+		case *ipMatcher:
+			if m == nil || m.ip.To16() == nil {
+				continue
+			}
+			idx.ips[ipKey(m.ip)] = struct{}{}
+		case *cidrMatcher:
+			if m == nil || m.ipNet == nil {
+				continue
+			}
+			if !idx.addCIDR(m.ipNet) {
+				idx.others = append(idx.others, m)
+			}
+		case *domainMatcher:
+			if m == nil || !idx.addDomain(m) {
+				idx.others = append(idx.others, m)
+			}
+		default:
+			idx.others = append(idx.others, m)
+		}
+	}
+}
+
+func (idx *bypassIndex) addCIDR(ipNet *net.IPNet) bool {
+	// the same as the IPNet.Contains, the IPv4 networks match the IPv4-mapped IPv6 addresses.
+	ip, mask := ipNet.IP.To4(), ipNet.Mask
+	if ip == nil {
+		ip = ipNet.IP.To16()
+	}
+	if len(ip) == net.IPv4len && len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	ones, n := mask.Size()
+	if ip == nil || n != len(ip)*8 {
+		return false
+	}
+
+	var key [net.IPv6len]byte
+	copy(key[:], ip.Mask(mask))
+	if len(ip) == net.IPv4len {
+		cidrInsert(&idx.cidrs4, key, ones)
+	} else {
+		cidrInsert(&idx.cidrs6, key, ones)
+	}
+	return true
+}
+
+func (idx *bypassIndex) addDomain(m *domainMatcher) bool {
+	switch {
+	case m.suffix && isPlainDomain(m.pattern):
+		idx.suffixes.insert(m.pattern)
+	case m.suffix:
+		return false
+	case strings.HasPrefix(m.pattern, "*.") && isPlainDomain(m.pattern[2:]):
+		idx.domains.insert(m.pattern[2:], false, true)
+	case isPlainDomain(m.pattern):
+		idx.domains.insert(m.pattern, true, false)
+	default:
+		return false
+	}
+	return true
+}
+
+// isPlainDomain checks whether the domain pattern s has no glob wildcards.
+func isPlainDomain(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "*?[]{}\\")
+}
+
+func (idx *bypassIndex) match(addr string) bool {
+	if ip := net.ParseIP(addr); ip != nil {
+		if _, ok := idx.ips[ipKey(ip)]; ok {
+			return true
+		}
+		var key [net.IPv6len]byte
+		if ip4 := ip.To4(); ip4 != nil {
+			copy(key[:], ip4)
+			if cidrLookup(idx.cidrs4, key, 32) {
+				return true
+			}
+		} else {
+			copy(key[:], ip)
+			if cidrLookup(idx.cidrs6, key, 128) {
+				return true
+			}
+		}
+	}
+	if idx.domains.lookup(addr) || idx.suffixes.lookup(addr) {
+		return true
+	}
+	for _, m := range idx.others {
+		if m.Match(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipKey(ip net.IP) (key [net.IPv6len]byte) {
+	copy(key[:], ip.To16())
+	return
+}
+
+// cidrNode is a node of the path-compressed binary radix tree of the CIDRs,
+// the prefix is the first bits of the key.
+type cidrNode struct {
+	key   [net.IPv6len]byte
+	bits  int
+	leaf  bool // the prefix is a CIDR in the tree
+	child [2]*cidrNode
+}
+
+func cidrInsert(np **cidrNode, key [net.IPv6len]byte, n int) {
+	for {
+		node := *np
+		if node == nil {
+			*np = &cidrNode{key: key, bits: n, leaf: true}
+			return
+		}
+
+		c := commonBits(node.key, key, minint(node.bits, n))
+		if c < node.bits {
+			// split the node at the common prefix.
+			parent := &cidrNode{key: maskBits(key, c), bits: c}
+			parent.child[bitAt(node.key, c)] = node
+			if c == n {
+				parent.leaf = true
+			} else {
+				parent.child[bitAt(key, c)] = &cidrNode{key: key, bits: n, leaf: true}
+			}
+			*np = parent
+			return
+		}
+		if node.bits == n {
+			node.leaf = true
+			return
+		}
+		np = &node.child[bitAt(key, node.bits)]
+	}
+}
+
+func cidrLookup(node *cidrNode, key [net.IPv6len]byte, n int) bool {
+	for node != nil {
+		if node.bits > n || commonBits(node.key, key, node.bits) < node.bits {
+			return false
+		}
+		if node.leaf {
+			return true
+		}
+		if node.bits == n {
+			return false
+		}
+		node = node.child[bitAt(key, node.bits)]
+	}
+	return false
+}
+
+// commonBits returns the length of the common prefix of a and b, up to n bits.
+func commonBits(a, b [net.IPv6len]byte, n int) int {
+	for i := 0; i < net.IPv6len && i*8 < n; i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			return minint(i*8+bits.LeadingZeros8(x), n)
+		}
+	}
+	return n
+}
+
+func bitAt(key [net.IPv6len]byte, i int) byte {
+	return key[i/8] >> (7 - uint(i%8)) & 1
+}
+
+func maskBits(key [net.IPv6len]byte, n int) (masked [net.IPv6len]byte) {
+	for i := 0; i < net.IPv6len && i*8 < n; i++ {
+		masked[i] = key[i]
+		if r := n - i*8; r < 8 {
+			masked[i] &= ^byte(0xff >> uint(r))
+		}
+	}
+	return
+}
+
+// domainNode is a node of the suffix trie of the domains by the reversed labels,
+// such as com -> example -> www for www.example.com.
+type domainNode struct {
+	exact    bool // the domain itself is matched
+	sub      bool // the subdomains are matched
+	children map[string]*domainNode
+}
+
+func (node *domainNode) insert(domain string, exact, sub bool) {
+	for {
+		label := domain
+		n := strings.LastIndexByte(domain, '.')
+		if n >= 0 {
+			label = domain[n+1:]
+		}
+
+		child := node.children[label]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*domainNode)
+			}
+			child = &domainNode{}
+			node.children[label] = child
+		}
+		node = child
+
+		if n < 0 {
+			node.exact = node.exact || exact
+			node.sub = node.sub || sub
+			return
+		}
+		domain = domain[:n]
+	}
+}
+
+func (node *domainNode) lookup(domain string) bool {
+	for node != nil {
+		label := domain
+		n := strings.LastIndexByte(domain, '.')
+		if n >= 0 {
+			label = domain[n+1:]
+		}
+
+		node = node.children[label]
+		if node == nil {
+			return false
+		}
+		if n < 0 {
+			return node.exact
+		}
+		if node.sub {
+			return true
+		}
+		domain = domain[:n]
+	}
+	return false
+}
+
+// suffixNode is a node of the suffix trie of the domains by the reversed characters,
+// such as m -> o -> c -> . -> e ... for example.com, which is matched by any name ending with it.
+type suffixNode struct {
+	end      bool // a domain ends at this node
+	children map[byte]*suffixNode
+}
+
+func (node *suffixNode) insert(domain string) {
+	for i := len(domain) - 1; i >= 0; i-- {
+		child := node.children[domain[i]]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[byte]*suffixNode)
+			}
+			child = &suffixNode{}
+			node.children[domain[i]] = child
+		}
+		node = child
+	}
+	node.end = true
+}
+
+func (node *suffixNode) lookup(s string) bool {
+	for i := len(s) - 1; node != nil; i-- {
+		if node.end {
+			return true
+		}
+		if i < 0 {
+			return false
+		}
+		node = node.children[s[i]]
+	}
+	return false
+}
@@ -4,9 +4,8 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
 	"time"
-
-	"github.com/go-log/log"
 )
 
 var (
@@ -18,8 +17,10 @@ var (
 type Chain struct {
 	isRoute    bool
 	Retries    int
+	Rules      *Rules // routing rules, consulted before the node selection
 	nodeGroups []*NodeGroup
-	route      []Node // nodes in the selected route
+	route      []Node     // nodes in the selected route
+	raceGroup  *NodeGroup // group of the first node in the selected route, for racing its candidates
 }
 
 // NewChain creates a proxy chain with a list of proxy nodes.
@@ -53,6 +54,9 @@ func (c *Chain) Nodes() (nodes []Node) {
 
 // NodeGroups returns the list of node group.
 func (c *Chain) NodeGroups() []*NodeGroup {
+	if c == nil {
+		return nil
+	}
 	return c.nodeGroups
 }
 
@@ -140,10 +144,11 @@ func (c *Chain) dialWithOptions(ctx context.Context, network, address string, op
 		return nil, err
 	}
 
-	ipAddr := address
+	ipAddrs := []string{address}
 	if address != "" {
-		ipAddr = c.resolve(address, options.Resolver, options.Hosts)
+		ipAddrs = c.resolveAll(address, options.Resolver, options.Hosts, options.logger())
 	}
+	ipAddr := ipAddrs[0]
 
 	timeout := options.Timeout
 	if timeout <= 0 {
@@ -162,42 +167,54 @@ func (c *Chain) dialWithOptions(ctx context.Context, network, address string, op
 			Timeout: timeout,
 			// LocalAddr: laddr, // TODO: optional local address
 		}
+		if options.Race > 1 && len(ipAddrs) > 1 {
+			addrs := interleaveAddrs(ipAddrs)
+			if len(addrs) > options.Race {
+				addrs = addrs[:options.Race]
+			}
+			conn, _, err := raceDial(ctx, len(addrs), options.RaceDelay, func(i int) (net.Conn, error) {
+				return d.DialContext(ctx, network, addrs[i])
+			})
+			return conn, err
+		}
 		return d.DialContext(ctx, network, ipAddr)
 	}
 
-	conn, err := route.getConn(ctx)
+	cc, err := route.getConnFor(ctx, options, func(conn net.Conn) (net.Conn, error) {
+		cOpts := append([]ConnectOption{AddrConnectOption(address)}, route.LastNode().ConnectOptions...)
+		return route.LastNode().Client.ConnectContext(ctx, conn, network, ipAddr, cOpts...)
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	cOpts := append([]ConnectOption{AddrConnectOption(address)}, route.LastNode().ConnectOptions...)
-	cc, err := route.LastNode().Client.ConnectContext(ctx, conn, network, ipAddr, cOpts...)
-	if err != nil {
-		conn.Close()
-		return nil, err
-	}
-	return cc, nil
+	return route.trackConn(cc), nil
 }
 
-func (*Chain) resolve(addr string, resolver Resolver, hosts *Hosts) string {
+// resolveAll resolves the host of the address addr to all its IP addresses,
+// the addr itself is returned if it is an IP address or can not be resolved.
+func (*Chain) resolveAll(addr string, resolver Resolver, hosts *Hosts, logger LeveledLogger) []string {
 	host, port, err := net.SplitHostPort(addr)
-	if err != nil {
-		return addr
+	if err != nil || net.ParseIP(host) != nil {
+		return []string{addr}
 	}
 
 	if ip := hosts.Lookup(host); ip != nil {
-		return net.JoinHostPort(ip.String(), port)
+		return []string{net.JoinHostPort(ip.String(), port)}
 	}
 	if resolver != nil {
 		ips, err := resolver.Resolve(host)
 		if err != nil {
-			log.Logf("[resolver] %s: %v", host, err)
+			logger.Errorf("[resolver] %s: %v", host, err)
 		}
 		if len(ips) > 0 {
-			return net.JoinHostPort(ips[0].String(), port)
+			addrs := make([]string, 0, len(ips))
+			for _, ip := range ips {
+				addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+			}
+			return addrs
 		}
 	}
-	return addr
+	return []string{addr}
 }
 
 // Conn obtains a handshaked connection to the last node of the chain.
@@ -223,8 +240,9 @@ func (c *Chain) Conn(opts ...ChainOption) (conn net.Conn, err error) {
 		if err != nil {
 			continue
 		}
-		conn, err = route.getConn(ctx)
+		conn, err = route.getConn(ctx, options)
 		if err == nil {
+			conn = route.trackConn(conn)
 			break
 		}
 	}
@@ -232,50 +250,140 @@ func (c *Chain) Conn(opts ...ChainOption) (conn net.Conn, err error) {
 }
 
 // getConn obtains a connection to the last node of the chain.
-func (c *Chain) getConn(ctx context.Context) (conn net.Conn, err error) {
+func (c *Chain) getConn(ctx context.Context, options *ChainOptions) (net.Conn, error) {
+	return c.getConnFor(ctx, options, nil)
+}
+
+// getConnFor obtains a connection to the last node of the chain, then applies the connect, if any, to it.
+// The connection to the first node is taken from its pool if there is a warm one,
+// otherwise if racing is enabled by the options, it is raced with the candidates of the node.
+// The pooled connection closed by the peer while idle is dropped by the pool, but it may still fail,
+// then it is discarded and retried once with a fresh dial, the nodes are not marked dead for it.
+func (c *Chain) getConnFor(ctx context.Context, options *ChainOptions, connect func(net.Conn) (net.Conn, error)) (conn net.Conn, err error) {
 	if c.IsEmpty() {
 		err = ErrEmptyChain
 		return
 	}
+	if options == nil {
+		options = &ChainOptions{}
+	}
 	nodes := c.Nodes()
 	node := nodes[0]
 
-	cn, err := node.Client.Dial(node.Addr, node.DialOptions...)
-	if err != nil {
-		node.MarkDead()
-		return
+	if cn := node.Pool.get(node); cn != nil {
+		if conn, err = connectNodes(ctx, cn, node, nodes[1:], connect, false); err == nil {
+			return
+		}
+		options.logger().Debugf("[chain] %s: pooled connection: %v, retry", node.String(), err)
 	}
 
-	cn, err = node.Client.Handshake(cn, node.HandshakeOptions...)
-	if err != nil {
-		node.MarkDead()
+	var cn net.Conn
+	if candidates := c.raceGroup.candidates(node, options.Race); len(candidates) > 1 {
+		var i int
+		cn, i, err = raceDial(ctx, len(candidates), options.RaceDelay, func(i int) (net.Conn, error) {
+			return candidates[i].handshake()
+		})
+		if err != nil {
+			return
+		}
+		node = candidates[i]
+		if len(c.route) > 0 {
+			c.route[0] = node
+		}
+	} else if cn, err = node.handshake(); err != nil {
 		return
 	}
-	node.ResetDead()
 
-	preNode := node
-	for _, node := range nodes[1:] {
-		var cc net.Conn
-		cc, err = preNode.Client.ConnectContext(ctx, cn, "tcp", node.Addr, preNode.ConnectOptions...)
-		if err != nil {
-			cn.Close()
-			node.MarkDead()
-			return
+	return connectNodes(ctx, cn, node, nodes[1:], connect, true)
+}
+
+// connectNodes connects through the connection cn to the first node, to the rest nodes one by one,
+// then applies the connect, if any, to the connection to the last node.
+// The nodes failed are marked dead if markDead is true. The cn is closed if it fails.
+func connectNodes(ctx context.Context, cn net.Conn, first Node, nodes []Node,
+	connect func(net.Conn) (net.Conn, error), markDead bool) (net.Conn, error) {
+	preNode := first
+	for _, node := range nodes {
+		start := time.Now()
+
+		cc, err := preNode.Client.ConnectContext(ctx, cn, "tcp", node.Addr, preNode.ConnectOptions...)
+		if err == nil {
+			cc, err = node.Client.Handshake(cc, node.HandshakeOptions...)
 		}
-		cc, err = node.Client.Handshake(cc, node.HandshakeOptions...)
 		if err != nil {
 			cn.Close()
-			node.MarkDead()
-			return
+			if markDead {
+				node.MarkDead()
+			}
+			return nil, err
 		}
 		node.ResetDead()
+		rtt := time.Since(start)
+		node.stats.observeLatency(rtt)
+		DefaultMetrics.dialObserved(node, rtt)
 
 		cn = cc
 		preNode = node
 	}
 
-	conn = cn
-	return
+	if connect == nil {
+		return cn, nil
+	}
+	cc, err := connect(cn)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// handshake dials and handshakes with the node, the node status is updated by the result.
+func (node *Node) handshake() (net.Conn, error) {
+	start := time.Now()
+	conn, err := node.Client.Dial(node.Addr, node.DialOptions...)
+	if err != nil {
+		node.MarkDead()
+		return nil, err
+	}
+
+	cc, err := node.Client.Handshake(conn, node.HandshakeOptions...)
+	if err != nil {
+		conn.Close()
+		node.MarkDead()
+		return nil, err
+	}
+	node.ResetDead()
+	rtt := time.Since(start)
+	node.stats.observeLatency(rtt)
+	DefaultMetrics.dialObserved(*node, rtt)
+
+	return cc, nil
+}
+
+// trackConn counts the connection conn as an active connection of the nodes in the route until it is closed.
+func (c *Chain) trackConn(conn net.Conn) net.Conn {
+	if len(c.route) == 0 {
+		return conn
+	}
+	for i := range c.route {
+		c.route[i].stats.addConn(1)
+	}
+	return &routeConn{Conn: conn, nodes: c.route}
+}
+
+type routeConn struct {
+	net.Conn
+	nodes []Node
+	once  sync.Once
+}
+
+func (c *routeConn) Close() error {
+	c.once.Do(func() {
+		for i := range c.nodes {
+			c.nodes[i].stats.addConn(-1)
+		}
+	})
+	return c.Conn.Close()
 }
 
 func (c *Chain) selectRoute() (route *Chain, err error) {
@@ -284,6 +392,26 @@ func (c *Chain) selectRoute() (route *Chain, err error) {
 
 // selectRouteFor selects route with bypass testing.
 func (c *Chain) selectRouteFor(addr string) (route *Chain, err error) {
+	return c.selectRouteContext(SelectContext{Host: addr})
+}
+
+// selectRouteContext selects route for the target address ctx.Host with bypass testing,
+// the nodes are selected with the selection context ctx.
+func (c *Chain) selectRouteContext(ctx SelectContext) (route *Chain, err error) {
+	if c != nil && !c.isRoute && c.Rules != nil {
+		chain, matched, err := c.Rules.Match(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			if chain == nil {
+				return newRoute(), nil // direct
+			}
+			return chain.selectRouteContext(ctx)
+		}
+	}
+
+	addr := ctx.Host
 	if c.IsEmpty() {
 		return newRoute(), nil
 	}
@@ -293,10 +421,11 @@ func (c *Chain) selectRouteFor(addr string) (route *Chain, err error) {
 
 	route = newRoute()
 	var nl []Node
+	var raceGroup *NodeGroup
 
-	for _, group := range c.nodeGroups {
+	for i, group := range c.nodeGroups {
 		var node Node
-		node, err = group.Next()
+		node, err = group.NextContext(ctx)
 		if err != nil {
 			return
 		}
@@ -310,6 +439,9 @@ func (c *Chain) selectRouteFor(addr string) (route *Chain, err error) {
 				ChainDialOption(route),
 			)
 			route = newRoute() // cutoff the chain for multiplex node.
+			raceGroup = nil
+		} else if i == 0 {
+			raceGroup = group
 		}
 
 		route.AddNode(node)
@@ -317,16 +449,27 @@ func (c *Chain) selectRouteFor(addr string) (route *Chain, err error) {
 	}
 
 	route.route = nl
+	route.raceGroup = raceGroup
 
 	return
 }
 
 // ChainOptions holds options for Chain.
 type ChainOptions struct {
-	Retries  int
-	Timeout  time.Duration
-	Hosts    *Hosts
-	Resolver Resolver
+	Retries   int
+	Timeout   time.Duration
+	Hosts     *Hosts
+	Resolver  Resolver
+	Logger    LeveledLogger
+	Race      int           // number of the candidates to race, 0 or 1 disables racing
+	RaceDelay time.Duration // delay between the racing attempts
+}
+
+func (opts *ChainOptions) logger() LeveledLogger {
+	if opts.Logger == nil {
+		return DefaultLeveledLogger
+	}
+	return opts.Logger
 }
 
 // ChainOption allows a common way to set chain options.
@@ -359,3 +502,21 @@ func ResolverChainOption(resolver Resolver) ChainOption {
 		opts.Resolver = resolver
 	}
 }
+
+// RaceChainOption specifies the racing used by Chain.Dial.
+// The connection to the first node is raced with at most n available nodes of its group,
+// and the direct connection is raced with at most n resolved addresses of the target.
+// The attempts are started at the interval of delay, default is DefaultRaceDelay.
+func RaceChainOption(n int, delay time.Duration) ChainOption {
+	return func(opts *ChainOptions) {
+		opts.Race = n
+		opts.RaceDelay = delay
+	}
+}
+
+// LoggerChainOption specifies the leveled logger used by Chain.Dial.
+func LoggerChainOption(logger LeveledLogger) ChainOption {
+	return func(opts *ChainOptions) {
+		opts.Logger = logger
+	}
+}
@@ -6,21 +6,36 @@ import (
 	"flag"
 	"fmt"
 	"github.com/far4599/gost-minimal/config"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	_ "net/http/pprof"
 
 	"github.com/far4599/gost-minimal"
+	"github.com/far4599/gost-minimal/admin"
 	"github.com/go-log/log"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	configureFile string
 	baseCfg       = &config.BaseConfig{}
+	flagCfg       config.BaseConfig // the config set by the flags, the config file is applied on it
 	pprofAddr     string
 	pprofEnabled  = os.Getenv("PROFILING") != ""
+
+	routers   []*config.Router
+	adminSrv  *admin.Server
+	reloadMux sync.Mutex
 )
 
 func init() {
@@ -28,6 +43,8 @@ func init() {
 
 	var (
 		printVersion bool
+		printYAML    bool
+		checkConfig  bool
 	)
 
 	flag.Var(&baseCfg.Route.ChainNodes, "F", "forward address, can make a forward Chain")
@@ -35,6 +52,10 @@ func init() {
 	flag.StringVar(&configureFile, "C", "", "configure file")
 	flag.BoolVar(&baseCfg.Debug, "D", false, "enable debug log")
 	flag.BoolVar(&printVersion, "V", false, "print version")
+	flag.BoolVar(&printYAML, "Y", false, "print the config converted to YAML")
+	flag.BoolVar(&checkConfig, "check", false, "check the config and exit, no listener is opened")
+	flag.StringVar(&baseCfg.Metrics, "M", "", "metrics HTTP server address, such as :9000")
+	flag.StringVar(&baseCfg.Admin, "A", "", "admin API URL, such as https://admin:123456@:18080")
 	if pprofEnabled {
 		flag.StringVar(&pprofAddr, "P", ":6060", "profiling HTTP Server address")
 	}
@@ -46,6 +67,7 @@ func init() {
 		os.Exit(0)
 	}
 
+	flagCfg = *newFlagConfig()
 	if configureFile != "" {
 		_, err := config.ParseBaseConfig(configureFile, baseCfg)
 		if err != nil {
@@ -53,6 +75,25 @@ func init() {
 			os.Exit(1)
 		}
 	}
+	if printYAML {
+		cfg, err := config.NewYAMLConfig(baseCfg)
+		if err == nil {
+			err = yaml.NewEncoder(os.Stdout).Encode(cfg)
+		}
+		if err != nil {
+			log.Log(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if checkConfig {
+		if err := config.Check(baseCfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "config is OK")
+		os.Exit(0)
+	}
 	if flag.NFlag() == 0 {
 		flag.PrintDefaults()
 		os.Exit(0)
@@ -85,38 +126,185 @@ func main() {
 
 	gost.DefaultTLSConfig = tlsConfig
 
+	if baseCfg.Metrics != "" {
+		gost.DefaultMetrics = gost.NewMetrics()
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", gost.DefaultMetrics)
+		go func() {
+			log.Log("metrics Server on", baseCfg.Metrics)
+			log.Log(http.ListenAndServe(baseCfg.Metrics, mux))
+		}()
+	}
+
 	if err := start(); err != nil {
 		log.Log(err)
 		os.Exit(1)
 	}
 
+	go watchReload()
+	if configureFile != "" {
+		go gost.PeriodReload(configReloader{}, configureFile)
+	}
+
 	select {}
 }
 
 func start() error {
 	gost.Debug = baseCfg.Debug
 
-	var routers []config.Router
+	if err := config.SetGlobalLimits(baseCfg.Limits); err != nil {
+		return err
+	}
+
+	if baseCfg.Admin != "" {
+		gost.DefaultSessions = gost.NewSessions()
+	}
+
+	if err := config.ParseNamedObjects(baseCfg); err != nil {
+		return err
+	}
+
 	rts, err := baseCfg.Route.GenRouters()
 	if err != nil {
 		return err
 	}
-	routers = append(routers, rts...)
+	for i := range rts {
+		routers = append(routers, &rts[i])
+	}
 
 	for _, route := range baseCfg.Routes {
 		rts, err := route.GenRouters()
 		if err != nil {
 			return err
 		}
-		routers = append(routers, rts...)
+		for i := range rts {
+			routers = append(routers, &rts[i])
+		}
 	}
 
 	if len(routers) == 0 {
 		return errors.New("invalid config")
 	}
-	for i := range routers {
-		go routers[i].Serve()
+	for _, rt := range routers {
+		go rt.Serve()
+	}
+
+	if baseCfg.Admin != "" {
+		return startAdmin(baseCfg.Admin, routers)
+	}
+	return nil
+}
+
+// newFlagConfig returns a copy of the config set by the flags.
+func newFlagConfig() *config.BaseConfig {
+	return &config.BaseConfig{
+		Route: config.Route{
+			ServeNodes: append(config.StringList(nil), baseCfg.ServeNodes...),
+			ChainNodes: append(config.StringList(nil), baseCfg.ChainNodes...),
+		},
+		Debug:   baseCfg.Debug,
+		Metrics: baseCfg.Metrics,
+		Admin:   baseCfg.Admin,
+	}
+}
+
+// reload reloads the config file and diffs the routers against the running ones,
+// the unchanged listeners keep their sockets and active sessions.
+// The metrics and admin servers are not reloaded.
+func reload() {
+	reloadMux.Lock()
+	defer reloadMux.Unlock()
+
+	cfg := flagCfg
+	cfg.ServeNodes = append(config.StringList(nil), flagCfg.ServeNodes...)
+	cfg.ChainNodes = append(config.StringList(nil), flagCfg.ChainNodes...)
+	if configureFile != "" {
+		if _, err := config.ParseBaseConfig(configureFile, &cfg); err != nil {
+			log.Log("[reload]", err)
+			return
+		}
+	}
+
+	rts, err := config.ReloadRouters(&cfg, routers)
+	routers = rts
+	if adminSrv != nil {
+		adminSrv.SetRouters(rts)
+	}
+	if err != nil {
+		log.Log("[reload]", err)
+		return
+	}
+
+	gost.Debug = cfg.Debug
+	log.Logf("[reload] %d routers", len(rts))
+}
+
+// watchReload reloads the config on SIGHUP.
+func watchReload() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		log.Log("[reload] SIGHUP")
+		reload()
+	}
+}
+
+// configReloader reloads the config when the config file is changed.
+type configReloader struct{}
+
+func (configReloader) Reload(r io.Reader) error {
+	reload()
+	return nil
+}
+
+func (configReloader) Period() time.Duration {
+	return time.Second
+}
+
+func startAdmin(s string, routers []*config.Router) error {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	s, err := gost.InterpolateURL(s)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid admin URL %s", gost.Redact(s))
+	}
+
+	srv := &admin.Server{
+		Routers:  routers,
+		Sessions: gost.DefaultSessions,
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		srv.Authenticator = gost.NewLocalAuthenticator(map[string]string{
+			u.User.Username(): password,
+		})
+	}
+
+	ln, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "https" {
+		tlsConfig := gost.DefaultTLSConfig
+		if cert := u.Query().Get("cert"); cert != "" {
+			if tlsConfig, err = config.TlsConfig(cert, u.Query().Get("key")); err != nil {
+				ln.Close()
+				return err
+			}
+		}
+		ln = tls.NewListener(ln, tlsConfig)
 	}
 
+	adminSrv = srv
+	go func() {
+		log.Log("admin Server on", ln.Addr())
+		log.Log(http.Serve(ln, srv))
+	}()
 	return nil
 }
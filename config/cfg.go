@@ -6,25 +6,56 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/far4599/gost-minimal"
+	"github.com/go-log/log"
 )
 
 var (
 	Routers []Router
 )
 
+var (
+	accessLogs    = make(map[string]*accessLog)
+	accessLogsMux sync.Mutex
+)
+
 type BaseConfig struct {
 	Route
-	Routes []Route
-	Debug  bool
+	Routes     []Route
+	Chains     map[string]StringList // the named chains, each is a list of chain nodes or node group names
+	NodeGroups map[string]StringList // the named node groups, each is a list of chain nodes
+	Bypasses   map[string]string     // the named bypasses, in the format of the bypass parameter
+	Resolvers  map[string]string     // the named resolvers, in the format of the dns parameter
+	Hosts      map[string]string     // the named hosts files
+	Debug      bool
+	Limits     string // the file of global bandwidth limits
+	Metrics    string // the metrics HTTP server address
+	Admin      string // the admin API URL
 }
 
+// ParseBaseConfig parses the config file s in JSON, or in YAML if it has the .yaml or .yml extension.
+// The ${NAME} and ${file:PATH} placeholders in the node URLs are kept, they are interpolated when the nodes are parsed.
 func ParseBaseConfig(s string, baseCfg *BaseConfig) (*BaseConfig, error) {
+	if IsYAMLConfig(s) {
+		cfg, err := ParseYAMLConfig(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s, err)
+		}
+		if err := cfg.Apply(baseCfg); err != nil {
+			return nil, err
+		}
+		return baseCfg, nil
+	}
+
 	file, err := os.Open(s)
 	if err != nil {
 		return nil, err
@@ -87,11 +118,12 @@ func ParseUsers(authFile string) (users []*url.Userinfo, err error) {
 			continue
 		}
 
-		s := strings.SplitN(line, " ", 2)
-		if len(s) == 1 {
-			users = append(users, url.User(strings.TrimSpace(s[0])))
-		} else if len(s) == 2 {
-			users = append(users, url.UserPassword(strings.TrimSpace(s[0]), strings.TrimSpace(s[1])))
+		// the permissions of the user are ignored.
+		s := strings.Fields(line)
+		if len(s) == 1 || gost.IsUserOption(s[1]) {
+			users = append(users, url.User(s[0]))
+		} else {
+			users = append(users, url.UserPassword(s[0], s[1]))
 		}
 	}
 
@@ -99,10 +131,34 @@ func ParseUsers(authFile string) (users []*url.Userinfo, err error) {
 	return
 }
 
+// ParseAuthenticator parses the secrets file or the remote source s.
 func ParseAuthenticator(s string) (gost.Authenticator, error) {
+	return named.parseAuthenticator(s, nil)
+}
+
+func (o *namedObjects) parseAuthenticator(s string, chain *gost.Chain) (gost.Authenticator, error) {
 	if s == "" {
 		return nil, nil
 	}
+	if gost.IsRemoteSource(s) {
+		au := gost.NewLocalAuthenticator(nil)
+		if err := o.loadRemote(au, s, chain); err != nil {
+			return nil, err
+		}
+		return au, nil
+	}
+
+	au, err := loadAuthenticator(s)
+	if err != nil {
+		return nil, err
+	}
+	go gost.PeriodReload(au, s)
+
+	return au, nil
+}
+
+// loadAuthenticator loads the secrets file s, it is not live reloaded.
+func loadAuthenticator(s string) (*gost.LocalAuthenticator, error) {
 	f, err := os.Open(s)
 	if err != nil {
 		return nil, err
@@ -110,13 +166,230 @@ func ParseAuthenticator(s string) (gost.Authenticator, error) {
 	defer f.Close()
 
 	au := gost.NewLocalAuthenticator(nil)
-	au.Reload(f)
+	if err := au.Reload(f); err != nil {
+		return nil, err
+	}
+	return au, nil
+}
 
+// parseNodeAuthenticator parses the authenticator of the serve node,
+// which is the htpasswd file, the HTTP endpoint auth_url or the secrets file.
+func (o *namedObjects) parseNodeAuthenticator(node gost.Node, chain *gost.Chain) (gost.Authenticator, error) {
+	if s := node.Get("htpasswd"); s != "" {
+		return o.parseHTPasswd(s, chain)
+	}
+	if s := node.Get("auth_url"); s != "" {
+		au := gost.NewHTTPAuthenticator(s)
+		au.TTL = node.GetDuration("auth_ttl")
+		au.Timeout = node.GetDuration("auth_timeout")
+		return au, nil
+	}
+	return o.parseAuthenticator(node.Get("secrets"), chain)
+}
+
+// ParseHTPasswd parses the htpasswd file or the remote source s.
+func ParseHTPasswd(s string) (gost.Authenticator, error) {
+	return named.parseHTPasswd(s, nil)
+}
+
+func (o *namedObjects) parseHTPasswd(s string, chain *gost.Chain) (gost.Authenticator, error) {
+	if gost.IsRemoteSource(s) {
+		au := gost.NewHTPasswdAuthenticator()
+		if err := o.loadRemote(au, s, chain); err != nil {
+			return nil, err
+		}
+		return au, nil
+	}
+
+	au, err := loadHTPasswd(s)
+	if err != nil {
+		return nil, err
+	}
 	go gost.PeriodReload(au, s)
 
 	return au, nil
 }
 
+// loadHTPasswd loads the htpasswd file s, it is not live reloaded.
+func loadHTPasswd(s string) (*gost.HTPasswdAuthenticator, error) {
+	f, err := os.Open(s)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	au := gost.NewHTPasswdAuthenticator()
+	if err := au.Reload(f); err != nil {
+		return nil, err
+	}
+	return au, nil
+}
+
+// ParseLimiter creates a bandwidth limiter from the read/write limits such as 10MB,
+// and the optional limits file that will be live reloaded.
+func ParseLimiter(rlimit, wlimit, s string) (*gost.Limiter, error) {
+	limiter, err := loadLimiter(rlimit, wlimit, s)
+	if err != nil || limiter == nil {
+		return nil, err
+	}
+	if s != "" {
+		go gost.PeriodReload(limiter, s)
+	}
+	return limiter, nil
+}
+
+// loadLimiter is like ParseLimiter, but the limits file is not live reloaded.
+func loadLimiter(rlimit, wlimit, s string) (*gost.Limiter, error) {
+	if rlimit == "" && wlimit == "" && s == "" {
+		return nil, nil
+	}
+
+	r, err := gost.ParseBytes(rlimit)
+	if err != nil {
+		return nil, err
+	}
+	w, err := gost.ParseBytes(wlimit)
+	if err != nil {
+		return nil, err
+	}
+	limiter := gost.NewLimiter(r, w)
+	if s == "" {
+		return limiter, nil
+	}
+
+	f, err := os.Open(s)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := limiter.Reload(f); err != nil {
+		return nil, err
+	}
+	return limiter, nil
+}
+
+// ParseTraffic creates the traffic accounting with the optional quota file that will be live reloaded,
+// and the optional store file for persisting the quota usage.
+func ParseTraffic(quota, store string) (*gost.Traffic, error) {
+	traffic, err := loadTraffic(quota, store)
+	if err != nil {
+		return nil, err
+	}
+	if store != "" {
+		go gost.PeriodSave(traffic, time.Minute)
+	}
+	if quota != "" {
+		go gost.PeriodReload(traffic, quota)
+	}
+	return traffic, nil
+}
+
+// loadTraffic is like ParseTraffic, but the quota file is not live reloaded and the usage is not saved.
+func loadTraffic(quota, store string) (*gost.Traffic, error) {
+	traffic, err := gost.NewTraffic(store)
+	if err != nil {
+		return nil, err
+	}
+	if quota == "" {
+		return traffic, nil
+	}
+
+	f, err := os.Open(quota)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := traffic.Reload(f); err != nil {
+		return nil, err
+	}
+	return traffic, nil
+}
+
+// ParseAccessLog creates the access logger writing to the file name,
+// which is rotated when its size exceeds maxSize (100MB by default), keeping the number of backups (3 by default).
+// The serve nodes with the same file share the logger, the rotation of a shared logger is refreshed to the latest options.
+// The returned stoppable releases the logger, the file is closed when all the users release it.
+func ParseAccessLog(name, maxSize, backups string) (*gost.AccessLogger, gost.Stoppable, error) {
+	if name == "" {
+		return nil, nil, nil
+	}
+
+	size := int64(100 << 20)
+	if maxSize != "" {
+		n, err := gost.ParseBytes(maxSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		size = n
+	}
+	n := 3
+	if backups != "" {
+		var err error
+		if n, err = strconv.Atoi(backups); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	accessLogsMux.Lock()
+	defer accessLogsMux.Unlock()
+
+	l := accessLogs[name]
+	if l != nil {
+		l.file.SetRotation(size, n)
+	} else {
+		f, err := gost.NewRotateFile(name, size, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		l = &accessLog{name: name, file: f, logger: gost.NewAccessLogger(f)}
+		accessLogs[name] = l
+	}
+	l.refs++
+	return l.logger, &accessLogRef{l: l}, nil
+}
+
+// accessLog is the access logger shared by the serve nodes writing to the same file.
+type accessLog struct {
+	name   string
+	file   *gost.RotateFile
+	logger *gost.AccessLogger
+	refs   int
+}
+
+// accessLogRef is a reference to the shared access logger, it is stopped with the router holding it.
+type accessLogRef struct {
+	l       *accessLog
+	stopped bool
+}
+
+// Stop releases the reference, the logger is closed and removed from the cache when it is the last one.
+func (r *accessLogRef) Stop() {
+	accessLogsMux.Lock()
+	defer accessLogsMux.Unlock()
+
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+
+	if r.l.refs--; r.l.refs > 0 {
+		return
+	}
+	if accessLogs[r.l.name] == r.l {
+		delete(accessLogs, r.l.name)
+	}
+	r.l.logger.Close()
+}
+
+func (r *accessLogRef) Stopped() bool {
+	accessLogsMux.Lock()
+	defer accessLogsMux.Unlock()
+
+	return r.stopped
+}
+
 func ParseIP(s string, port string) (ips []string) {
 	if s == "" {
 		return
@@ -156,10 +429,18 @@ func ParseIP(s string, port string) (ips []string) {
 	return
 }
 
+// ParseBypass parses the bypass s, which is a list of patterns, a file or a remote source.
 func ParseBypass(s string) *gost.Bypass {
+	return named.parseBypass(s, nil)
+}
+
+func (o *namedObjects) parseBypass(s string, chain *gost.Chain) *gost.Bypass {
 	if s == "" {
 		return nil
 	}
+	if bp := o.bypass(s); bp != nil {
+		return bp
+	}
 	var matchers []gost.Matcher
 	var reversed bool
 	if strings.HasPrefix(s, "~") {
@@ -167,6 +448,14 @@ func ParseBypass(s string) *gost.Bypass {
 		s = strings.TrimLeft(s, "~")
 	}
 
+	if gost.IsRemoteSource(s) {
+		bp := gost.NewBypass(reversed)
+		if err := o.loadRemote(bp, s, chain); err != nil {
+			log.Logf("[bypass] %s: %v", gost.Redact(s), err)
+		}
+		return bp
+	}
+
 	f, err := os.Open(s)
 	if err != nil {
 		for _, s := range strings.Split(s, ",") {
@@ -187,52 +476,26 @@ func ParseBypass(s string) *gost.Bypass {
 	return bp
 }
 
+// ParseResolver parses the resolver cfg, which is a list of name servers, a file or a remote source.
 func ParseResolver(cfg string) gost.Resolver {
+	return named.parseResolver(cfg, nil)
+}
+
+func (o *namedObjects) parseResolver(cfg string, chain *gost.Chain) gost.Resolver {
 	if cfg == "" {
 		return nil
 	}
-	var nss []gost.NameServer
+	if gost.IsRemoteSource(cfg) {
+		resolver := gost.NewResolver(0)
+		if err := o.loadRemote(resolver, cfg, chain); err != nil {
+			log.Logf("[resolver] %s: %v", gost.Redact(cfg), err)
+		}
+		return resolver
+	}
 
 	f, err := os.Open(cfg)
 	if err != nil {
-		for _, s := range strings.Split(cfg, ",") {
-			s = strings.TrimSpace(s)
-			if s == "" {
-				continue
-			}
-			if strings.HasPrefix(s, "https") {
-				p := "https"
-				u, _ := url.Parse(s)
-				if u == nil || u.Scheme == "" {
-					continue
-				}
-				if u.Scheme == "https-Chain" {
-					p = u.Scheme
-				}
-				ns := gost.NameServer{
-					Addr:     s,
-					Protocol: p,
-				}
-				nss = append(nss, ns)
-				continue
-			}
-
-			ss := strings.Split(s, "/")
-			if len(ss) == 1 {
-				ns := gost.NameServer{
-					Addr: ss[0],
-				}
-				nss = append(nss, ns)
-			}
-			if len(ss) == 2 {
-				ns := gost.NameServer{
-					Addr:     ss[0],
-					Protocol: ss[1],
-				}
-				nss = append(nss, ns)
-			}
-		}
-		return gost.NewResolver(0, nss...)
+		return gost.NewResolver(0, parseNameServers(cfg)...)
 	}
 	defer f.Close()
 
@@ -244,7 +507,65 @@ func ParseResolver(cfg string) gost.Resolver {
 	return resolver
 }
 
+// parseNameServers parses the comma separated name servers, such as 1.1.1.1:53/udp,https://1.0.0.1/dns-query.
+func parseNameServers(cfg string) (nss []gost.NameServer) {
+	for _, s := range strings.Split(cfg, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if strings.HasPrefix(s, "https") {
+			p := "https"
+			u, _ := url.Parse(s)
+			if u == nil || u.Scheme == "" {
+				continue
+			}
+			if u.Scheme == "https-Chain" {
+				p = u.Scheme
+			}
+			ns := gost.NameServer{
+				Addr:     s,
+				Protocol: p,
+			}
+			nss = append(nss, ns)
+			continue
+		}
+
+		ss := strings.Split(s, "/")
+		if len(ss) == 1 {
+			ns := gost.NameServer{
+				Addr: ss[0],
+			}
+			nss = append(nss, ns)
+		}
+		if len(ss) == 2 {
+			ns := gost.NameServer{
+				Addr:     ss[0],
+				Protocol: ss[1],
+			}
+			nss = append(nss, ns)
+		}
+	}
+	return
+}
+
+// ParseHosts parses the hosts file or the remote source s.
 func ParseHosts(s string) *gost.Hosts {
+	return named.parseHosts(s, nil)
+}
+
+func (o *namedObjects) parseHosts(s string, chain *gost.Chain) *gost.Hosts {
+	if hosts := o.hostsOf(s); hosts != nil {
+		return hosts
+	}
+	if gost.IsRemoteSource(s) {
+		hosts := gost.NewHosts()
+		if err := o.loadRemote(hosts, s, chain); err != nil {
+			log.Logf("[hosts] %s: %v", gost.Redact(s), err)
+		}
+		return hosts
+	}
+
 	f, err := os.Open(s)
 	if err != nil {
 		return nil
@@ -258,3 +579,40 @@ func ParseHosts(s string) *gost.Hosts {
 
 	return hosts
 }
+
+// ParseStrategy creates the node selection strategy by the name,
+// hashKey is the key of the hash strategy.
+func ParseStrategy(name, hashKey string) gost.Strategy {
+	strategy := gost.NewStrategy(name)
+	if s, ok := strategy.(*gost.HashStrategy); ok {
+		s.Key = hashKey
+	}
+	return strategy
+}
+
+// ParseRules parses the routing rules file s,
+// the rule targets refer to the named chains.
+func ParseRules(s string) (*gost.Rules, error) {
+	return named.parseRules(s)
+}
+
+func (o *namedObjects) parseRules(s string) (*gost.Rules, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(s)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := gost.NewRules(o.chainMap())
+	if err := rules.Reload(f); err != nil {
+		return nil, fmt.Errorf("%s: %v", s, err)
+	}
+
+	go gost.PeriodReload(rules, s)
+
+	return rules, nil
+}
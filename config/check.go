@@ -0,0 +1,353 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/far4599/gost-minimal"
+)
+
+// the protocols and transports supported by the serve nodes and the chain nodes,
+// ParseNode falls back to auto and tcp for the others silently.
+var (
+	serveHandlers = map[string]bool{
+		"": true, "auto": true, "http": true, "https": true, "socks": true, "socks5": true, "socks4": true, "socks4a": true,
+		"tcp": true, "rtcp": true, "udp": true, "rudp": true, "sni": true, "dns": true,
+	}
+	serveListeners = map[string]bool{
+		"tcp": true, "tls": true, "https": true, "mtls": true, "rtcp": true, "dns": true,
+	}
+	chainConnectors = map[string]bool{
+		"": true, "auto": true, "http": true, "https": true, "socks": true, "socks5": true, "socks4": true, "socks4a": true,
+		"forward": true, "sni": true,
+	}
+	chainDialers = map[string]bool{
+		"tcp": true, "tls": true, "https": true, "mtls": true, "ohttp": true,
+	}
+	strategies = map[string]bool{
+		"": true, "round": true, "random": true, "fifo": true, "least_conn": true, "latency": true, "weighted": true, "hash": true,
+	}
+	nameServerProtocols = map[string]bool{
+		"": true, "udp": true, "udp-chain": true, "tcp": true, "tcp-chain": true,
+		"tls": true, "tls-chain": true, "https": true, "https-chain": true,
+	}
+)
+
+// Check validates the config as a dry run, no listener is opened.
+// Each node is parsed, the permissions, bypass, resolver, hosts and rules sources,
+// and the cert, key and CA files are loaded, all the problems found are reported.
+// It has no side effects: the remote sources are not fetched, no cache file is written,
+// and no reloader is started.
+func Check(baseCfg *BaseConfig) error {
+	c := &checker{cfg: baseCfg}
+
+	for name, nodes := range baseCfg.NodeGroups {
+		if len(nodes) == 0 {
+			c.errorf("node group %s: empty node group", name)
+		}
+		for _, ns := range nodes {
+			c.checkChainNode("node group "+name, ns)
+		}
+	}
+	for name, hops := range baseCfg.Chains {
+		if name == gost.RuleDirect || name == gost.RuleReject {
+			c.errorf("chain %s: chain name is reserved", name)
+		}
+		c.checkHops("chain "+name, hops)
+	}
+	for name, s := range baseCfg.Bypasses {
+		c.checkBypass("bypass "+name, s)
+	}
+	for name, s := range baseCfg.Resolvers {
+		c.checkResolver("resolver "+name, s)
+	}
+	for name, s := range baseCfg.Hosts {
+		c.checkHosts("hosts "+name, s)
+	}
+
+	routes := append([]Route{baseCfg.Route}, baseCfg.Routes...)
+	for _, route := range routes {
+		if route.Chain != "" {
+			if _, ok := baseCfg.Chains[route.Chain]; !ok {
+				c.errorf("unknown chain %s", route.Chain)
+			}
+		} else {
+			c.checkHops("chain", route.ChainNodes)
+		}
+		for _, ns := range route.ServeNodes {
+			c.checkServeNode(ns)
+		}
+	}
+
+	if _, err := loadLimiter("", "", baseCfg.Limits); err != nil {
+		c.errorf("limits: %v", err)
+	}
+
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+	return nil
+}
+
+type checker struct {
+	cfg  *BaseConfig
+	errs configErrors
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Sprintf(format, args...))
+}
+
+func (c *checker) checkHops(prefix string, hops []string) {
+	for _, ns := range hops {
+		if _, ok := c.cfg.NodeGroups[ns]; ok {
+			continue
+		}
+		c.checkChainNode(prefix, ns)
+	}
+}
+
+func (c *checker) checkChainNode(prefix, ns string) {
+	prefix += ": " + gost.Redact(ns)
+	if err := checkScheme(ns, chainConnectors, chainDialers); err != nil {
+		c.errorf("%s: %v", prefix, err)
+	}
+	nodes, err := named.parseChainNode(ns, true)
+	if err != nil {
+		c.errorf("%s: %v", prefix, err)
+		return
+	}
+	node := nodes[0]
+	if s := node.Get("peer"); gost.IsRemoteSource(s) {
+		c.checkRemote(prefix+": peer", s)
+	} else if s != "" {
+		f, err := os.Open(s)
+		if err != nil {
+			c.errorf("%s: peer: %v", prefix, err)
+		} else {
+			peerCfg := newPeerConfig()
+			if err := peerCfg.parse(f); err != nil {
+				c.errorf("%s: peer: %v", prefix, err)
+			}
+			f.Close()
+			for _, ns := range peerCfg.Nodes {
+				c.checkChainNode(prefix+": peer", ns)
+			}
+		}
+	}
+	c.checkStrategy(prefix, node.Get("strategy"))
+	c.checkBypass(prefix+": bypass", node.Get("bypass"))
+}
+
+func (c *checker) checkServeNode(ns string) {
+	prefix := gost.Redact(ns)
+	if err := checkScheme(ns, serveHandlers, serveListeners); err != nil {
+		c.errorf("%s: %v", prefix, err)
+	}
+	node, err := gost.ParseNode(ns)
+	if err != nil {
+		c.errorf("%s: %v", prefix, err)
+		return
+	}
+
+	if auth := node.Get("auth"); auth != "" {
+		if _, err := base64.StdEncoding.DecodeString(auth); err != nil {
+			c.errorf("%s: auth: %v", prefix, err)
+		}
+	}
+	if s := node.Get("htpasswd"); gost.IsRemoteSource(s) {
+		c.checkRemote(prefix+": htpasswd", s)
+	} else if s != "" {
+		if _, err := loadHTPasswd(s); err != nil {
+			c.errorf("%s: htpasswd: %v", prefix, err)
+		}
+	}
+	if s := node.Get("auth_url"); s != "" && !gost.IsRemoteSource(s) {
+		c.errorf("%s: auth_url: invalid URL %s", prefix, gost.Redact(s))
+	}
+	if s := node.Get("secrets"); gost.IsRemoteSource(s) {
+		c.checkRemote(prefix+": secrets", s)
+	} else if s != "" {
+		if _, err := loadAuthenticator(s); err != nil {
+			c.errorf("%s: secrets: %v", prefix, err)
+		}
+	}
+
+	certFile, keyFile := node.Get("cert"), node.Get("key")
+	if (certFile == "") != (keyFile == "") {
+		c.errorf("%s: cert and key should be set together", prefix)
+	} else if certFile != "" {
+		if _, err := TlsConfig(certFile, keyFile); err != nil {
+			c.errorf("%s: %v", prefix, err)
+		}
+	}
+
+	for _, name := range []string{"whitelist", "blacklist"} {
+		if s := node.Get(name); s != "" {
+			if _, err := gost.ParsePermissions(s); err != nil {
+				c.errorf("%s: %s: %v", prefix, name, err)
+			}
+		}
+	}
+	if _, err := loadLimiter(node.Get("rlimit"), node.Get("wlimit"), node.Get("limits")); err != nil {
+		c.errorf("%s: %v", prefix, err)
+	}
+	if quota, store := node.Get("quota"), node.Get("quota_store"); quota != "" || store != "" {
+		if _, err := loadTraffic(quota, store); err != nil {
+			c.errorf("%s: quota: %v", prefix, err)
+		}
+	}
+	if s := node.Get("loglevel"); s != "" {
+		if _, err := gost.ParseLogLevel(s); err != nil {
+			c.errorf("%s: %v", prefix, err)
+		}
+	}
+
+	c.checkStrategy(prefix, node.Get("strategy"))
+	c.checkBypass(prefix+": bypass", node.Get("bypass"))
+	c.checkResolver(prefix+": dns", node.Get("dns"))
+	c.checkHosts(prefix+": hosts", node.Get("Hosts"))
+	c.checkRules(prefix+": rules", node.Get("rules"))
+}
+
+// checkScheme checks the scheme of the node ns is made of the known protocol and transport.
+func checkScheme(ns string, protocols, transports map[string]bool) error {
+	u, err := parseNodeURL(ns)
+	if err != nil {
+		return err
+	}
+	protocol, transport := splitScheme(strings.ToLower(u.Scheme))
+	if transport == "" {
+		// a single scheme is either the protocol or the transport, such as http:// or tls://
+		if !protocols[protocol] && !transports[protocol] {
+			return fmt.Errorf("unknown protocol or transport %s", protocol)
+		}
+		return nil
+	}
+	if !protocols[protocol] {
+		return fmt.Errorf("unknown protocol %s", protocol)
+	}
+	if !transports[transport] {
+		return fmt.Errorf("unknown transport %s", transport)
+	}
+	return nil
+}
+
+func (c *checker) checkStrategy(prefix, s string) {
+	if !strategies[s] {
+		c.errorf("%s: unknown strategy %s", prefix, s)
+	}
+}
+
+// checkBypass reports the bypass file which can not be loaded,
+// ParseBypass takes it as a list of patterns silently.
+func (c *checker) checkBypass(prefix, s string) {
+	if s == "" {
+		return
+	}
+	if _, ok := c.cfg.Bypasses[s]; ok {
+		return
+	}
+	s = strings.TrimLeft(s, "~")
+	if gost.IsRemoteSource(s) {
+		c.checkRemote(prefix, s)
+		return
+	}
+	if _, err := os.Stat(s); err == nil || strings.Contains(s, ",") {
+		return
+	}
+	if _, _, err := net.ParseCIDR(s); err != nil && strings.Contains(s, "/") {
+		c.errorf("%s: can not load %s", prefix, gost.Redact(s))
+	}
+}
+
+// checkResolver reports the name servers with the unknown protocols,
+// which are taken as UDP name servers silently.
+func (c *checker) checkResolver(prefix, s string) {
+	if s == "" {
+		return
+	}
+	if _, ok := c.cfg.Resolvers[s]; ok {
+		return
+	}
+	if gost.IsRemoteSource(s) {
+		c.checkRemote(prefix, s)
+		return
+	}
+	if _, err := os.Stat(s); err == nil {
+		return
+	}
+
+	nss := parseNameServers(s)
+	if len(nss) == 0 {
+		c.errorf("%s: no name server in %s", prefix, gost.Redact(s))
+	}
+	for _, ns := range nss {
+		if !nameServerProtocols[strings.ToLower(ns.Protocol)] {
+			c.errorf("%s: unknown name server protocol %s", prefix, ns.Protocol)
+			continue
+		}
+		if err := ns.Init(); err != nil {
+			c.errorf("%s: %s: %v", prefix, ns.Addr, err)
+		}
+	}
+}
+
+func (c *checker) checkHosts(prefix, s string) {
+	if s == "" {
+		return
+	}
+	if _, ok := c.cfg.Hosts[s]; ok {
+		return
+	}
+	if gost.IsRemoteSource(s) {
+		c.checkRemote(prefix, s)
+		return
+	}
+	if _, err := os.Stat(s); err != nil {
+		c.errorf("%s: %v", prefix, err)
+	}
+}
+
+// checkRemote checks the options of the remote source s, the source is not fetched.
+func (c *checker) checkRemote(prefix, s string) {
+	_, name, err := parseRemoteSource(s)
+	if err != nil {
+		c.errorf("%s: %v", prefix, err)
+		return
+	}
+	if name == "" {
+		return
+	}
+	if _, err := strconv.ParseBool(name); err == nil {
+		return
+	}
+	if _, ok := c.cfg.Chains[name]; !ok {
+		c.errorf("%s: unknown chain %s", prefix, name)
+	}
+}
+
+func (c *checker) checkRules(prefix, s string) {
+	if s == "" {
+		return
+	}
+	f, err := os.Open(s)
+	if err != nil {
+		c.errorf("%s: %v", prefix, err)
+		return
+	}
+	defer f.Close()
+
+	// the chains are not built, the targets are checked by name.
+	chains := make(map[string]*gost.Chain)
+	for name := range c.cfg.Chains {
+		chains[name] = nil
+	}
+	if err := gost.NewRules(chains).Reload(f); err != nil {
+		c.errorf("%s: %v", prefix, err)
+	}
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	s := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(s, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	limits := writeTestFile(t, dir, "limits.txt", "* 1MB 1MB\nadmin 10MB\n")
+	badLimits := writeTestFile(t, dir, "bad-limits.txt", "admin 10XB\n")
+	quota := writeTestFile(t, dir, "quota.txt", "admin 1GB daily\n")
+	secrets := writeTestFile(t, dir, "secrets.txt", "admin 123456\n")
+	rules := writeTestFile(t, dir, "rules.txt", "DOMAIN-SUFFIX,example.com,DIRECT\n")
+	badRules := writeTestFile(t, dir, "bad-rules.txt", "DOMAIN-SUFFIX,example.com,chain2\n")
+
+	tests := []struct {
+		cfg  BaseConfig
+		errs []string
+	}{
+		{cfg: BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080"}}}},
+		{cfg: BaseConfig{
+			Route: Route{
+				ServeNodes: StringList{
+					"http://:8080?limits=" + limits + "&quota=" + quota + "&secrets=" + secrets + "&rules=" + rules,
+					"socks5://:1080?whitelist=tcp:*:80,443&strategy=hash&dns=1.1.1.1:53/udp",
+				},
+				Chain: "chain1",
+			},
+			Chains:     map[string]StringList{"chain1": {"group1"}},
+			NodeGroups: map[string]StringList{"group1": {"socks5+tls://192.168.1.1:1080?bypass=*.local"}},
+			Limits:     limits,
+		}},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"xyz://:8080", "http+xyz://:8081"}}},
+			errs: []string{"unknown protocol or transport xyz", "unknown transport xyz"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080"}, ChainNodes: StringList{"xyz+tls://:1080"}}},
+			errs: []string{"unknown protocol xyz"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080?strategy=best"}}},
+			errs: []string{"unknown strategy best"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080"}, Chain: "chain1"}},
+			errs: []string{"unknown chain chain1"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080?whitelist=tcp"}}},
+			errs: []string{"whitelist"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080?limits=" + badLimits}}, Limits: badLimits},
+			errs: []string{"http://:8080", "limits:"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080?secrets=" + filepath.Join(dir, "none.txt")}}},
+			errs: []string{"secrets:"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080?rules=" + badRules}}},
+			errs: []string{"rules:"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080?dns=1.1.1.1:53/xyz"}}},
+			errs: []string{"unknown name server protocol xyz"},
+		},
+		{
+			cfg:  BaseConfig{Route: Route{ServeNodes: StringList{"http://:8080?bypass=https://example.com/bypass.txt%23chain=chain2"}}},
+			errs: []string{"unknown chain chain2"},
+		},
+		{
+			cfg: BaseConfig{
+				Route:      Route{ServeNodes: StringList{"http://:8080"}},
+				NodeGroups: map[string]StringList{"group1": nil},
+				Chains:     map[string]StringList{"DIRECT": {"http://:8081"}},
+			},
+			errs: []string{"node group group1: empty node group", "chain DIRECT: chain name is reserved"},
+		},
+	}
+
+	for i, tc := range tests {
+		err := Check(&tc.cfg)
+		if len(tc.errs) == 0 {
+			if err != nil {
+				t.Errorf("#%d test failed: %v", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("#%d test failed: want error %v", i, tc.errs)
+			continue
+		}
+		for _, s := range tc.errs {
+			if !strings.Contains(err.Error(), s) {
+				t.Errorf("#%d test failed: got error %q, want %q", i, err, s)
+			}
+		}
+	}
+}
+
+// TestCheckDryRun checks the remote sources are not fetched, the cache files are not written,
+// and the reloaders are not started.
+func TestCheckDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var fetched int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		w.Write([]byte("*.example.com\n"))
+	}))
+	defer srv.Close()
+
+	limits := writeTestFile(t, dir, "limits.txt", "* 1MB 1MB\n")
+	quota := writeTestFile(t, dir, "quota.txt", "admin 1GB\n")
+	secrets := writeTestFile(t, dir, "secrets.txt", "admin 123456\n")
+	cache := filepath.Join(dir, "bypass.cache")
+	bypass := srv.URL + "/bypass.txt%23cache=" + cache
+
+	cfg := &BaseConfig{
+		Route: Route{
+			ServeNodes: StringList{
+				"http://:8080?limits=" + limits + "&quota=" + quota + "&quota_store=" + filepath.Join(dir, "usage.json") +
+					"&secrets=" + secrets,
+			},
+			ChainNodes: StringList{"socks5://192.168.1.1:1080?bypass=" + bypass},
+		},
+		Limits: limits,
+	}
+
+	n := runtime.NumGoroutine()
+	if err := Check(cfg); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if v := atomic.LoadInt32(&fetched); v != 0 {
+		t.Errorf("remote source is fetched %d times", v)
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		t.Errorf("cache file is written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "usage.json")); !os.IsNotExist(err) {
+		t.Errorf("quota store is written: %v", err)
+	}
+	if m := runtime.NumGoroutine(); m > n {
+		t.Errorf("%d goroutines are started", m-n)
+	}
+}
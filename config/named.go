@@ -0,0 +1,183 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/far4599/gost-minimal"
+)
+
+// the named objects defined in the config,
+// each of them is built once and shared by all the nodes referring to it by name.
+var named = &namedObjects{}
+
+type namedObjects struct {
+	chains    map[string]*gost.Chain
+	groups    map[string]*gost.NodeGroup
+	bypasses  map[string]*gost.Bypass
+	resolvers map[string]gost.Resolver
+	hosts     map[string]*gost.Hosts
+	mux       sync.RWMutex
+}
+
+// ParseNamedObjects builds the named node groups, chains, bypasses, resolvers and hosts of the config,
+// they replace the named objects built before, which are stopped.
+func ParseNamedObjects(cfg *BaseConfig) error {
+	o, err := parseNamedObjects(cfg)
+	if err != nil {
+		return err
+	}
+	named.swap(o).stop()
+	return nil
+}
+
+// parseNamedObjects builds the named objects of the config, the running named objects are not changed.
+// The node groups are built before the chains, so the chains can refer to them.
+// If it fails, the objects built are stopped.
+func parseNamedObjects(cfg *BaseConfig) (_ *namedObjects, err error) {
+	o := &namedObjects{
+		groups:    make(map[string]*gost.NodeGroup),
+		chains:    make(map[string]*gost.Chain),
+		bypasses:  make(map[string]*gost.Bypass),
+		resolvers: make(map[string]gost.Resolver),
+		hosts:     make(map[string]*gost.Hosts),
+	}
+	defer func() {
+		if err != nil {
+			o.stop()
+		}
+	}()
+
+	for name, nodes := range cfg.NodeGroups {
+		group, err := o.parseNodeGroup(0, nil, nodes...)
+		if err != nil {
+			return nil, fmt.Errorf("node group %s: %v", name, err)
+		}
+		o.groups[name] = group
+	}
+
+	for name, hops := range cfg.Chains {
+		if name == gost.RuleDirect || name == gost.RuleReject {
+			return nil, fmt.Errorf("chain name %s is reserved", name)
+		}
+		chain, err := o.parseChain(hops, 0)
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: %v", name, err)
+		}
+		o.chains[name] = chain
+	}
+
+	// the remote sources below can be fetched through the named chains.
+	for name, s := range cfg.Bypasses {
+		o.bypasses[name] = o.parseBypass(s, nil)
+	}
+
+	for name, s := range cfg.Resolvers {
+		resolver := o.parseResolver(s, nil)
+		if resolver == nil {
+			return nil, fmt.Errorf("resolver %s: invalid resolver %s", name, gost.Redact(s))
+		}
+		o.resolvers[name] = resolver
+		// the shared resolver is not bound to any chain.
+		if err := resolver.Init(); err != nil {
+			return nil, fmt.Errorf("resolver %s: %v", name, err)
+		}
+	}
+
+	for name, s := range cfg.Hosts {
+		h := o.parseHosts(s, nil)
+		if h == nil {
+			return nil, fmt.Errorf("hosts %s: can not load %s", name, gost.Redact(s))
+		}
+		o.hosts[name] = h
+	}
+
+	return o, nil
+}
+
+// swap replaces the named objects with the ones of n, the replaced ones are returned.
+func (o *namedObjects) swap(n *namedObjects) *namedObjects {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	old := &namedObjects{
+		chains:    o.chains,
+		groups:    o.groups,
+		bypasses:  o.bypasses,
+		resolvers: o.resolvers,
+		hosts:     o.hosts,
+	}
+	o.chains, o.groups, o.bypasses, o.resolvers, o.hosts = n.chains, n.groups, n.bypasses, n.resolvers, n.hosts
+	return old
+}
+
+// stop stops the named chains and node groups, and the reloaders of the named bypasses, resolvers and hosts.
+func (o *namedObjects) stop() {
+	o.mux.RLock()
+	defer o.mux.RUnlock()
+
+	for _, chain := range o.chains {
+		stopChain(chain)
+	}
+	for _, group := range o.groups {
+		stopGroup(group)
+	}
+	for _, bp := range o.bypasses {
+		bp.Stop()
+	}
+	for _, r := range o.resolvers {
+		if s, ok := r.(gost.Stoppable); ok {
+			s.Stop()
+		}
+	}
+	for _, h := range o.hosts {
+		h.Stop()
+	}
+}
+
+func (o *namedObjects) chain(name string) *gost.Chain {
+	o.mux.RLock()
+	defer o.mux.RUnlock()
+
+	return o.chains[name]
+}
+
+// chainMap returns a copy of the named chains.
+func (o *namedObjects) chainMap() map[string]*gost.Chain {
+	o.mux.RLock()
+	defer o.mux.RUnlock()
+
+	m := make(map[string]*gost.Chain, len(o.chains))
+	for name, chain := range o.chains {
+		m[name] = chain
+	}
+	return m
+}
+
+func (o *namedObjects) group(name string) *gost.NodeGroup {
+	o.mux.RLock()
+	defer o.mux.RUnlock()
+
+	return o.groups[name]
+}
+
+func (o *namedObjects) bypass(name string) *gost.Bypass {
+	o.mux.RLock()
+	defer o.mux.RUnlock()
+
+	return o.bypasses[name]
+}
+
+func (o *namedObjects) resolver(name string) gost.Resolver {
+	o.mux.RLock()
+	defer o.mux.RUnlock()
+
+	return o.resolvers[name]
+}
+
+func (o *namedObjects) hostsOf(name string) *gost.Hosts {
+	o.mux.RLock()
+	defer o.mux.RUnlock()
+
+	return o.hosts[name]
+}
@@ -15,6 +15,7 @@ import (
 
 type PeerConfig struct {
 	Strategy    string `json:"strategy"`
+	HashKey     string `json:"hash_key"`
 	MaxFails    int    `json:"max_fails"`
 	FailTimeout time.Duration
 	PeriodF     time.Duration // the PeriodF for live reloading
@@ -22,11 +23,13 @@ type PeerConfig struct {
 	Group       *gost.NodeGroup
 	BaseNodes   []gost.Node
 	StoppedF    chan struct{}
+	named       *namedObjects // the named objects the nodes refer to
 }
 
 func newPeerConfig() *PeerConfig {
 	return &PeerConfig{
 		StoppedF: make(chan struct{}),
+		named:    named,
 	}
 }
 
@@ -53,13 +56,13 @@ func (cfg *PeerConfig) Reload(r io.Reader) error {
 			},
 			&gost.InvalidFilter{},
 		),
-		gost.WithStrategy(gost.NewStrategy(cfg.Strategy)),
+		gost.WithStrategy(ParseStrategy(cfg.Strategy, cfg.HashKey)),
 	)
 
 	gNodes := cfg.BaseNodes
 	nid := len(gNodes) + 1
 	for _, s := range cfg.Nodes {
-		nodes, err := ParseChainNode(s)
+		nodes, err := cfg.named.parseChainNode(s, false)
 		if err != nil {
 			return err
 		}
@@ -67,6 +70,7 @@ func (cfg *PeerConfig) Reload(r io.Reader) error {
 		for i := range nodes {
 			nodes[i].ID = nid
 			nid++
+			nodes[i].Pool.Start(nodes[i])
 		}
 
 		gNodes = append(gNodes, nodes...)
@@ -77,6 +81,7 @@ func (cfg *PeerConfig) Reload(r io.Reader) error {
 		if node.Bypass != nil {
 			node.Bypass.Stop() // clear the old nodes
 		}
+		node.Pool.Stop()
 	}
 
 	return nil
@@ -124,6 +129,8 @@ func (cfg *PeerConfig) parse(r io.Reader) error {
 		switch ss[0] {
 		case "strategy":
 			cfg.Strategy = ss[1]
+		case "hash_key":
+			cfg.HashKey = ss[1]
 		case "max_fails":
 			cfg.MaxFails, _ = strconv.Atoi(ss[1])
 		case "fail_timeout":
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"os"
+	"sync"
+
+	"github.com/go-log/log"
+
+	"github.com/far4599/gost-minimal"
+)
+
+var (
+	// the background services of the node groups, such as the peer reloaders and health checkers,
+	// they are stopped with the chains replaced on reload.
+	groupServices    = make(map[*gost.NodeGroup][]gost.Stoppable)
+	groupServicesMux sync.Mutex
+)
+
+var (
+	// the limits file of the global limiter
+	globalLimits string
+)
+
+// SetGlobalLimits sets the global bandwidth limiter from the limits file s, see ParseLimiter,
+// the running one is stopped.
+func SetGlobalLimits(s string) error {
+	limiter, err := ParseLimiter("", "", s)
+	if err != nil {
+		return err
+	}
+	setGlobalLimiter(limiter, s)
+	return nil
+}
+
+func setGlobalLimiter(limiter *gost.Limiter, s string) {
+	if old := gost.GlobalLimiter(); old != nil && old != limiter {
+		old.Stop()
+	}
+	gost.SetGlobalLimiter(limiter)
+	globalLimits = s
+}
+
+func addGroupService(group *gost.NodeGroup, s gost.Stoppable) {
+	groupServicesMux.Lock()
+	defer groupServicesMux.Unlock()
+
+	groupServices[group] = append(groupServices[group], s)
+}
+
+// stopChain stops the connection pools and the background services of the chain nodes.
+func stopChain(chain *gost.Chain) {
+	if chain == nil {
+		return
+	}
+
+	for _, group := range chain.NodeGroups() {
+		stopGroup(group)
+	}
+}
+
+// stopGroup stops the connection pools and the background services of the node group.
+func stopGroup(group *gost.NodeGroup) {
+	groupServicesMux.Lock()
+	defer groupServicesMux.Unlock()
+
+	for _, node := range group.Nodes() {
+		node.Pool.Stop()
+		if node.Bypass != nil {
+			node.Bypass.Stop()
+		}
+	}
+	for _, s := range groupServices[group] {
+		s.Stop()
+	}
+	delete(groupServices, group)
+}
+
+// ReloadRouters re-generates the routers of the config and diffs them against the running routers.
+// The routers on the unchanged listeners keep the listeners and the active sessions,
+// their handlers, chains and options are swapped in place.
+// The named objects, the global limiter and the routers are built aside, they replace the running ones only if all of them succeed,
+// then the listeners removed from the config are closed and the new listeners are served.
+// If it fails, the new objects are dropped and the running routers are returned untouched with the error.
+func ReloadRouters(baseCfg *BaseConfig, routers []*Router) ([]*Router, error) {
+	o, err := parseNamedObjects(baseCfg)
+	if err != nil {
+		return routers, err
+	}
+
+	// the global limiter is kept if the limits file is unchanged, the file is reloaded into it with the routers,
+	// so the active connections get the new limits.
+	limiter := gost.GlobalLimiter()
+	keepLimiter := limiter != nil && baseCfg.Limits == globalLimits
+	if keepLimiter {
+		_, err = loadLimiter("", "", baseCfg.Limits)
+	} else {
+		limiter, err = ParseLimiter("", "", baseCfg.Limits)
+	}
+	if err != nil {
+		o.stop()
+		return routers, err
+	}
+
+	routes := append([]Route{baseCfg.Route}, baseCfg.Routes...)
+	keys := make(map[string]bool)
+	addrs := make(map[string]bool)
+	for _, route := range routes {
+		for _, ns := range route.ServeNodes {
+			if node, err := gost.ParseNode(ns); err == nil {
+				keys[listenerKey(node)] = true
+				addrs[node.Addr] = true
+			}
+		}
+	}
+
+	running := make(map[string]*Router)
+	servers := make(map[*gost.Server]*Router)
+	var removed, closed []*Router
+	for _, rt := range routers {
+		key := listenerKey(rt.Node)
+		if key != "" && keys[key] && running[key] == nil {
+			running[key] = rt
+			servers[rt.Server] = rt
+			continue
+		}
+		removed = append(removed, rt)
+		// the address is taken by a changed serve node, the listener is closed first so it can be reused.
+		if addrs[rt.Node.Addr] {
+			rt.Server.Close()
+			closed = append(closed, rt)
+		}
+	}
+
+	var rts []Router
+	for _, route := range routes {
+		rs, err := route.genRouters(o, running)
+		rts = append(rts, rs...)
+		if err != nil {
+			// drop the new config, the running routers and the named objects are untouched.
+			for i := range rts {
+				if old := servers[rts[i].Server]; old != nil {
+					rts[i].stopExcept(old)
+				} else {
+					rts[i].Close()
+				}
+				stopChain(rts[i].Chain)
+			}
+			o.stop()
+			if !keepLimiter && limiter != nil {
+				limiter.Stop()
+			}
+			for _, rt := range closed {
+				if err := rt.relisten(); err != nil {
+					log.Logf("[reload] %s: %v", rt.Node.String(), err)
+					continue
+				}
+				go rt.Serve()
+			}
+			return routers, err
+		}
+	}
+
+	old := named.swap(o)
+	if keepLimiter {
+		reloadLimits(limiter, baseCfg.Limits)
+	} else {
+		setGlobalLimiter(limiter, baseCfg.Limits)
+	}
+
+	var result []*Router
+	for i := range rts {
+		rt := &rts[i]
+		if old := servers[rt.Server]; old != nil {
+			rt.handlers.set(rt.Handler)
+			old.stopExcept(rt)
+			delete(servers, rt.Server)
+		} else {
+			go rt.Serve()
+		}
+		result = append(result, rt)
+	}
+	for _, rt := range removed {
+		log.Logf("[reload] close %s on %s", rt.Node.String(), rt.Server.Addr())
+		rt.Close()
+	}
+	// the running routers whose listeners are not taken by the new config
+	for _, rt := range servers {
+		rt.Close()
+	}
+
+	// all the chains are re-built, the old ones are stopped.
+	for _, rt := range routers {
+		stopChain(rt.Chain)
+	}
+	old.stop()
+
+	return result, nil
+}
+
+// reloadLimits reloads the limits file s into the limiter.
+func reloadLimits(limiter *gost.Limiter, s string) {
+	f, err := os.Open(s)
+	if err != nil {
+		log.Logf("[reload] %s: %v", s, err)
+		return
+	}
+	defer f.Close()
+
+	if err := limiter.Reload(f); err != nil {
+		log.Logf("[reload] %s: %v", s, err)
+	}
+}
@@ -0,0 +1,348 @@
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/far4599/gost-minimal"
+)
+
+func genTestRouters(t *testing.T, cfg *BaseConfig) []*Router {
+	if err := ParseNamedObjects(cfg); err != nil {
+		t.Fatal(err)
+	}
+	rts, err := cfg.Route.GenRouters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routers []*Router
+	for i := range rts {
+		go rts[i].Serve()
+		routers = append(routers, &rts[i])
+	}
+	return routers
+}
+
+func dialRouter(rt *Router) error {
+	conn, err := net.Dial("tcp", rt.Server.Addr().String())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestReloadRouters(t *testing.T) {
+	routers := genTestRouters(t, &BaseConfig{
+		Route: Route{
+			ServeNodes: StringList{"http://127.0.0.1:0", "socks5://localhost:0"},
+			Chain:      "chain1",
+		},
+		Chains: map[string]StringList{"chain1": {"http://127.0.0.1:1"}},
+	})
+	defer func() {
+		for _, rt := range routers {
+			rt.Close()
+		}
+	}()
+	chain := named.chain("chain1")
+
+	// the second serve node fails, nothing is changed.
+	rs, err := ReloadRouters(&BaseConfig{
+		Route: Route{
+			ServeNodes: StringList{"http://127.0.0.1:0", "http://127.0.0.1:0?whitelist=tcp"},
+			Chain:      "chain2",
+		},
+		Chains: map[string]StringList{"chain2": {"http://127.0.0.1:2"}},
+	}, routers)
+	if err == nil {
+		t.Fatal("invalid config is reloaded")
+	}
+	if len(rs) != len(routers) || rs[0] != routers[0] || rs[1] != routers[1] {
+		t.Errorf("got routers %v, want %v", rs, routers)
+	}
+	if named.chain("chain1") != chain || named.chain("chain2") != nil {
+		t.Error("named chains are changed")
+	}
+	for _, rt := range routers {
+		if err := dialRouter(rt); err != nil {
+			t.Errorf("%s: %v", rt.Node.String(), err)
+		}
+	}
+
+	rs, err = ReloadRouters(&BaseConfig{
+		Route: Route{ServeNodes: StringList{"http://127.0.0.1:0"}},
+	}, routers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, rt := range rs {
+			rt.Close()
+		}
+	}()
+	if len(rs) != 1 || rs[0].Server != routers[0].Server {
+		t.Fatalf("listener is not reused, got routers %v", rs)
+	}
+	if named.chain("chain1") != nil {
+		t.Error("named chain is not replaced")
+	}
+	if err := dialRouter(rs[0]); err != nil {
+		t.Error(err)
+	}
+	if err := dialRouter(routers[1]); err == nil {
+		t.Error("removed listener is not closed")
+	}
+}
+
+func TestRouterStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	limits := writeTestFile(t, dir, "limits.txt", "* 1MB 1MB\n")
+	secrets := writeTestFile(t, dir, "secrets.txt", "admin 123456\n")
+	bypass := writeTestFile(t, dir, "bypass.txt", "*.example.com\n")
+	hosts := writeTestFile(t, dir, "hosts.txt", "127.0.0.1 example.com\n")
+
+	routers := genTestRouters(t, &BaseConfig{
+		Route: Route{
+			ServeNodes: StringList{
+				"http://127.0.0.1:0?limits=" + limits + "&secrets=" + secrets + "&bypass=" + bypass +
+					"&Hosts=" + hosts + "&dns=1.1.1.1:53/udp",
+			},
+			ChainNodes: StringList{"http://127.0.0.1:1?bypass=" + bypass},
+		},
+	})
+	rt := routers[0]
+	// the traffic, limiter, authenticator, bypass, hosts and resolver
+	if len(rt.services) != 6 {
+		t.Errorf("got %d services", len(rt.services))
+	}
+	nodeBypass := rt.Chain.Nodes()[0].Bypass
+
+	rt.Close()
+	stopChain(rt.Chain)
+	for _, s := range rt.services {
+		if !s.Stopped() {
+			t.Errorf("%T is not stopped", s)
+		}
+	}
+	if !nodeBypass.Stopped() {
+		t.Error("bypass of the chain node is not stopped")
+	}
+}
+
+func TestReloadRoutersKeepTraffic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	limits := writeTestFile(t, dir, "limits.txt", "* 1MB 1MB\n")
+	quota := writeTestFile(t, dir, "quota.txt", "admin 1GB\n")
+	store := filepath.Join(dir, "usage.json")
+	quota2 := writeTestFile(t, dir, "quota2.txt", "admin 2GB\n")
+	node := "http://127.0.0.1:0?limits=" + limits + "&quota_store=" + store + "&quota="
+
+	routers := genTestRouters(t, &BaseConfig{Route: Route{ServeNodes: StringList{node + quota}}})
+	defer routers[0].Close()
+	traffic, limiter := routers[0].Traffic, routers[0].Limiter
+
+	rs, err := ReloadRouters(&BaseConfig{Route: Route{ServeNodes: StringList{node + quota}}}, routers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].Traffic != traffic || rs[0].Limiter != limiter {
+		t.Fatal("traffic and limiter are not kept")
+	}
+	if traffic.Stopped() || limiter.Stopped() {
+		t.Error("kept traffic or limiter is stopped")
+	}
+
+	// the quota is changed, a new traffic is created from the saved usage.
+	rs, err = ReloadRouters(&BaseConfig{Route: Route{ServeNodes: StringList{node + quota2}}}, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].Traffic == traffic || !traffic.Stopped() {
+		t.Error("traffic is not replaced")
+	}
+	if rs[0].Limiter != limiter || limiter.Stopped() {
+		t.Error("limiter is not kept")
+	}
+	rs[0].stop()
+}
+
+func TestReloadGlobalLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	limits := writeTestFile(t, dir, "limits.txt", "* 1MB 1MB\n")
+	if err := SetGlobalLimits(limits); err != nil {
+		t.Fatal(err)
+	}
+	defer SetGlobalLimits("")
+	limiter := gost.GlobalLimiter()
+
+	node := StringList{"http://127.0.0.1:0"}
+	routers := genTestRouters(t, &BaseConfig{Route: Route{ServeNodes: node}, Limits: limits})
+
+	// the limit is changed in the same file, the limiter is kept.
+	writeTestFile(t, dir, "limits.txt", "* 2MB 1MB\n")
+	rs, err := ReloadRouters(&BaseConfig{Route: Route{ServeNodes: node}, Limits: limits}, routers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gost.GlobalLimiter() != limiter || limiter.Stopped() {
+		t.Fatal("global limiter is not kept")
+	}
+	if r, _ := limiter.Limits(""); r != 2<<20 {
+		t.Errorf("global limit is not reloaded, got %d", r)
+	}
+
+	// the invalid limits file fails the reload, the limiter is untouched.
+	rs, err = ReloadRouters(&BaseConfig{Route: Route{ServeNodes: node}, Limits: filepath.Join(dir, "none.txt")}, rs)
+	if err == nil {
+		t.Error("invalid limits file is reloaded")
+	}
+	if gost.GlobalLimiter() != limiter || limiter.Stopped() {
+		t.Error("global limiter is changed")
+	}
+
+	// the limits file is changed, the limiter is replaced.
+	limits2 := writeTestFile(t, dir, "limits2.txt", "* 3MB 3MB\n")
+	rs, err = ReloadRouters(&BaseConfig{Route: Route{ServeNodes: node}, Limits: limits2}, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gost.GlobalLimiter() == limiter || !limiter.Stopped() {
+		t.Error("global limiter is not replaced")
+	}
+	if r, _ := gost.GlobalLimiter().Limits(""); r != 3<<20 {
+		t.Errorf("wrong global limit %d", r)
+	}
+
+	// the limits are removed.
+	rs, err = ReloadRouters(&BaseConfig{Route: Route{ServeNodes: node}}, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs[0].Close()
+	if gost.GlobalLimiter() != nil {
+		t.Error("global limiter is not removed")
+	}
+}
+
+func TestReloadAccessLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "access.log")
+	node := "http://127.0.0.1:0?accesslog=" + name
+
+	routers := genTestRouters(t, &BaseConfig{Route: Route{ServeNodes: StringList{node}}})
+	l := accessLogs[name]
+	if l == nil || l.refs != 1 {
+		t.Fatal("access log is not opened")
+	}
+
+	// the rotation is changed, the logger is kept with the new rotation.
+	rs, err := ReloadRouters(&BaseConfig{Route: Route{ServeNodes: StringList{node + "&accesslog_maxsize=10B&accesslog_backups=1"}}}, routers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessLogs[name] != l || l.refs != 1 {
+		t.Fatalf("access log is not kept, refs %d", l.refs)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.logger.Log(&gost.AccessRecord{ID: uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(name + ".1"); err != nil {
+		t.Errorf("access log is not rotated: %v", err)
+	}
+
+	// the access log is removed from the serve node, the file is closed.
+	rs, err = ReloadRouters(&BaseConfig{Route: Route{ServeNodes: StringList{"http://127.0.0.1:0"}}}, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs[0].Close()
+	if accessLogs[name] != nil {
+		t.Error("access log is not released")
+	}
+	if err := l.logger.Log(&gost.AccessRecord{}); err != os.ErrClosed {
+		t.Errorf("access log is not closed, got %v", err)
+	}
+}
+
+func TestPeerRemoteChain(t *testing.T) {
+	var fetched int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		w.Write([]byte("peer http://127.0.0.1:3\n"))
+	}))
+	defer srv.Close()
+	peer := "peer=" + srv.URL + "/peers%23chain=true%26timeout=1s"
+
+	// the named group has no chain to fetch through.
+	err := ParseNamedObjects(&BaseConfig{
+		NodeGroups: map[string]StringList{"group1": {"http://127.0.0.1:2?" + peer}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "chain=true") {
+		t.Errorf("got error %v", err)
+	}
+
+	// the peers are fetched through the hops leading to the group, which is unavailable.
+	route := Route{ChainNodes: StringList{"http://127.0.0.1:1", "http://127.0.0.1:2?" + peer}}
+	if _, err := route.ParseChain(); err == nil {
+		t.Error("peers are fetched directly")
+	}
+	if v := atomic.LoadInt32(&fetched); v != 0 {
+		t.Errorf("remote source is fetched %d times", v)
+	}
+}
+
+// TestReloadNamedChain checks the serve nodes refer to the named objects of the reloaded config.
+func TestReloadNamedChain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin:{SHA}fEqNCco3Yq9h5ZUglD3CZJT4lBs=\n"))
+	}))
+	defer srv.Close()
+
+	routers := genTestRouters(t, &BaseConfig{Route: Route{ServeNodes: StringList{"http://127.0.0.1:0"}}})
+	rs, err := ReloadRouters(&BaseConfig{
+		Route: Route{
+			ServeNodes: StringList{
+				"http://127.0.0.1:0?htpasswd=" + srv.URL + "/htpasswd%23chain=chain2",
+				"http://127.0.0.1:0?secrets=" + srv.URL + "/secrets%23chain=chain2",
+			},
+		},
+		Chains: map[string]StringList{"chain2": {}},
+	}, routers)
+	if err != nil {
+		routers[0].Close()
+		t.Fatal(err)
+	}
+	for _, rt := range rs {
+		rt.Close()
+		rt.stop()
+	}
+}
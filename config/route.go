@@ -3,11 +3,13 @@ package config
 import (
 	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 
 	"github.com/go-log/log"
 
@@ -26,66 +28,136 @@ func (l *StringList) Set(value string) error {
 
 type Route struct {
 	ServeNodes StringList
-	ChainNodes StringList
+	ChainNodes StringList // chain nodes or names of the node groups
+	Chain      string     // name of the chain, it takes the place of the ChainNodes
 	Retries    int
 }
 
 func (r *Route) ParseChain() (*gost.Chain, error) {
+	return r.parseChain(named)
+}
+
+// parseChain parses the chain of the route, the named chains and node groups are looked up in o.
+func (r *Route) parseChain(o *namedObjects) (*gost.Chain, error) {
+	if r.Chain != "" {
+		chain := o.chain(r.Chain)
+		if chain == nil {
+			return nil, fmt.Errorf("unknown chain %s", r.Chain)
+		}
+		return chain, nil
+	}
+	return o.parseChain(r.ChainNodes, r.Retries)
+}
+
+// parseChain parses the chain, each hop is a chain node or the name of a node group.
+func (o *namedObjects) parseChain(hops []string, retries int) (*gost.Chain, error) {
 	chain := gost.NewChain()
-	chain.Retries = r.Retries
+	chain.Retries = retries
 	gid := 1 // Group ID
 
-	for _, ns := range r.ChainNodes {
-		ngroup := gost.NewNodeGroup()
-		ngroup.ID = gid
-		gid++
+	for _, ns := range hops {
+		if group := o.group(ns); group != nil {
+			chain.AddNodeGroup(group)
+			gid++
+			continue
+		}
+
+		// the peers of the group are fetched through the hops leading to it.
+		prefix := gost.NewChain()
+		prefix.Retries = retries
+		prefix.AddNodeGroup(chain.NodeGroups()...)
 
-		// parse the base nodes
-		nodes, err := ParseChainNode(ns)
+		ngroup, err := o.parseNodeGroup(gid, prefix, ns)
 		if err != nil {
 			return nil, err
 		}
+		gid++
 
-		nid := 1 // Node ID
-		for i := range nodes {
-			nodes[i].ID = nid
-			nid++
+		chain.AddNodeGroup(ngroup)
+	}
+
+	return chain, nil
+}
+
+// parseNodeGroup parses the nodes of the group,
+// the options of the group such as the strategy are taken from the first node.
+// The chain leads to the group, it is nil for the named groups.
+func (o *namedObjects) parseNodeGroup(gid int, chain *gost.Chain, nss ...string) (*gost.NodeGroup, error) {
+	ngroup := gost.NewNodeGroup()
+	ngroup.ID = gid
+
+	// parse the base nodes
+	var nodes []gost.Node
+	for _, ns := range nss {
+		nl, err := o.parseChainNode(ns, false)
+		if err != nil {
+			return nil, err
 		}
-		ngroup.AddNode(nodes...)
-
-		ngroup.SetSelector(nil,
-			gost.WithFilter(
-				&gost.FailFilter{
-					MaxFails:    nodes[0].GetInt("max_fails"),
-					FailTimeout: nodes[0].GetDuration("fail_timeout"),
-				},
-				&gost.InvalidFilter{},
-			),
-			gost.WithStrategy(gost.NewStrategy(nodes[0].Get("strategy"))),
-		)
+		nodes = append(nodes, nl...)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("empty node group")
+	}
 
-		if cfg := nodes[0].Get("peer"); cfg != "" {
+	nid := 1 // Node ID
+	for i := range nodes {
+		nodes[i].ID = nid
+		nid++
+		nodes[i].Pool.Start(nodes[i])
+	}
+	ngroup.AddNode(nodes...)
+
+	ngroup.SetSelector(nil,
+		gost.WithFilter(
+			&gost.FailFilter{
+				MaxFails:    nodes[0].GetInt("max_fails"),
+				FailTimeout: nodes[0].GetDuration("fail_timeout"),
+			},
+			&gost.InvalidFilter{},
+		),
+		gost.WithStrategy(ParseStrategy(nodes[0].Get("strategy"), nodes[0].Get("hash_key"))),
+	)
+
+	if cfg := nodes[0].Get("peer"); cfg != "" {
+		peerCfg := newPeerConfig()
+		peerCfg.Group = ngroup
+		peerCfg.BaseNodes = nodes
+		peerCfg.named = o
+
+		if gost.IsRemoteSource(cfg) {
+			if err := o.loadRemote(peerCfg, cfg, chain); err != nil {
+				return nil, err
+			}
+		} else {
 			f, err := os.Open(cfg)
 			if err != nil {
 				return nil, err
 			}
-
-			peerCfg := newPeerConfig()
-			peerCfg.Group = ngroup
-			peerCfg.BaseNodes = nodes
 			peerCfg.Reload(f)
 			f.Close()
 
 			go gost.PeriodReload(peerCfg, cfg)
 		}
+		addGroupService(ngroup, peerCfg)
+	}
 
-		chain.AddNodeGroup(ngroup)
+	if d := nodes[0].GetDuration("health_check"); d > 0 {
+		hc := gost.NewHealthChecker(ngroup, d, nodes[0].Get("health_target"))
+		hc.Timeout = nodes[0].GetDuration("health_timeout")
+		go hc.Run()
+		addGroupService(ngroup, hc)
 	}
 
-	return chain, nil
+	return ngroup, nil
 }
 
 func ParseChainNode(ns string) (nodes []gost.Node, err error) {
+	return named.parseChainNode(ns, false)
+}
+
+// parseChainNode parses the chain node ns, the bypass is not loaded if dry is true,
+// so no remote source is fetched and no reloader is started.
+func (o *namedObjects) parseChainNode(ns string, dry bool) (nodes []gost.Node, err error) {
 	node, err := gost.ParseNode(ns)
 	if err != nil {
 		return
@@ -188,7 +260,9 @@ func ParseChainNode(ns string) (nodes []gost.Node, err error) {
 		Transporter: tr,
 	}
 
-	node.Bypass = ParseBypass(node.Get("bypass"))
+	if !dry {
+		node.Bypass = o.parseBypass(node.Get("bypass"), nil)
+	}
 
 	ips := ParseIP(node.Get("ip"), sport)
 	for _, ip := range ips {
@@ -204,130 +278,194 @@ func ParseChainNode(ns string) (nodes []gost.Node, err error) {
 		nodes = []gost.Node{node}
 	}
 
+	// the pools are started by the node group, or lazily by the first connection.
+	if size := node.GetInt("pool"); size > 0 && !tr.Multiplex() {
+		for i := range nodes {
+			nodes[i].Pool = gost.NewConnPool(size, node.GetDuration("pool_idle"))
+		}
+	}
+
 	return
 }
 
 func (r *Route) GenRouters() ([]Router, error) {
-	chain, err := r.ParseChain()
+	return r.genRouters(named, nil)
+}
+
+// genRouters generates the routers of the serve nodes, the listeners of the running routers are reused by the listener key.
+// The routers generated before an error are returned with the error.
+func (r *Route) genRouters(o *namedObjects, running map[string]*Router) ([]Router, error) {
+	chain, err := r.parseChain(o)
 	if err != nil {
 		return nil, err
 	}
 
 	var rts []Router
-
 	for _, ns := range r.ServeNodes {
-		node, err := gost.ParseNode(ns)
+		rt, err := o.genRouter(ns, chain, running)
 		if err != nil {
-			return nil, err
+			return rts, err
 		}
+		rts = append(rts, rt)
+	}
 
-		if auth := node.Get("auth"); auth != "" && node.User == nil {
-			c, err := base64.StdEncoding.DecodeString(auth)
-			if err != nil {
-				return nil, err
-			}
-			cs := string(c)
-			s := strings.IndexByte(cs, ':')
-			if s < 0 {
-				node.User = url.User(cs)
-			} else {
-				node.User = url.UserPassword(cs[:s], cs[s+1:])
-			}
-		}
-		authenticator, err := ParseAuthenticator(node.Get("secrets"))
+	return rts, nil
+}
+
+func (o *namedObjects) genRouter(ns string, chain *gost.Chain, running map[string]*Router) (rt Router, err error) {
+	node, err := gost.ParseNode(ns)
+	if err != nil {
+		return Router{}, err
+	}
+
+	if auth := node.Get("auth"); auth != "" && node.User == nil {
+		c, err := base64.StdEncoding.DecodeString(auth)
 		if err != nil {
-			return nil, err
+			return Router{}, err
 		}
-		if authenticator == nil && node.User != nil {
-			kvs := make(map[string]string)
-			kvs[node.User.Username()], _ = node.User.Password()
-			authenticator = gost.NewLocalAuthenticator(kvs)
+		cs := string(c)
+		s := strings.IndexByte(cs, ':')
+		if s < 0 {
+			node.User = url.User(cs)
+		} else {
+			node.User = url.UserPassword(cs[:s], cs[s+1:])
 		}
-		if node.User == nil {
-			if users, _ := ParseUsers(node.Get("secrets")); len(users) > 0 {
-				node.User = users[0]
+	}
+	// the reloaders started for the router, they are stopped with it.
+	// The named objects are shared, they are stopped with the named objects.
+	var services []gost.Stoppable
+	defer func() {
+		if err != nil {
+			for _, s := range services {
+				s.Stop()
 			}
 		}
-		certFile, keyFile := node.Get("cert"), node.Get("key")
-		tlsCfg, err := TlsConfig(certFile, keyFile)
-		if err != nil && certFile != "" && keyFile != "" {
-			return nil, err
+	}()
+
+	authenticator, err := o.parseNodeAuthenticator(node, chain)
+	if err != nil {
+		return Router{}, err
+	}
+	if s, ok := authenticator.(gost.Stoppable); ok {
+		services = append(services, s)
+	}
+	if authenticator == nil && node.User != nil {
+		kvs := make(map[string]string)
+		kvs[node.User.Username()], _ = node.User.Password()
+		authenticator = gost.NewLocalAuthenticator(kvs)
+	}
+	if node.User == nil {
+		if users, _ := ParseUsers(node.Get("secrets")); len(users) > 0 {
+			node.User = users[0]
 		}
+	}
+	certFile, keyFile := node.Get("cert"), node.Get("key")
+	tlsCfg, err := TlsConfig(certFile, keyFile)
+	if err != nil && certFile != "" && keyFile != "" {
+		return Router{}, err
+	}
 
-		ttl := node.GetDuration("ttl")
-		timeout := node.GetDuration("timeout")
+	ttl := node.GetDuration("ttl")
+	timeout := node.GetDuration("timeout")
 
+	// the listener of the running router with the same key is reused, so are its active sessions.
+	key := listenerKey(node)
+	var server *gost.Server
+	var handlers *handlerSwitch
+	var prev *Router
+	if old := running[key]; key != "" && old != nil && old.handlers != nil {
+		delete(running, key)
+		server, handlers, prev = old.Server, old.handlers, old
+	} else {
 		var ln gost.Listener
-		switch node.Transport {
-		case "tls":
-			ln, err = gost.TLSListener(node.Addr, tlsCfg)
-		case "mtls":
-			ln, err = gost.MTLSListener(node.Addr, tlsCfg)
-		case "tcp":
-			ln, err = gost.TCPListener(node.Addr)
-		case "rtcp":
-			ln, err = gost.TCPRemoteForwardListener(node.Addr, chain)
-		case "dns":
-			ln, err = gost.DNSListener(
-				node.Addr,
-				&gost.DNSOptions{
-					Mode:      node.Get("mode"),
-					TLSConfig: tlsCfg,
-				},
-			)
-		default:
-			ln, err = gost.TCPListener(node.Addr)
-		}
+		ln, err = listen(node, tlsCfg, chain)
 		if err != nil {
-			return nil, err
+			return Router{}, err
 		}
-
-		var handler gost.Handler
-		switch node.Protocol {
-		case "socks", "socks5":
-			handler = gost.SOCKS5Handler()
-		case "socks4", "socks4a":
-			handler = gost.SOCKS4Handler()
-		case "http":
-			handler = gost.HTTPHandler()
-		case "tcp":
-			handler = gost.TCPDirectForwardHandler(node.Remote)
-		case "rtcp":
-			handler = gost.TCPRemoteForwardHandler(node.Remote)
-		case "udp":
-			handler = gost.UDPDirectForwardHandler(node.Remote)
-		case "rudp":
-			handler = gost.UDPRemoteForwardHandler(node.Remote)
-		case "sni":
-			handler = gost.SNIHandler()
-		case "dns":
-			handler = gost.DNSHandler(node.Remote)
-		default:
-			// start from 2.5, if remote is not empty, then we assume that it is a forward tunnel.
-			if node.Remote != "" {
-				handler = gost.TCPDirectForwardHandler(node.Remote)
-			} else {
-				handler = gost.AutoHandler()
+		defer func() {
+			if err != nil {
+				ln.Close()
 			}
+		}()
+		server, handlers = &gost.Server{Listener: ln}, &handlerSwitch{}
+	}
+
+	handler := newHandler(node)
+
+	var whitelist, blacklist *gost.Permissions
+	if node.Values.Get("whitelist") != "" {
+		if whitelist, err = gost.ParsePermissions(node.Get("whitelist")); err != nil {
+			return Router{}, err
 		}
+	}
+	if node.Values.Get("blacklist") != "" {
+		if blacklist, err = gost.ParsePermissions(node.Get("blacklist")); err != nil {
+			return Router{}, err
+		}
+	}
 
-		var whitelist, blacklist *gost.Permissions
-		if node.Values.Get("whitelist") != "" {
-			if whitelist, err = gost.ParsePermissions(node.Get("whitelist")); err != nil {
-				return nil, err
-			}
+	// the limiter and traffic of the running router are kept if their options are unchanged,
+	// so are the token buckets and the traffic counters of the active sessions.
+	var limiter *gost.Limiter
+	if prev != nil && sameOptions(prev.Node, node, "rlimit", "wlimit", "limits") {
+		limiter = prev.Limiter
+	} else if limiter, err = ParseLimiter(node.Get("rlimit"), node.Get("wlimit"), node.Get("limits")); err != nil {
+		return Router{}, err
+	}
+	if limiter != nil {
+		services = append(services, limiter)
+	}
+
+	var traffic *gost.Traffic
+	if prev != nil && prev.Traffic != nil && sameOptions(prev.Node, node, "quota", "quota_store") {
+		traffic = prev.Traffic
+	} else {
+		handover := prev != nil && prev.Traffic != nil && sameOptions(prev.Node, node, "quota_store")
+		if handover {
+			prev.Traffic.Save() // hand the usage over to the new traffic through the store
 		}
-		if node.Values.Get("blacklist") != "" {
-			if blacklist, err = gost.ParsePermissions(node.Get("blacklist")); err != nil {
-				return nil, err
-			}
+		if traffic, err = ParseTraffic(node.Get("quota"), node.Get("quota_store")); err != nil {
+			return Router{}, err
+		}
+		if handover {
+			traffic.TakeOver(prev.Traffic)
 		}
+	}
+	services = append(services, traffic)
+	accessLog, accessLogRef, err := ParseAccessLog(node.Get("accesslog"), node.Get("accesslog_maxsize"), node.Get("accesslog_backups"))
+	if err != nil {
+		return Router{}, err
+	}
+	if accessLogRef != nil {
+		services = append(services, accessLogRef)
+	}
 
-		node.Bypass = ParseBypass(node.Get("bypass"))
-		hosts := ParseHosts(node.Get("Hosts"))
-		ips := ParseIP(node.Get("ip"), "")
+	var logger gost.LeveledLogger
+	if s := node.Get("loglevel"); s != "" {
+		level, err := gost.ParseLogLevel(s)
+		if err != nil {
+			return Router{}, err
+		}
+		logger = gost.NewLeveledLogger(level)
+	}
 
-		resolver := ParseResolver(node.Get("dns"))
+	node.Bypass = o.parseBypass(node.Get("bypass"), chain)
+	if node.Bypass != nil && node.Bypass != o.bypass(node.Get("bypass")) {
+		services = append(services, node.Bypass)
+	}
+	hosts := o.parseHosts(node.Get("Hosts"), chain)
+	if hosts != nil && hosts != o.hostsOf(node.Get("Hosts")) {
+		services = append(services, hosts)
+	}
+	ips := ParseIP(node.Get("ip"), "")
+
+	// the named resolver is shared and initialized once.
+	resolver := o.resolver(node.Get("dns"))
+	if resolver == nil {
+		resolver = o.parseResolver(node.Get("dns"), chain)
+		if s, ok := resolver.(gost.Stoppable); ok {
+			services = append(services, s)
+		}
 		if resolver != nil {
 			resolver.Init(
 				gost.ChainResolverOption(chain),
@@ -335,44 +473,176 @@ func (r *Route) GenRouters() ([]Router, error) {
 				gost.TTLResolverOption(ttl),
 				gost.PreferResolverOption(node.Get("prefer")),
 				gost.SrcIPResolverOption(net.ParseIP(node.Get("ip"))),
+				gost.LoggerResolverOption(logger),
 			)
 		}
+	}
+
+	// the routing rules are bound to the serve node, so the chain is not shared with the others.
+	routeChain := chain
+	rules, err := o.parseRules(node.Get("rules"))
+	if err != nil {
+		return Router{}, err
+	}
+	if rules != nil {
+		services = append(services, rules)
+	}
+	if rules != nil {
+		routeChain = gost.NewChain()
+		routeChain.Retries = chain.Retries
+		routeChain.AddNodeGroup(chain.NodeGroups()...)
+		routeChain.Rules = rules
+	}
+
+	options := []gost.HandlerOption{
+		gost.AddrHandlerOption(server.Addr().String()),
+		gost.ChainHandlerOption(routeChain),
+		gost.UsersHandlerOption(node.User),
+		gost.AuthenticatorHandlerOption(authenticator),
+		gost.TLSConfigHandlerOption(tlsCfg),
+		gost.WhitelistHandlerOption(whitelist),
+		gost.BlacklistHandlerOption(blacklist),
+		gost.StrategyHandlerOption(ParseStrategy(node.Get("strategy"), node.Get("hash_key"))),
+		gost.MaxFailsHandlerOption(node.GetInt("max_fails")),
+		gost.FailTimeoutHandlerOption(node.GetDuration("fail_timeout")),
+		gost.BypassHandlerOption(node.Bypass),
+		gost.ResolverHandlerOption(resolver),
+		gost.HostsHandlerOption(hosts),
+		gost.RetryHandlerOption(node.GetInt("retry")), // override the global retry option.
+		gost.TimeoutHandlerOption(timeout),
+		gost.RaceHandlerOption(node.GetInt("race"), node.GetDuration("race_delay")),
+		gost.ProbeResistHandlerOption(node.Get("probe_resist")),
+		gost.KnockingHandlerOption(node.Get("knock")),
+		gost.NodeHandlerOption(node),
+		gost.IPsHandlerOption(ips),
+		gost.TCPModeHandlerOption(node.GetBool("tcp")),
+		gost.LimiterHandlerOption(limiter),
+		gost.TrafficHandlerOption(traffic),
+		gost.AccessLogHandlerOption(accessLog),
+		gost.LoggerHandlerOption(logger),
+	}
+	handler.Init(options...)
+
+	if handlers.handler.Load() == nil {
+		handlers.set(handler) // the handler of the running router is swapped after the reload succeeds
+	}
+
+	return Router{
+		Node:     node,
+		Server:   server,
+		Handler:  handler,
+		handlers: handlers,
+		options:  options,
+		Chain:    routeChain,
+		Rules:    rules,
+		Resolver: resolver,
+		Hosts:    hosts,
+		Traffic:  traffic,
+		Limiter:  limiter,
+
+		Bypass:    node.Bypass,
+		Whitelist: node.Get("whitelist"),
+		Blacklist: node.Get("blacklist"),
+
+		services: services,
+	}, nil
+}
+
+// sameOptions checks whether the options of the nodes a and b are the same.
+func sameOptions(a, b gost.Node, options ...string) bool {
+	for _, s := range options {
+		if a.Get(s) != b.Get(s) {
+			return false
+		}
+	}
+	return true
+}
 
-		handler.Init(
-			gost.AddrHandlerOption(ln.Addr().String()),
-			gost.ChainHandlerOption(chain),
-			gost.UsersHandlerOption(node.User),
-			gost.AuthenticatorHandlerOption(authenticator),
-			gost.TLSConfigHandlerOption(tlsCfg),
-			gost.WhitelistHandlerOption(whitelist),
-			gost.BlacklistHandlerOption(blacklist),
-			gost.StrategyHandlerOption(gost.NewStrategy(node.Get("strategy"))),
-			gost.MaxFailsHandlerOption(node.GetInt("max_fails")),
-			gost.FailTimeoutHandlerOption(node.GetDuration("fail_timeout")),
-			gost.BypassHandlerOption(node.Bypass),
-			gost.ResolverHandlerOption(resolver),
-			gost.HostsHandlerOption(hosts),
-			gost.RetryHandlerOption(node.GetInt("retry")), // override the global retry option.
-			gost.TimeoutHandlerOption(timeout),
-			gost.ProbeResistHandlerOption(node.Get("probe_resist")),
-			gost.KnockingHandlerOption(node.Get("knock")),
-			gost.NodeHandlerOption(node),
-			gost.IPsHandlerOption(ips),
-			gost.TCPModeHandlerOption(node.GetBool("tcp")),
+// listen creates the listener of the serve node by the transport.
+func listen(node gost.Node, tlsCfg *tls.Config, chain *gost.Chain) (gost.Listener, error) {
+	switch node.Transport {
+	case "tls":
+		return gost.TLSListener(node.Addr, tlsCfg)
+	case "mtls":
+		return gost.MTLSListener(node.Addr, tlsCfg)
+	case "tcp":
+		return gost.TCPListener(node.Addr)
+	case "rtcp":
+		return gost.TCPRemoteForwardListener(node.Addr, chain)
+	case "dns":
+		return gost.DNSListener(
+			node.Addr,
+			&gost.DNSOptions{
+				Mode:      node.Get("mode"),
+				TLSConfig: tlsCfg,
+			},
 		)
+	default:
+		return gost.TCPListener(node.Addr)
+	}
+}
 
-		rt := Router{
-			Node:     node,
-			Server:   &gost.Server{Listener: ln},
-			Handler:  handler,
-			Chain:    chain,
-			Resolver: resolver,
-			Hosts:    hosts,
+// newHandler creates the handler of the serve node by the protocol.
+func newHandler(node gost.Node) gost.Handler {
+	switch node.Protocol {
+	case "socks", "socks5":
+		return gost.SOCKS5Handler()
+	case "socks4", "socks4a":
+		return gost.SOCKS4Handler()
+	case "http":
+		return gost.HTTPHandler()
+	case "tcp":
+		return gost.TCPDirectForwardHandler(node.Remote)
+	case "rtcp":
+		return gost.TCPRemoteForwardHandler(node.Remote)
+	case "udp":
+		return gost.UDPDirectForwardHandler(node.Remote)
+	case "rudp":
+		return gost.UDPRemoteForwardHandler(node.Remote)
+	case "sni":
+		return gost.SNIHandler()
+	case "dns":
+		return gost.DNSHandler(node.Remote)
+	default:
+		// start from 2.5, if remote is not empty, then we assume that it is a forward tunnel.
+		if node.Remote != "" {
+			return gost.TCPDirectForwardHandler(node.Remote)
 		}
-		rts = append(rts, rt)
+		return gost.AutoHandler()
 	}
+}
 
-	return rts, nil
+// listenerKey identifies the listener of the serve node, the listener is reused on reload if the key is unchanged.
+// The remote forwarding listeners depend on the chain, they are not reused.
+func listenerKey(node gost.Node) string {
+	if node.Transport == "rtcp" {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s?cert=%s&key=%s&mode=%s",
+		node.Transport, node.Addr, node.Get("cert"), node.Get("key"), node.Get("mode"))
+}
+
+// handlerSwitch passes the connections to the current handler of the router,
+// so the handler can be swapped without closing the listener.
+type handlerSwitch struct {
+	handler atomic.Value
+}
+
+// handlerValue wraps the handler, as atomic.Value requires the values of the same concrete type.
+type handlerValue struct {
+	gost.Handler
+}
+
+func (s *handlerSwitch) set(h gost.Handler) {
+	s.handler.Store(handlerValue{h})
+}
+
+func (s *handlerSwitch) Init(options ...gost.HandlerOption) {
+	s.handler.Load().(handlerValue).Init(options...)
+}
+
+func (s *handlerSwitch) Handle(conn net.Conn) {
+	s.handler.Load().(handlerValue).Handle(conn)
 }
 
 type Router struct {
@@ -382,16 +652,100 @@ type Router struct {
 	Chain    *gost.Chain
 	Resolver gost.Resolver
 	Hosts    *gost.Hosts
+	Traffic  *gost.Traffic
+	Limiter  *gost.Limiter
+	Rules    *gost.Rules
+
+	Bypass    *gost.Bypass
+	Whitelist string // the whitelist permissions
+	Blacklist string // the blacklist permissions
+
+	handlers *handlerSwitch
+	options  []gost.HandlerOption // the options the handler is initialized with
+	services []gost.Stoppable     // the reloaders of the router, such as the traffic, rules and bypass
 }
 
 func (r *Router) Serve() error {
 	log.Logf("%s on %s", r.Node.String(), r.Server.Addr())
-	return r.Server.Serve(r.Handler)
+	var h gost.Handler = r.handlers
+	if r.handlers == nil {
+		h = r.Handler
+	}
+	return r.Server.Serve(h, gost.NodeServerOption(r.Node))
+}
+
+// SetBypass swaps the handler of the router for a new one with the bypass bp,
+// the connections being handled keep the old handler.
+func (r *Router) SetBypass(bp *gost.Bypass) {
+	r.Bypass = bp
+	r.swapHandler(gost.BypassHandlerOption(bp))
+}
+
+// SetWhitelist swaps the handler of the router for a new one with the whitelist permissions s.
+func (r *Router) SetWhitelist(s string, ps *gost.Permissions) {
+	r.Whitelist = s
+	r.swapHandler(gost.WhitelistHandlerOption(ps))
+}
+
+// SetBlacklist swaps the handler of the router for a new one with the blacklist permissions s.
+func (r *Router) SetBlacklist(s string, ps *gost.Permissions) {
+	r.Blacklist = s
+	r.swapHandler(gost.BlacklistHandlerOption(ps))
+}
+
+// swapHandler creates a new handler with the options of the router overridden by the opts,
+// the serving handler is never re-initialized, as it is not safe for the concurrent use.
+func (r *Router) swapHandler(opts ...gost.HandlerOption) {
+	options := make([]gost.HandlerOption, 0, len(r.options)+len(opts))
+	options = append(options, r.options...)
+	options = append(options, opts...)
+
+	h := newHandler(r.Node)
+	h.Init(options...)
+	r.options = options
+	r.Handler = h
+	if r.handlers != nil {
+		r.handlers.set(h)
+	}
+}
+
+// relisten opens the listener of the router again after it is closed.
+func (r *Router) relisten() error {
+	tlsCfg, _ := TlsConfig(r.Node.Get("cert"), r.Node.Get("key"))
+	ln, err := listen(r.Node, tlsCfg, r.Chain)
+	if err != nil {
+		return err
+	}
+	r.Server = &gost.Server{Listener: ln}
+	return nil
 }
 
 func (r *Router) Close() error {
 	if r == nil || r.Server == nil {
 		return nil
 	}
+	r.stop()
 	return r.Server.Close()
 }
+
+// stop stops the reloaders of the router, such as the traffic, rules, limiter, bypass and authenticator,
+// the listener is kept.
+func (r *Router) stop() {
+	r.stopExcept(nil)
+}
+
+// stopExcept stops the reloaders of the router which are not shared with the router other,
+// such as the traffic and limiter taken over on reload.
+func (r *Router) stopExcept(other *Router) {
+	shared := make(map[gost.Stoppable]bool)
+	if other != nil {
+		for _, s := range other.services {
+			shared[s] = true
+		}
+	}
+	for _, s := range r.services {
+		if !shared[s] {
+			s.Stop()
+		}
+	}
+}
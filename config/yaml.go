@@ -0,0 +1,639 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/far4599/gost-minimal"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLConfig is the structured config in YAML,
+// the listeners, handlers, chains, hops and their options are spelled out as fields
+// instead of the URL form of the -L/-F flags.
+//
+//	services:
+//	- name: proxy
+//	  addr: :8080
+//	  handler: http
+//	  listener: tls
+//	  auth: {username: admin, password: "123456"}
+//	  chain: upstream
+//	  options: {bypass: "~*.example.com", dns: "1.1.1.1:53/udp"}
+//	chains:
+//	- name: upstream
+//	  hops:
+//	  - name: hop-0
+//	    options: {strategy: round, max_fails: 3}
+//	    nodes:
+//	    - addr: 192.168.1.1:1080
+//	      connector: socks5
+//	      dialer: tls
+type YAMLConfig struct {
+	Services  []*ServiceConfig  `yaml:"services"`
+	Chains    []*ChainConfig    `yaml:"chains,omitempty"`
+	Bypasses  map[string]string `yaml:"bypasses,omitempty"`  // the named bypasses, in the format of the bypass option
+	Resolvers map[string]string `yaml:"resolvers,omitempty"` // the named resolvers, in the format of the dns option
+	Hosts     map[string]string `yaml:"hosts,omitempty"`     // the named hosts files
+	Debug     bool              `yaml:"debug,omitempty"`
+	Limits    string            `yaml:"limits,omitempty"`
+	Metrics   string            `yaml:"metrics,omitempty"`
+	Admin     string            `yaml:"admin,omitempty"`
+}
+
+// ServiceConfig is a serve node, the counterpart of the -L flag.
+type ServiceConfig struct {
+	Name     string            `yaml:"name,omitempty"`
+	Addr     string            `yaml:"addr"`
+	Handler  string            `yaml:"handler,omitempty"`  // http, socks5, ss, tcp, ..., auto by default
+	Listener string            `yaml:"listener,omitempty"` // tls, ws, kcp, ..., tcp by default
+	Auth     *AuthConfig       `yaml:"auth,omitempty"`
+	Forward  string            `yaml:"forward,omitempty"` // the target addresses of port forwarding
+	Chain    string            `yaml:"chain,omitempty"`   // name of the chain
+	Options  map[string]string `yaml:"options,omitempty"`
+}
+
+// ChainConfig is a named chain, the counterpart of the -F flags.
+type ChainConfig struct {
+	Name string       `yaml:"name"`
+	Hops []*HopConfig `yaml:"hops"`
+}
+
+// HopConfig is a node group of the chain.
+// The options are shared by the nodes, such as strategy, max_fails and fail_timeout.
+type HopConfig struct {
+	Name    string            `yaml:"name,omitempty"`
+	Nodes   []*NodeConfig     `yaml:"nodes"`
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+// NodeConfig is a chain node.
+type NodeConfig struct {
+	Name      string            `yaml:"name,omitempty"`
+	Addr      string            `yaml:"addr"`
+	Connector string            `yaml:"connector,omitempty"` // http, socks5, ss, ..., auto by default
+	Dialer    string            `yaml:"dialer,omitempty"`    // tls, ws, kcp, ..., tcp by default
+	Auth      *AuthConfig       `yaml:"auth,omitempty"`
+	Options   map[string]string `yaml:"options,omitempty"`
+}
+
+type AuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// IsYAMLConfig checks whether the config file s is in YAML by its extension.
+func IsYAMLConfig(s string) bool {
+	switch strings.ToLower(filepath.Ext(s)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// ParseYAMLConfig parses and validates the YAML config file s.
+func ParseYAMLConfig(s string) (*YAMLConfig, error) {
+	data, err := ioutil.ReadFile(s)
+	if err != nil {
+		return nil, err
+	}
+	return LoadYAMLConfig(data)
+}
+
+// LoadYAMLConfig validates the YAML config data against the schema and decodes it.
+// The errors are reported with the line numbers.
+func LoadYAMLConfig(data []byte) (*YAMLConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, errors.New("empty config")
+	}
+
+	root := doc.Content[0]
+	var errs configErrors
+	yamlSchema.validate(root, "", &errs)
+	errs.checkNames(root)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	cfg := &YAMLConfig{}
+	if err := root.Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Apply converts the config to the URL form and applies it to the base config.
+// Each hop of the chains becomes a named node group, named by the chain and hop name.
+func (c *YAMLConfig) Apply(baseCfg *BaseConfig) error {
+	if baseCfg.Chains == nil {
+		baseCfg.Chains = make(map[string]StringList)
+	}
+	if baseCfg.NodeGroups == nil {
+		baseCfg.NodeGroups = make(map[string]StringList)
+	}
+	for _, chain := range c.Chains {
+		var hops StringList
+		for i, hop := range chain.Hops {
+			name := hop.Name
+			if name == "" {
+				name = "hop-" + strconv.Itoa(i)
+			}
+			name = chain.Name + "/" + name
+
+			var nodes StringList
+			for _, node := range hop.Nodes {
+				// the hop options are the defaults of the nodes
+				options := make(map[string]string)
+				for k, v := range hop.Options {
+					options[k] = v
+				}
+				for k, v := range node.Options {
+					options[k] = v
+				}
+				nodes = append(nodes, nodeURL(node.Connector, node.Dialer, node.Addr, "", node.Auth, options))
+			}
+			baseCfg.NodeGroups[name] = nodes
+			hops = append(hops, name)
+		}
+		baseCfg.Chains[chain.Name] = hops
+	}
+
+	for _, svc := range c.Services {
+		baseCfg.Routes = append(baseCfg.Routes, Route{
+			ServeNodes: StringList{nodeURL(svc.Handler, svc.Listener, svc.Addr, svc.Forward, svc.Auth, svc.Options)},
+			Chain:      svc.Chain,
+		})
+	}
+
+	baseCfg.Bypasses = mergeNamed(baseCfg.Bypasses, c.Bypasses)
+	baseCfg.Resolvers = mergeNamed(baseCfg.Resolvers, c.Resolvers)
+	baseCfg.Hosts = mergeNamed(baseCfg.Hosts, c.Hosts)
+
+	baseCfg.Debug = baseCfg.Debug || c.Debug
+	if c.Limits != "" {
+		baseCfg.Limits = c.Limits
+	}
+	if c.Metrics != "" {
+		baseCfg.Metrics = c.Metrics
+	}
+	if c.Admin != "" {
+		baseCfg.Admin = c.Admin
+	}
+
+	return nil
+}
+
+func mergeNamed(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string)
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// nodeURL builds the node URL, the ${...} placeholders are kept as is and interpolated when the node is parsed.
+func nodeURL(protocol, transport, addr, remote string, auth *AuthConfig, options map[string]string) string {
+	var p placeholders
+	protocol, transport, addr, remote = p.protect(protocol), p.protect(transport), p.protect(addr), p.protect(remote)
+
+	scheme := protocol
+	if scheme == "" {
+		scheme = "auto"
+	}
+	if transport != "" {
+		scheme += "+" + transport
+	}
+
+	u := url.URL{
+		Scheme: scheme,
+		Host:   addr,
+	}
+	if remote != "" {
+		u.Path = "/" + remote
+	}
+	if auth != nil {
+		if auth.Password != "" {
+			u.User = url.UserPassword(p.protect(auth.Username), p.protect(auth.Password))
+		} else {
+			u.User = url.User(p.protect(auth.Username))
+		}
+	}
+	values := url.Values{}
+	for k, v := range options {
+		values.Set(k, p.protect(v))
+	}
+	u.RawQuery = values.Encode()
+
+	return p.restore(u.String())
+}
+
+// NewYAMLConfig converts the base config in the URL form, such as the -L/-F flags, to the YAML config.
+// The chain of a route is named as chain-N, the named node groups referred by the chains are expanded to the hops.
+func NewYAMLConfig(baseCfg *BaseConfig) (*YAMLConfig, error) {
+	c := &YAMLConfig{
+		Bypasses:  baseCfg.Bypasses,
+		Resolvers: baseCfg.Resolvers,
+		Hosts:     baseCfg.Hosts,
+		Debug:     baseCfg.Debug,
+		Limits:    baseCfg.Limits,
+		Metrics:   baseCfg.Metrics,
+		Admin:     baseCfg.Admin,
+	}
+
+	var names []string
+	for name := range baseCfg.Chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		chain, err := newChainConfig(name, baseCfg.Chains[name], baseCfg.NodeGroups)
+		if err != nil {
+			return nil, err
+		}
+		c.Chains = append(c.Chains, chain)
+	}
+
+	routes := append([]Route{baseCfg.Route}, baseCfg.Routes...)
+	for i, route := range routes {
+		chainName := route.Chain
+		if chainName == "" && len(route.ChainNodes) > 0 {
+			chainName = "chain-" + strconv.Itoa(i)
+			chain, err := newChainConfig(chainName, route.ChainNodes, baseCfg.NodeGroups)
+			if err != nil {
+				return nil, err
+			}
+			c.Chains = append(c.Chains, chain)
+		}
+
+		for _, ns := range route.ServeNodes {
+			u, err := parseNodeURL(ns)
+			if err != nil {
+				return nil, err
+			}
+			svc := &ServiceConfig{
+				Name:    "service-" + strconv.Itoa(len(c.Services)),
+				Addr:    u.Host,
+				Forward: strings.Trim(u.EscapedPath(), "/"),
+				Auth:    authConfig(u.User),
+				Chain:   chainName,
+				Options: optionsOf(u.Query()),
+			}
+			svc.Handler, svc.Listener = splitScheme(u.Scheme)
+			c.Services = append(c.Services, svc)
+		}
+	}
+
+	return c, nil
+}
+
+func newChainConfig(name string, hops StringList, groups map[string]StringList) (*ChainConfig, error) {
+	chain := &ChainConfig{Name: name}
+	for i, ns := range hops {
+		hop := &HopConfig{Name: "hop-" + strconv.Itoa(i)}
+		nodes := StringList{ns}
+		if group, ok := groups[ns]; ok {
+			hop.Name = strings.TrimPrefix(ns, name+"/") // the hops converted by Apply
+			nodes = group
+		}
+		for _, s := range nodes {
+			u, err := parseNodeURL(s)
+			if err != nil {
+				return nil, err
+			}
+			node := &NodeConfig{
+				Addr:    u.Host,
+				Auth:    authConfig(u.User),
+				Options: optionsOf(u.Query()),
+			}
+			node.Connector, node.Dialer = splitScheme(u.Scheme)
+			hop.Nodes = append(hop.Nodes, node)
+		}
+		chain.Hops = append(chain.Hops, hop)
+	}
+	return chain, nil
+}
+
+// parseNodeURL parses the node URL s, the ${...} placeholders are kept as is in the host, user info and query.
+func parseNodeURL(s string) (*url.URL, error) {
+	var p placeholders
+	s = p.protect(strings.TrimSpace(s))
+	if !strings.Contains(s, "://") {
+		s = "auto://" + s
+	}
+	// the port can not be a placeholder for url.Parse, so the host with a placeholder is cut out and set after.
+	var host string
+	if i := strings.Index(s, "://") + 3; len(p) > 0 {
+		end := strings.IndexAny(s[i:], "/?#")
+		if end < 0 {
+			end = len(s) - i
+		}
+		at := strings.LastIndex(s[i:i+end], "@") + 1
+		if h := s[i+at : i+end]; strings.Contains(h, "gostph") {
+			host = h
+			s = s[:i+at] + s[i+end:]
+		}
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			ue.URL = gost.Redact(p.restore(ue.URL))
+		}
+		return nil, err
+	}
+	if len(p) == 0 {
+		return u, nil
+	}
+
+	if host != "" {
+		u.Host = host
+	}
+	u.Scheme = p.restore(u.Scheme)
+	u.Host = p.restore(u.Host)
+	u.RawQuery = p.restore(u.RawQuery)
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(p.restore(u.User.Username()), p.restore(password))
+		} else {
+			u.User = url.User(p.restore(u.User.Username()))
+		}
+	}
+	return u, nil
+}
+
+var placeholderRegexp = regexp.MustCompile(`\$?\$\{[^}]*\}`)
+
+// placeholders protects the ${...} placeholders of gost.Interpolate from the URL parsing and escaping,
+// they are replaced by the alphanumeric tokens, and restored after.
+type placeholders []string
+
+func (p *placeholders) protect(s string) string {
+	return placeholderRegexp.ReplaceAllStringFunc(s, func(v string) string {
+		*p = append(*p, v)
+		return fmt.Sprintf("gostph%dx", len(*p)-1)
+	})
+}
+
+func (p placeholders) restore(s string) string {
+	for i, v := range p {
+		s = strings.Replace(s, fmt.Sprintf("gostph%dx", i), v, -1)
+	}
+	return s
+}
+
+func splitScheme(scheme string) (protocol, transport string) {
+	ss := strings.SplitN(scheme, "+", 2)
+	protocol = ss[0]
+	if protocol == "auto" {
+		protocol = ""
+	}
+	if len(ss) == 2 {
+		transport = ss[1]
+	}
+	return
+}
+
+func authConfig(user *url.Userinfo) *AuthConfig {
+	if user == nil {
+		return nil
+	}
+	password, _ := user.Password()
+	return &AuthConfig{Username: user.Username(), Password: password}
+}
+
+// optionsOf converts the URL query to the options, the multiple values of an option are joined by comma.
+func optionsOf(values url.Values) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	options := make(map[string]string)
+	for k, v := range values {
+		options[k] = strings.Join(v, ",")
+	}
+	return options
+}
+
+// schema describes the structure of a YAML node.
+type schema struct {
+	kind     yaml.Kind
+	tag      string             // the tag of the scalar, such as !!str, !!bool
+	fields   map[string]*schema // the known fields of the mapping
+	required []string           // the required fields of the mapping
+	elem     *schema            // the elements of the sequence, or the values of the mapping without known fields
+}
+
+var (
+	strSchema  = &schema{kind: yaml.ScalarNode, tag: "!!str"}
+	boolSchema = &schema{kind: yaml.ScalarNode, tag: "!!bool"}
+	// the option values can be any scalar, such as max_fails: 3.
+	optionsSchema = &schema{kind: yaml.MappingNode, elem: &schema{kind: yaml.ScalarNode}}
+	namedSchema   = &schema{kind: yaml.MappingNode, elem: strSchema}
+	authSchema    = &schema{
+		kind:     yaml.MappingNode,
+		fields:   map[string]*schema{"username": strSchema, "password": strSchema},
+		required: []string{"username"},
+	}
+
+	yamlSchema = &schema{
+		kind: yaml.MappingNode,
+		fields: map[string]*schema{
+			"services": {
+				kind: yaml.SequenceNode,
+				elem: &schema{
+					kind: yaml.MappingNode,
+					fields: map[string]*schema{
+						"name":     strSchema,
+						"addr":     strSchema,
+						"handler":  strSchema,
+						"listener": strSchema,
+						"auth":     authSchema,
+						"forward":  strSchema,
+						"chain":    strSchema,
+						"options":  optionsSchema,
+					},
+					required: []string{"addr"},
+				},
+			},
+			"chains": {
+				kind: yaml.SequenceNode,
+				elem: &schema{
+					kind: yaml.MappingNode,
+					fields: map[string]*schema{
+						"name": strSchema,
+						"hops": {
+							kind: yaml.SequenceNode,
+							elem: &schema{
+								kind: yaml.MappingNode,
+								fields: map[string]*schema{
+									"name": strSchema,
+									"nodes": {
+										kind: yaml.SequenceNode,
+										elem: &schema{
+											kind: yaml.MappingNode,
+											fields: map[string]*schema{
+												"name":      strSchema,
+												"addr":      strSchema,
+												"connector": strSchema,
+												"dialer":    strSchema,
+												"auth":      authSchema,
+												"options":   optionsSchema,
+											},
+											required: []string{"addr"},
+										},
+									},
+									"options": optionsSchema,
+								},
+								required: []string{"nodes"},
+							},
+						},
+					},
+					required: []string{"name", "hops"},
+				},
+			},
+			"bypasses":  namedSchema,
+			"resolvers": namedSchema,
+			"hosts":     namedSchema,
+			"debug":     boolSchema,
+			"limits":    strSchema,
+			"metrics":   strSchema,
+			"admin":     strSchema,
+		},
+		required: []string{"services"},
+	}
+)
+
+type configErrors []string
+
+func (e configErrors) Error() string {
+	return strings.Join(e, "\n")
+}
+
+func (e *configErrors) add(n *yaml.Node, format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf("line %d: ", n.Line)+fmt.Sprintf(format, args...))
+}
+
+var kindNames = map[yaml.Kind]string{
+	yaml.MappingNode:  "mapping",
+	yaml.SequenceNode: "sequence",
+	yaml.ScalarNode:   "scalar",
+}
+
+// validate checks the node n against the schema, path is the path of the node used in the error messages.
+func (s *schema) validate(n *yaml.Node, path string, errs *configErrors) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind != s.kind {
+		errs.add(n, "%s should be a %s", pathName(path), kindNames[s.kind])
+		return
+	}
+
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if s.tag != "" && n.Tag != s.tag {
+			if s.tag == "!!str" && n.Tag != "!!null" {
+				return // the other scalars are valid strings, such as password: 123456
+			}
+			errs.add(n, "%s should be a %s, got %s", pathName(path), strings.TrimPrefix(s.tag, "!!"), n.Value)
+		}
+
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			s.elem.validate(c, path+"["+strconv.Itoa(i)+"]", errs)
+		}
+
+	case yaml.MappingNode:
+		found := make(map[string]bool)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if found[key.Value] {
+				errs.add(key, "duplicate field %s", joinPath(path, key.Value))
+				continue
+			}
+			found[key.Value] = true
+
+			fs := s.elem
+			if s.fields != nil {
+				fs = s.fields[key.Value]
+			}
+			if fs == nil {
+				errs.add(key, "unknown field %s", joinPath(path, key.Value))
+				continue
+			}
+			fs.validate(value, joinPath(path, key.Value), errs)
+		}
+		for _, name := range s.required {
+			if !found[name] {
+				errs.add(n, "missing field %s", joinPath(path, name))
+			}
+		}
+	}
+}
+
+// checkNames checks the chain names are unique and the services refer to the known chains.
+func (e *configErrors) checkNames(root *yaml.Node) {
+	chains := make(map[string]bool)
+	for _, chain := range yamlItems(root, "chains") {
+		name := yamlField(chain, "name")
+		if name == nil {
+			continue
+		}
+		if chains[name.Value] {
+			e.add(name, "duplicate chain %s", name.Value)
+		}
+		chains[name.Value] = true
+	}
+	for _, svc := range yamlItems(root, "services") {
+		if name := yamlField(svc, "chain"); name != nil && name.Value != "" && !chains[name.Value] {
+			e.add(name, "unknown chain %s", name.Value)
+		}
+	}
+}
+
+func yamlField(n *yaml.Node, name string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == name {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func yamlItems(n *yaml.Node, name string) []*yaml.Node {
+	if f := yamlField(n, name); f != nil && f.Kind == yaml.SequenceNode {
+		return f.Content
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func pathName(path string) string {
+	if path == "" {
+		return "config"
+	}
+	return path
+}
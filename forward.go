@@ -70,6 +70,7 @@ func (h *baseForwardHandler) Init(options ...HandlerOption) {
 			Addr:   addr,
 			Host:   addr,
 			marker: &failMarker{},
+			stats:  &nodeStats{},
 		})
 
 		n++
@@ -125,19 +126,21 @@ func (h *tcpDirectForwardHandler) Handle(conn net.Conn) {
 	var node Node
 	var err error
 	for i := 0; i < retries; i++ {
-		node, err = h.group.Next()
+		node, err = h.group.NextContext(SelectContext{Client: conn.RemoteAddr().String()})
 		if err != nil {
-			log.Logf("[tcp] %s - %s : %s", conn.RemoteAddr(), h.raddr, err)
+			h.options.logger().Errorf("[tcp] %s - %s : %s", conn.RemoteAddr(), h.raddr, err)
 			return
 		}
 
-		log.Logf("[tcp] %s - %s", conn.RemoteAddr(), node.Addr)
+		h.options.logger().Infof("[tcp] %s - %s", conn.RemoteAddr(), node.Addr)
 		cc, err = h.options.Chain.Dial(node.Addr,
 			RetryChainOption(h.options.Retries),
 			TimeoutChainOption(h.options.Timeout),
+			LoggerChainOption(h.options.Logger),
+			RaceChainOption(h.options.Race, h.options.RaceDelay),
 		)
 		if err != nil {
-			log.Logf("[tcp] %s -> %s : %s", conn.RemoteAddr(), node.Addr, err)
+			h.options.logger().Errorf("[tcp] %s -> %s : %s", conn.RemoteAddr(), node.Addr, err)
 			node.MarkDead()
 		} else {
 			break
@@ -150,9 +153,10 @@ func (h *tcpDirectForwardHandler) Handle(conn net.Conn) {
 	node.ResetDead()
 	defer cc.Close()
 
-	log.Logf("[tcp] %s <-> %s", conn.RemoteAddr(), node.Addr)
-	transport(conn, cc)
-	log.Logf("[tcp] %s >-< %s", conn.RemoteAddr(), node.Addr)
+	h.options.logger().Infof("[tcp] %s <-> %s", conn.RemoteAddr(), node.Addr)
+	s := h.options.session("tcp", conn, "", node.Addr, nil)
+	s.end(transport(s.wrapConn(h.options.clientConn(conn, nil)), cc))
+	h.options.logger().Infof("[tcp] %s >-< %s", conn.RemoteAddr(), node.Addr)
 }
 
 type udpDirectForwardHandler struct {
@@ -185,24 +189,24 @@ func (h *udpDirectForwardHandler) Init(options ...HandlerOption) {
 func (h *udpDirectForwardHandler) Handle(conn net.Conn) {
 	defer conn.Close()
 
-	node, err := h.group.Next()
+	node, err := h.group.NextContext(SelectContext{Client: conn.RemoteAddr().String()})
 	if err != nil {
-		log.Logf("[udp] %s - %s : %s", conn.RemoteAddr(), h.raddr, err)
+		h.options.logger().Errorf("[udp] %s - %s : %s", conn.RemoteAddr(), h.raddr, err)
 		return
 	}
 
 	cc, err := h.options.Chain.DialContext(context.Background(), "udp", node.Addr)
 	if err != nil {
 		node.MarkDead()
-		log.Logf("[udp] %s - %s : %s", conn.RemoteAddr(), node.Addr, err)
+		h.options.logger().Errorf("[udp] %s - %s : %s", conn.RemoteAddr(), node.Addr, err)
 		return
 	}
 	defer cc.Close()
 	node.ResetDead()
 
-	log.Logf("[udp] %s <-> %s", conn.RemoteAddr(), node.Addr)
+	h.options.logger().Infof("[udp] %s <-> %s", conn.RemoteAddr(), node.Addr)
 	transport(conn, cc)
-	log.Logf("[udp] %s >-< %s", conn.RemoteAddr(), node.Addr)
+	h.options.logger().Infof("[udp] %s >-< %s", conn.RemoteAddr(), node.Addr)
 }
 
 type tcpRemoteForwardHandler struct {
@@ -244,14 +248,14 @@ func (h *tcpRemoteForwardHandler) Handle(conn net.Conn) {
 	var node Node
 	var err error
 	for i := 0; i < retries; i++ {
-		node, err = h.group.Next()
+		node, err = h.group.NextContext(SelectContext{Client: conn.RemoteAddr().String()})
 		if err != nil {
-			log.Logf("[rtcp] %s - %s : %s", conn.LocalAddr(), h.raddr, err)
+			h.options.logger().Errorf("[rtcp] %s - %s : %s", conn.LocalAddr(), h.raddr, err)
 			return
 		}
 		cc, err = net.DialTimeout("tcp", node.Addr, h.options.Timeout)
 		if err != nil {
-			log.Logf("[rtcp] %s -> %s : %s", conn.LocalAddr(), node.Addr, err)
+			h.options.logger().Errorf("[rtcp] %s -> %s : %s", conn.LocalAddr(), node.Addr, err)
 			node.MarkDead()
 		} else {
 			break
@@ -264,9 +268,9 @@ func (h *tcpRemoteForwardHandler) Handle(conn net.Conn) {
 	defer cc.Close()
 	node.ResetDead()
 
-	log.Logf("[rtcp] %s <-> %s", conn.LocalAddr(), node.Addr)
+	h.options.logger().Infof("[rtcp] %s <-> %s", conn.LocalAddr(), node.Addr)
 	transport(cc, conn)
-	log.Logf("[rtcp] %s >-< %s", conn.LocalAddr(), node.Addr)
+	h.options.logger().Infof("[rtcp] %s >-< %s", conn.LocalAddr(), node.Addr)
 }
 
 type udpRemoteForwardHandler struct {
@@ -299,30 +303,30 @@ func (h *udpRemoteForwardHandler) Init(options ...HandlerOption) {
 func (h *udpRemoteForwardHandler) Handle(conn net.Conn) {
 	defer conn.Close()
 
-	node, err := h.group.Next()
+	node, err := h.group.NextContext(SelectContext{Client: conn.RemoteAddr().String()})
 	if err != nil {
-		log.Logf("[rudp] %s - %s : %s", conn.RemoteAddr(), h.raddr, err)
+		h.options.logger().Errorf("[rudp] %s - %s : %s", conn.RemoteAddr(), h.raddr, err)
 		return
 	}
 
 	raddr, err := net.ResolveUDPAddr("udp", node.Addr)
 	if err != nil {
 		node.MarkDead()
-		log.Logf("[rudp] %s - %s : %s", conn.RemoteAddr(), node.Addr, err)
+		h.options.logger().Errorf("[rudp] %s - %s : %s", conn.RemoteAddr(), node.Addr, err)
 		return
 	}
 	cc, err := net.DialUDP("udp", nil, raddr)
 	if err != nil {
 		node.MarkDead()
-		log.Logf("[rudp] %s - %s : %s", conn.RemoteAddr(), node.Addr, err)
+		h.options.logger().Errorf("[rudp] %s - %s : %s", conn.RemoteAddr(), node.Addr, err)
 		return
 	}
 	defer cc.Close()
 	node.ResetDead()
 
-	log.Logf("[rudp] %s <-> %s", conn.RemoteAddr(), node.Addr)
+	h.options.logger().Infof("[rudp] %s <-> %s", conn.RemoteAddr(), node.Addr)
 	transport(conn, cc)
-	log.Logf("[rudp] %s >-< %s", conn.RemoteAddr(), node.Addr)
+	h.options.logger().Infof("[rudp] %s >-< %s", conn.RemoteAddr(), node.Addr)
 }
 
 type tcpRemoteForwardListener struct {
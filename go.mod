@@ -13,5 +13,8 @@ require (
 	github.com/ryanuber/go-glob v1.0.0
 	github.com/shadowsocks/shadowsocks-go v0.0.0-20190614083952-6a03846ca9c0
 	github.com/xtaci/smux v1.5.11
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 	golang.org/x/net v0.7.0
+	golang.org/x/sys v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
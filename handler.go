@@ -33,6 +33,8 @@ type HandlerOptions struct {
 	Bypass        *Bypass
 	Retries       int
 	Timeout       time.Duration
+	Race          int
+	RaceDelay     time.Duration
 	Resolver      Resolver
 	Hosts         *Hosts
 	ProbeResist   string
@@ -41,6 +43,11 @@ type HandlerOptions struct {
 	Host          string
 	IPs           []string
 	TCPMode       bool
+	Limiter       *Limiter
+	Traffic       *Traffic
+	AccessLog     *AccessLogger
+	Logger        LeveledLogger
+	Now           func() time.Time // the clock of the permission schedules, time.Now if nil
 }
 
 // HandlerOption allows a common way to set handler options.
@@ -147,6 +154,14 @@ func TimeoutHandlerOption(timeout time.Duration) HandlerOption {
 	}
 }
 
+// RaceHandlerOption sets the race options of HandlerOptions.
+func RaceHandlerOption(n int, delay time.Duration) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.Race = n
+		opts.RaceDelay = delay
+	}
+}
+
 // ResolverHandlerOption sets the resolver option of HandlerOptions.
 func ResolverHandlerOption(resolver Resolver) HandlerOption {
 	return func(opts *HandlerOptions) {
@@ -203,6 +218,130 @@ func TCPModeHandlerOption(b bool) HandlerOption {
 	}
 }
 
+// LimiterHandlerOption sets the bandwidth limiter for the client connections.
+func LimiterHandlerOption(limiter *Limiter) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.Limiter = limiter
+	}
+}
+
+// TrafficHandlerOption sets the traffic accounting for the client connections.
+func TrafficHandlerOption(traffic *Traffic) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.Traffic = traffic
+	}
+}
+
+// AccessLogHandlerOption sets the access logger for the proxy sessions.
+func AccessLogHandlerOption(logger *AccessLogger) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.AccessLog = logger
+	}
+}
+
+// LoggerHandlerOption sets the leveled logger of the handler.
+func LoggerHandlerOption(logger LeveledLogger) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.Logger = logger
+	}
+}
+
+// NowHandlerOption sets the clock of the permission schedules.
+func NowHandlerOption(now func() time.Time) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.Now = now
+	}
+}
+
+// logger returns the leveled logger of the handler, or the default one if it is not set.
+func (opts *HandlerOptions) logger() LeveledLogger {
+	if opts == nil || opts.Logger == nil {
+		return DefaultLeveledLogger
+	}
+	return opts.Logger
+}
+
+// authenticate authenticates the user of the client connection conn by the Authenticator.
+func (opts *HandlerOptions) authenticate(protocol string, conn net.Conn, user, password, target string) (*Principal, bool) {
+	return authenticate(opts.Authenticator, &AuthRequest{
+		User:     user,
+		Password: password,
+		Client:   conn.RemoteAddr().String(),
+		Node:     opts.Node.String(),
+		Protocol: protocol,
+		Target:   target,
+	})
+}
+
+// can tests whether the principal p can do the action on addr,
+// by both the permissions of the node and the permissions of the user.
+// The hostname of addr is resolved once for the IP rules of the permissions if the Resolver is set,
+// the address to connect to directly is returned with the hostname replaced by the checked IP,
+// so the hostname can not be rebound to another IP between the check and the dial.
+// It is used by dialAddr only if the route is empty, the chain is given the hostname.
+func (opts *HandlerOptions) can(action string, addr string, p *Principal) (string, bool) {
+	var ips []net.IP
+	var lookup func(host string) ([]net.IP, error)
+	if opts.Resolver != nil {
+		var err error
+		var done bool
+		lookup = func(host string) ([]net.IP, error) {
+			if !done {
+				done = true
+				if ip := opts.Hosts.Lookup(host); ip != nil {
+					ips = []net.IP{ip}
+				} else {
+					ips, err = opts.Resolver.Resolve(host)
+				}
+			}
+			return ips, err
+		}
+	}
+
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+	if !canResolveAt(action, addr, opts.Whitelist, opts.Blacklist, lookup, now) ||
+		(p != nil && !canResolveAt(action, addr, p.Whitelist, p.Blacklist, lookup, now)) {
+		return "", false
+	}
+	if len(ips) > 0 {
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			return net.JoinHostPort(ips[0].String(), port), true
+		}
+	}
+	return addr, true
+}
+
+// dialAddr returns the address to dial through the route,
+// the checked address returned by can for the direct dial, the host itself for the chain.
+func dialAddr(route *Chain, host, checked string) string {
+	if route.IsEmpty() {
+		return checked
+	}
+	return host
+}
+
+// clientConn wraps the client connection conn of the principal p
+// with the bandwidth limiters, the metrics and the traffic accounting.
+func (opts *HandlerOptions) clientConn(conn net.Conn, p *Principal) net.Conn {
+	user := p.Name()
+	conn = limitConn(conn, opts.Limiter, user)
+	if p != nil && (p.ReadLimit > 0 || p.WriteLimit > 0) {
+		lc := &limitedConn{Conn: conn}
+		if p.ReadLimit > 0 {
+			lc.rlimiters = []*RateLimiter{NewRateLimiter(p.ReadLimit)}
+		}
+		if p.WriteLimit > 0 {
+			lc.wlimiters = []*RateLimiter{NewRateLimiter(p.WriteLimit)}
+		}
+		conn = lc
+	}
+	conn = DefaultMetrics.wrapConn(conn, opts.Node)
+	return opts.Traffic.wrapConn(conn, user)
+}
+
 type autoHandler struct {
 	options *HandlerOptions
 }
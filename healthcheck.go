@@ -0,0 +1,148 @@
+package gost
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HealthChecker actively checks the health of the nodes in a node group.
+//
+// Each node is dialed directly through its client (Dial and Handshake),
+// and if Target is set, the target address is connected through the node.
+// A failed check marks the node dead and a succeeded one resets the fail status,
+// so the FailFilter of the group can skip the dead nodes before the real traffic hits them.
+// The handshake latency of a succeeded check is also fed to the latency strategy.
+type HealthChecker struct {
+	Group    *NodeGroup
+	Interval time.Duration
+	Target   string        // optional probe target address, such as example.com:80
+	Timeout  time.Duration // timeout of a single check, default is DialTimeout
+	Logger   LeveledLogger
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+// NewHealthChecker creates a HealthChecker that checks the nodes of group every interval.
+func NewHealthChecker(group *NodeGroup, interval time.Duration, target string) *HealthChecker {
+	return &HealthChecker{
+		Group:    group,
+		Interval: interval,
+		Target:   target,
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Run checks the nodes periodically until the checker is stopped.
+func (hc *HealthChecker) Run() {
+	if hc == nil || hc.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+
+	for {
+		hc.Check()
+
+		select {
+		case <-ticker.C:
+		case <-hc.stopped:
+			return
+		}
+	}
+}
+
+// Check checks all the nodes of the group concurrently and waits for the results.
+func (hc *HealthChecker) Check() {
+	if hc == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, node := range hc.Group.Nodes() {
+		wg.Add(1)
+		go func(node Node) {
+			defer wg.Done()
+
+			if err := hc.check(&node); err != nil {
+				node.MarkDead()
+				hc.logger().Warnf("[health] %d@%s: %v", node.ID, node.String(), err)
+				return
+			}
+			node.ResetDead()
+			hc.logger().Debugf("[health] %d@%s: OK", node.ID, node.String())
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (hc *HealthChecker) check(node *Node) error {
+	if node.Client == nil {
+		return ErrInvalidNode
+	}
+
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = DialTimeout
+	}
+
+	start := time.Now()
+	conn, err := node.Client.Dial(node.Addr, node.DialOptions...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	cc, err := node.Client.Handshake(conn, node.HandshakeOptions...)
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	node.stats.observeLatency(time.Since(start))
+
+	if hc.Target == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cc, err = node.Client.ConnectContext(ctx, cc, "tcp", hc.Target, node.ConnectOptions...)
+	if err != nil {
+		return err
+	}
+	return cc.Close()
+}
+
+func (hc *HealthChecker) logger() LeveledLogger {
+	if hc.Logger == nil {
+		return DefaultLeveledLogger
+	}
+	return hc.Logger
+}
+
+// Stop stops the checker.
+func (hc *HealthChecker) Stop() {
+	if hc == nil {
+		return
+	}
+	hc.once.Do(func() {
+		close(hc.stopped)
+	})
+}
+
+// Stopped checks whether the checker is stopped.
+func (hc *HealthChecker) Stopped() bool {
+	if hc == nil {
+		return true
+	}
+	select {
+	case <-hc.stopped:
+		return true
+	default:
+		return false
+	}
+}
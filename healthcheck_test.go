@@ -0,0 +1,91 @@
+package gost
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{
+		Listener: ln,
+		Handler:  SOCKS5Handler(),
+	}
+	go server.Run()
+	defer server.Close()
+
+	// an address that nobody listens on
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+
+	client := &Client{
+		Connector:   SOCKS5Connector(nil),
+		Transporter: TCPTransporter(),
+	}
+	newNode := func(id int, addr string) Node {
+		return Node{ID: id, Addr: addr, Client: client, marker: &failMarker{}, stats: &nodeStats{}}
+	}
+
+	tests := []struct {
+		target string
+		nodes  []Node
+		fails  []uint32
+	}{
+		{"", []Node{newNode(1, ln.Addr().String()), newNode(2, dead.Addr().String())}, []uint32{0, 1}},
+		{httpSrv.Listener.Addr().String(), []Node{newNode(1, ln.Addr().String())}, []uint32{0}},
+		{dead.Addr().String(), []Node{newNode(1, ln.Addr().String())}, []uint32{2}},
+	}
+
+	for i, tc := range tests {
+		group := NewNodeGroup(tc.nodes...)
+		tc.nodes[0].MarkDead()
+
+		hc := NewHealthChecker(group, time.Second, tc.target)
+		hc.Check()
+
+		for j, node := range group.Nodes() {
+			if node.FailCount() != tc.fails[j] {
+				t.Errorf("#%d test failed: node %d fail count %d, want %d", i, node.ID, node.FailCount(), tc.fails[j])
+			}
+			if node.Addr == ln.Addr().String() && node.Latency() == 0 {
+				t.Errorf("#%d test failed: node %d latency is not measured", i, node.ID)
+			}
+		}
+	}
+}
+
+func TestHealthCheckerStop(t *testing.T) {
+	client := &Client{
+		Connector:   SOCKS5Connector(nil),
+		Transporter: TCPTransporter(),
+	}
+	hc := NewHealthChecker(NewNodeGroup(Node{Addr: "127.0.0.1:1", Client: client}), 10*time.Millisecond, "")
+
+	done := make(chan struct{})
+	go func() {
+		hc.Run()
+		close(done)
+	}()
+
+	hc.Stop()
+	hc.Stop()
+	if !hc.Stopped() {
+		t.Error("checker should be stopped")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("checker should be exited")
+	}
+}
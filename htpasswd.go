@@ -0,0 +1,213 @@
+package gost
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-log/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HTPasswdAuthenticator is an Authenticator that authenticates client by the htpasswd file of Apache,
+// the bcrypt ($2y$), SHA-1 ({SHA}) and APR1 ($apr1$) hashes are supported.
+// The file has no reload option, it is reloaded on change if the file can be watched.
+type HTPasswdAuthenticator struct {
+	users   map[string]string
+	stopped chan struct{}
+	mux     sync.RWMutex
+}
+
+// NewHTPasswdAuthenticator creates an Authenticator that authenticates client by the htpasswd entries.
+func NewHTPasswdAuthenticator() *HTPasswdAuthenticator {
+	return &HTPasswdAuthenticator{
+		stopped: make(chan struct{}),
+	}
+}
+
+// Authenticate checks the password of the user against the hash.
+func (au *HTPasswdAuthenticator) Authenticate(user, password string) bool {
+	if au == nil {
+		return true
+	}
+
+	au.mux.RLock()
+	hash, ok := au.users[user]
+	au.mux.RUnlock()
+
+	return ok && verifyHTPasswd(hash, password)
+}
+
+// Reload parses the htpasswd entries in the form of user:hash from r, then live reloads the Authenticator.
+// The entries with the unsupported hashes, such as crypt(3), are ignored.
+func (au *HTPasswdAuthenticator) Reload(r io.Reader) error {
+	if r == nil || au.Stopped() {
+		return nil
+	}
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		n := strings.IndexByte(line, ':')
+		if n <= 0 {
+			continue
+		}
+		user, hash := line[:n], line[n+1:]
+		if !supportedHTPasswd(hash) {
+			log.Logf("[htpasswd] %s: unsupported hash", user)
+			continue
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	au.mux.Lock()
+	defer au.mux.Unlock()
+
+	au.users = users
+
+	return nil
+}
+
+// Period returns the reload period.
+func (au *HTPasswdAuthenticator) Period() time.Duration {
+	if au.Stopped() {
+		return -1
+	}
+	return 0
+}
+
+// Stop stops reloading.
+func (au *HTPasswdAuthenticator) Stop() {
+	select {
+	case <-au.stopped:
+	default:
+		close(au.stopped)
+	}
+}
+
+// Stopped checks whether the reloader is stopped.
+func (au *HTPasswdAuthenticator) Stopped() bool {
+	select {
+	case <-au.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func supportedHTPasswd(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+	case strings.HasPrefix(hash, "{SHA}"):
+	case strings.HasPrefix(hash, "$apr1$"):
+	default:
+		return false
+	}
+	return true
+}
+
+func verifyHTPasswd(hash, password string) bool {
+	var s string
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		s = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.TrimPrefix(hash, "$apr1$")
+		if n := strings.IndexByte(salt, '$'); n >= 0 {
+			salt = salt[:n]
+		}
+		s = apr1(password, salt)
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(s), []byte(hash)) == 1
+}
+
+// apr1 computes the APR1 hash of the password, the MD5-based crypt of Apache.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw, sl := []byte(password), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(sl)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(magic))
+	h.Write(sl)
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(altSum)
+		} else {
+			h.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write(sl)
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(pw)
+		}
+		sum = h.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(magic + salt + "$")
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[g[0]])<<16|uint(sum[g[1]])<<8|uint(sum[g[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package gost
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAPR1(t *testing.T) {
+	tests := []struct {
+		password, salt, hash string
+	}{
+		{"myPassword", "r31.....", "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/"},
+		{"p@ss w0rd with a long tail!", "abcdefgh", "$apr1$abcdefgh$/3esSNN42BrYvGvJR5Dp40"},
+		{"", "x", "$apr1$x$tMwYqBfQwi3FYAr0aJc8M/"},
+	}
+	for i, tc := range tests {
+		if h := apr1(tc.password, tc.salt); h != tc.hash {
+			t.Errorf("#%d test failed: got %s, want %s", i, h, tc.hash)
+		}
+	}
+}
+
+func TestHTPasswdAuthenticator(t *testing.T) {
+	bc, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("# comment\n\n")
+	b.WriteString("bcrypt:" + string(bc) + "\n")
+	b.WriteString("sha:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n") // password
+	b.WriteString("apr1:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\n")
+	b.WriteString("crypt:rqXexS6ZhobKA\n") // unsupported
+
+	au := NewHTPasswdAuthenticator()
+	if err := au.Reload(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user, password string
+		ok             bool
+	}{
+		{"bcrypt", "bcrypt-pass", true},
+		{"bcrypt", "password", false},
+		{"sha", "password", true},
+		{"sha", "Password", false},
+		{"apr1", "myPassword", true},
+		{"apr1", "mypassword", false},
+		{"crypt", "password", false},
+		{"unknown", "password", false},
+		{"", "", false},
+	}
+	for i, tc := range tests {
+		if ok := au.Authenticate(tc.user, tc.password); ok != tc.ok {
+			t.Errorf("#%d test failed: %s authenticated %v, want %v", i, tc.user, ok, tc.ok)
+		}
+	}
+
+	if au.Period() != 0 {
+		t.Errorf("got period %v, want 0", au.Period())
+	}
+	au.Stop()
+	if !au.Stopped() || au.Period() >= 0 {
+		t.Error("the authenticator is not stopped")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -14,8 +15,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/go-log/log"
 )
 
 type httpConnector struct {
@@ -82,9 +81,9 @@ func (c *httpConnector) ConnectContext(ctx context.Context, conn net.Conn, netwo
 		return nil, err
 	}
 
-	if Debug {
+	if DefaultLeveledLogger.Enabled(DebugLevel) {
 		dump, _ := httputil.DumpRequest(req, false)
-		log.Log(string(dump))
+		DefaultLeveledLogger.Debugf("[http] %s -> %s\n%s", conn.LocalAddr(), conn.RemoteAddr(), string(dump))
 	}
 
 	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
@@ -92,9 +91,9 @@ func (c *httpConnector) ConnectContext(ctx context.Context, conn net.Conn, netwo
 		return nil, err
 	}
 
-	if Debug {
+	if DefaultLeveledLogger.Enabled(DebugLevel) {
 		dump, _ := httputil.DumpResponse(resp, false)
-		log.Log(string(dump))
+		DefaultLeveledLogger.Debugf("[http] %s <- %s\n%s", conn.LocalAddr(), conn.RemoteAddr(), string(dump))
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -129,7 +128,7 @@ func (h *httpHandler) Handle(conn net.Conn) {
 
 	req, err := http.ReadRequest(bufio.NewReader(conn))
 	if err != nil {
-		log.Logf("[http] %s - %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+		h.options.logger().Errorf("[http] %s - %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 		return
 	}
 	defer req.Body.Close()
@@ -154,16 +153,17 @@ func (h *httpHandler) handleRequest(conn net.Conn, req *http.Request) {
 		host = net.JoinHostPort(host, "80")
 	}
 
-	u, _, _ := basicProxyAuth(req.Header.Get("Proxy-Authorization"))
+	user, _, _ := basicProxyAuth(req.Header.Get("Proxy-Authorization"))
+	u := user
 	if u != "" {
 		u += "@"
 	}
-	log.Logf("[http] %s%s -> %s -> %s",
+	h.options.logger().Infof("[http] %s%s -> %s -> %s",
 		u, conn.RemoteAddr(), h.options.Node.String(), host)
 
-	if Debug {
+	if h.options.logger().Enabled(DebugLevel) {
 		dump, _ := httputil.DumpRequest(req, false)
-		log.Logf("[http] %s -> %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
+		h.options.logger().Debugf("[http] %s -> %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
 	}
 
 	req.Header.Del("Gost-Target")
@@ -175,44 +175,62 @@ func (h *httpHandler) handleRequest(conn net.Conn, req *http.Request) {
 	}
 	resp.Header.Add("Proxy-Agent", "gost/"+Version)
 
-	if !Can("tcp", host, h.options.Whitelist, h.options.Blacklist) {
-		log.Logf("[http] %s - %s : Unauthorized to tcp connect to %s",
-			conn.RemoteAddr(), conn.LocalAddr(), host)
+	if h.options.Bypass.Contains(host) {
 		resp.StatusCode = http.StatusForbidden
 
-		if Debug {
+		h.options.logger().Warnf("[http] %s - %s bypass %s",
+			conn.RemoteAddr(), conn.LocalAddr(), host)
+		if h.options.logger().Enabled(DebugLevel) {
 			dump, _ := httputil.DumpResponse(resp, false)
-			log.Logf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
+			h.options.logger().Debugf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
 		}
 
 		resp.Write(conn)
 		return
 	}
 
-	if h.options.Bypass.Contains(host) {
+	principal, ok := h.authenticate(conn, req, resp, host)
+	if !ok {
+		return
+	}
+	user = principal.Name()
+	// the permissions are checked after the authentication,
+	// so the hostname is not resolved for the unauthenticated clients.
+	checked, ok := h.options.can("tcp", host, principal)
+	if !ok {
+		h.options.logger().Warnf("[http] %s - %s : %s: Unauthorized to tcp connect to %s",
+			conn.RemoteAddr(), conn.LocalAddr(), user, host)
 		resp.StatusCode = http.StatusForbidden
 
-		log.Logf("[http] %s - %s bypass %s",
-			conn.RemoteAddr(), conn.LocalAddr(), host)
-		if Debug {
+		if h.options.logger().Enabled(DebugLevel) {
 			dump, _ := httputil.DumpResponse(resp, false)
-			log.Logf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
+			h.options.logger().Debugf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
 		}
 
 		resp.Write(conn)
 		return
 	}
+	if h.options.Traffic.Exceeded(user) {
+		h.options.logger().Warnf("[http] %s - %s : %s: %s",
+			conn.RemoteAddr(), conn.LocalAddr(), user, ErrQuotaExceeded)
+		resp.StatusCode = http.StatusForbidden
 
-	if !h.authenticate(conn, req, resp) {
+		if h.options.logger().Enabled(DebugLevel) {
+			dump, _ := httputil.DumpResponse(resp, false)
+			h.options.logger().Debugf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
+		}
+
+		resp.Write(conn)
 		return
 	}
+	conn = h.options.clientConn(conn, principal)
 
 	if req.Method == "PRI" || (req.Method != http.MethodConnect && req.URL.Scheme != "http") {
 		resp.StatusCode = http.StatusBadRequest
 
-		if Debug {
+		if h.options.logger().Enabled(DebugLevel) {
 			dump, _ := httputil.DumpResponse(resp, false)
-			log.Logf("[http] %s <- %s\n%s",
+			h.options.logger().Debugf("[http] %s <- %s\n%s",
 				conn.RemoteAddr(), conn.LocalAddr(), string(dump))
 		}
 
@@ -234,9 +252,9 @@ func (h *httpHandler) handleRequest(conn net.Conn, req *http.Request) {
 	var cc net.Conn
 	var route *Chain
 	for i := 0; i < retries; i++ {
-		route, err = h.options.Chain.selectRouteFor(host)
+		route, err = h.options.Chain.selectRouteContext(principal.selectContext(conn.RemoteAddr().String(), host))
 		if err != nil {
-			log.Logf("[http] %s -> %s : %s",
+			h.options.logger().Errorf("[http] %s -> %s : %s",
 				conn.RemoteAddr(), conn.LocalAddr(), err)
 			continue
 		}
@@ -248,36 +266,38 @@ func (h *httpHandler) handleRequest(conn net.Conn, req *http.Request) {
 			fmt.Fprintf(&buf, "%d@%s -> ", nd.ID, nd.String())
 		}
 		fmt.Fprintf(&buf, "%s", host)
-		log.Log("[route]", buf.String())
+		h.options.logger().Infof("[route] %s", buf.String())
 
 		// forward http request
 		lastNode := route.LastNode()
 		if req.Method != http.MethodConnect && lastNode.Protocol == "http" {
-			err = h.forwardRequest(conn, req, route)
+			err = h.forwardRequest(conn, req, user, route)
 			if err == nil {
 				return
 			}
-			log.Logf("[http] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+			h.options.logger().Errorf("[http] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 			continue
 		}
 
-		cc, err = route.Dial(host,
+		cc, err = route.Dial(dialAddr(route, host, checked),
 			TimeoutChainOption(h.options.Timeout),
 			HostsChainOption(h.options.Hosts),
 			ResolverChainOption(h.options.Resolver),
+			LoggerChainOption(h.options.Logger),
+			RaceChainOption(h.options.Race, h.options.RaceDelay),
 		)
 		if err == nil {
 			break
 		}
-		log.Logf("[http] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+		h.options.logger().Errorf("[http] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 	}
 
 	if err != nil {
 		resp.StatusCode = http.StatusServiceUnavailable
 
-		if Debug {
+		if h.options.logger().Enabled(DebugLevel) {
 			dump, _ := httputil.DumpResponse(resp, false)
-			log.Logf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
+			h.options.logger().Debugf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(dump))
 		}
 
 		resp.Write(conn)
@@ -288,33 +308,33 @@ func (h *httpHandler) handleRequest(conn net.Conn, req *http.Request) {
 	if req.Method == http.MethodConnect {
 		b := []byte("HTTP/1.1 200 Connection established\r\n" +
 			"Proxy-Agent: gost/" + Version + "\r\n\r\n")
-		if Debug {
-			log.Logf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(b))
-		}
+		h.options.logger().Debugf("[http] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), string(b))
 		conn.Write(b)
 	} else {
 		req.Header.Del("Proxy-Connection")
 
 		if err = req.Write(cc); err != nil {
-			log.Logf("[http] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+			h.options.logger().Errorf("[http] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 			return
 		}
 	}
 
-	log.Logf("[http] %s <-> %s", conn.RemoteAddr(), host)
-	transport(conn, cc)
-	log.Logf("[http] %s >-< %s", conn.RemoteAddr(), host)
+	h.options.logger().Infof("[http] %s <-> %s", conn.RemoteAddr(), host)
+	s := h.options.session("http", conn, user, host, route)
+	s.end(transport(s.wrapConn(conn), cc))
+	h.options.logger().Infof("[http] %s >-< %s", conn.RemoteAddr(), host)
 }
 
-func (h *httpHandler) authenticate(conn net.Conn, req *http.Request, resp *http.Response) (ok bool) {
+func (h *httpHandler) authenticate(conn net.Conn, req *http.Request, resp *http.Response, host string) (principal *Principal, ok bool) {
 	u, p, _ := basicProxyAuth(req.Header.Get("Proxy-Authorization"))
-	if Debug && (u != "" || p != "") {
-		log.Logf("[http] %s -> %s : Authorization '%s' '%s'",
-			conn.RemoteAddr(), conn.LocalAddr(), u, p)
+	if u != "" || p != "" {
+		h.options.logger().Debugf("[http] %s -> %s : Authorization '%s' '%s'",
+			conn.RemoteAddr(), conn.LocalAddr(), u, redacted)
 	}
-	if h.options.Authenticator == nil || h.options.Authenticator.Authenticate(u, p) {
-		return true
+	if principal, ok = h.options.authenticate("http", conn, u, p, host); ok {
+		return
 	}
+	DefaultMetrics.authFailed("http")
 
 	// probing resistance is enabled, and knocking host is mismatch.
 	if ss := strings.SplitN(h.options.ProbeResist, ":", 2); len(ss) == 2 &&
@@ -338,10 +358,10 @@ func (h *httpHandler) authenticate(conn net.Conn, req *http.Request, resp *http.
 				defer cc.Close()
 
 				req.Write(cc)
-				log.Logf("[http] %s <-> %s : forward to %s",
+				h.options.logger().Infof("[http] %s <-> %s : forward to %s",
 					conn.RemoteAddr(), conn.LocalAddr(), ss[1])
 				transport(conn, cc)
-				log.Logf("[http] %s >-< %s : forward to %s",
+				h.options.logger().Infof("[http] %s >-< %s : forward to %s",
 					conn.RemoteAddr(), conn.LocalAddr(), ss[1])
 				return
 			}
@@ -359,7 +379,7 @@ func (h *httpHandler) authenticate(conn net.Conn, req *http.Request, resp *http.
 	}
 
 	if resp.StatusCode == 0 {
-		log.Logf("[http] %s <- %s : proxy authentication required",
+		h.options.logger().Warnf("[http] %s <- %s : proxy authentication required",
 			conn.RemoteAddr(), conn.LocalAddr())
 		resp.StatusCode = http.StatusProxyAuthRequired
 		resp.Header.Add("Proxy-Authenticate", "Basic realm=\"gost\"")
@@ -372,9 +392,9 @@ func (h *httpHandler) authenticate(conn net.Conn, req *http.Request, resp *http.
 		}
 	}
 
-	if Debug {
+	if h.options.logger().Enabled(DebugLevel) {
 		dump, _ := httputil.DumpResponse(resp, false)
-		log.Logf("[http] %s <- %s\n%s",
+		h.options.logger().Debugf("[http] %s <- %s\n%s",
 			conn.RemoteAddr(), conn.LocalAddr(), string(dump))
 	}
 
@@ -382,7 +402,7 @@ func (h *httpHandler) authenticate(conn net.Conn, req *http.Request, resp *http.
 	return
 }
 
-func (h *httpHandler) forwardRequest(conn net.Conn, req *http.Request, route *Chain) error {
+func (h *httpHandler) forwardRequest(conn net.Conn, req *http.Request, user string, route *Chain) error {
 	if route.IsEmpty() {
 		return nil
 	}
@@ -402,9 +422,12 @@ func (h *httpHandler) forwardRequest(conn net.Conn, req *http.Request, route *Ch
 	}
 	defer cc.Close()
 
+	s := h.options.session("http", conn, user, host, route)
+	sc := s.wrapConn(conn)
+
 	errc := make(chan error, 1)
 	go func() {
-		errc <- copyBuffer(conn, cc)
+		errc <- copyBuffer(sc, cc)
 	}()
 
 	go func() {
@@ -419,29 +442,33 @@ func (h *httpHandler) forwardRequest(conn net.Conn, req *http.Request, route *Ch
 			}
 			err := req.WriteProxy(cc)
 			if err != nil {
-				log.Logf("[http] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+				h.options.logger().Errorf("[http] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 				errc <- err
 				return
 			}
 			cc.SetWriteDeadline(time.Time{})
 
-			req, err = http.ReadRequest(bufio.NewReader(conn))
+			req, err = http.ReadRequest(bufio.NewReader(sc))
 			if err != nil {
 				errc <- err
 				return
 			}
 
-			if Debug {
+			if h.options.logger().Enabled(DebugLevel) {
 				dump, _ := httputil.DumpRequest(req, false)
-				log.Logf("[http] %s -> %s\n%s",
+				h.options.logger().Debugf("[http] %s -> %s\n%s",
 					conn.RemoteAddr(), conn.LocalAddr(), string(dump))
 			}
 		}
 	}()
 
-	log.Logf("[http] %s <-> %s", conn.RemoteAddr(), host)
-	<-errc
-	log.Logf("[http] %s >-< %s", conn.RemoteAddr(), host)
+	h.options.logger().Infof("[http] %s <-> %s", conn.RemoteAddr(), host)
+	err = <-errc
+	if err == io.EOF {
+		err = nil
+	}
+	s.end(err)
+	h.options.logger().Infof("[http] %s >-< %s", conn.RemoteAddr(), host)
 
 	return nil
 }
@@ -0,0 +1,180 @@
+package gost
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-log/log"
+)
+
+var (
+	// DefaultHTTPAuthTTL is the default TTL of the cached results of HTTPAuthenticator.
+	DefaultHTTPAuthTTL = time.Minute
+	// DefaultHTTPAuthTimeout is the default timeout of the requests of HTTPAuthenticator.
+	DefaultHTTPAuthTimeout = 5 * time.Second
+
+	// the cache is purged when it grows to this size.
+	httpAuthCacheSize = 10000
+)
+
+// HTTPAuthenticator is an AuthenticatorV2 that authenticates client by POSTing AuthRequest in JSON to the URL,
+// the 2xx status means the client is authenticated, and the optional Principal in JSON can be responded,
+// 401 and 403 mean it is rejected.
+// Both the positive and negative results are cached for TTL by the request, with the client port ignored,
+// the other statuses and the errors are taken as rejected and not cached.
+type HTTPAuthenticator struct {
+	URL     string
+	TTL     time.Duration // the TTL of the cached results, a negative TTL disables the cache
+	Timeout time.Duration
+	Client  *http.Client // nil for the default client with Timeout
+
+	cache map[[sha256.Size]byte]httpAuthResult
+	once  sync.Once
+	mux   sync.Mutex
+}
+
+type httpAuthResult struct {
+	principal *Principal
+	ok        bool
+	expires   time.Time
+}
+
+// NewHTTPAuthenticator creates an Authenticator that authenticates client by the HTTP endpoint url.
+func NewHTTPAuthenticator(url string) *HTTPAuthenticator {
+	return &HTTPAuthenticator{
+		URL: url,
+	}
+}
+
+// Authenticate checks the user-password pair by the endpoint without the context.
+func (au *HTTPAuthenticator) Authenticate(user, password string) bool {
+	if au == nil {
+		return true
+	}
+	_, ok := au.AuthenticateV2(&AuthRequest{User: user, Password: password})
+	return ok
+}
+
+// AuthenticateV2 checks the request by the endpoint.
+func (au *HTTPAuthenticator) AuthenticateV2(req *AuthRequest) (*Principal, bool) {
+	if au == nil {
+		return nil, true
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, false
+	}
+
+	// the key is hashed, so the passwords are not kept in memory.
+	kreq := *req
+	if host, _, err := net.SplitHostPort(kreq.Client); err == nil {
+		kreq.Client = host
+	}
+	b, _ := json.Marshal(&kreq)
+	key := sha256.Sum256(b)
+
+	if r, cached := au.lookup(key); cached {
+		return r.principal, r.ok
+	}
+
+	p, ok, err := au.post(body)
+	if err != nil {
+		log.Logf("[auth] %s: %s: %v", Redact(au.URL), req.User, err)
+		return nil, false
+	}
+	au.store(key, httpAuthResult{principal: p, ok: ok})
+	return p, ok
+}
+
+func (au *HTTPAuthenticator) post(body []byte) (*Principal, bool, error) {
+	au.once.Do(func() {
+		if au.Client == nil {
+			timeout := au.Timeout
+			if timeout <= 0 {
+				timeout = DefaultHTTPAuthTimeout
+			}
+			au.Client = &http.Client{Timeout: timeout}
+		}
+	})
+
+	resp, err := au.Client.Post(au.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, false, redactError(err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, true, nil
+	}
+	p := &Principal{}
+	if err := json.NewDecoder(resp.Body).Decode(p); err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	return p, true, nil
+}
+
+func (au *HTTPAuthenticator) ttl() time.Duration {
+	if au.TTL == 0 {
+		return DefaultHTTPAuthTTL
+	}
+	return au.TTL
+}
+
+func (au *HTTPAuthenticator) lookup(key [sha256.Size]byte) (r httpAuthResult, cached bool) {
+	au.mux.Lock()
+	defer au.mux.Unlock()
+
+	r, cached = au.cache[key]
+	if !cached {
+		return
+	}
+	if time.Now().After(r.expires) {
+		delete(au.cache, key)
+		return httpAuthResult{}, false
+	}
+	return r, true
+}
+
+func (au *HTTPAuthenticator) store(key [sha256.Size]byte, r httpAuthResult) {
+	ttl := au.ttl()
+	if ttl < 0 {
+		return
+	}
+
+	au.mux.Lock()
+	defer au.mux.Unlock()
+
+	if au.cache == nil {
+		au.cache = make(map[[sha256.Size]byte]httpAuthResult)
+	}
+	now := time.Now()
+	if len(au.cache) >= httpAuthCacheSize {
+		for k, r := range au.cache {
+			if now.After(r.expires) {
+				delete(au.cache, k)
+			}
+		}
+		if len(au.cache) >= httpAuthCacheSize {
+			au.cache = make(map[[sha256.Size]byte]httpAuthResult)
+		}
+	}
+	r.expires = now.Add(ttl)
+	au.cache[key] = r
+}
@@ -0,0 +1,162 @@
+package gost
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPAuthenticator(t *testing.T) {
+	var requests int32
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var req AuthRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.User == "admin" && req.Password == "123456" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	au := NewHTTPAuthenticator(srv.URL)
+	au.TTL = 200 * time.Millisecond
+
+	tests := []struct {
+		user, password string
+		ok             bool
+		requests       int32
+	}{
+		{"admin", "123456", true, 1},
+		{"admin", "123456", true, 1}, // cached
+		{"admin", "123", false, 2},
+		{"admin", "123", false, 2}, // negative cached
+		{"test", "123456", false, 3},
+	}
+	for i, tc := range tests {
+		if ok := au.Authenticate(tc.user, tc.password); ok != tc.ok {
+			t.Errorf("#%d test failed: authenticated %v, want %v", i, ok, tc.ok)
+		}
+		if n := atomic.LoadInt32(&requests); n != tc.requests {
+			t.Errorf("#%d test failed: got %d requests, want %d", i, n, tc.requests)
+		}
+	}
+
+	// the errors are not cached
+	time.Sleep(300 * time.Millisecond)
+	atomic.StoreInt32(&failing, 1)
+	if au.Authenticate("admin", "123456") {
+		t.Error("authenticated on the server error")
+	}
+	atomic.StoreInt32(&failing, 0)
+	if !au.Authenticate("admin", "123456") {
+		t.Error("the server error is cached")
+	}
+	if n := atomic.LoadInt32(&requests); n != 5 {
+		t.Errorf("got %d requests, want 5", n)
+	}
+
+	srv.Close()
+	if !au.Authenticate("admin", "123456") {
+		t.Error("the cached result is not used")
+	}
+	if au.Authenticate("admin", "654321") {
+		t.Error("authenticated with the unavailable server")
+	}
+}
+
+func TestHTTPAuthenticatorV2(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		var req AuthRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Target == "example.com:443" || req.Client != "127.0.0.1:10000" && req.Client != "127.0.0.1:10001" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&Principal{Chains: []string{"us"}, ReadLimit: 1024})
+	}))
+	defer srv.Close()
+
+	au := NewHTTPAuthenticator(srv.URL)
+	tests := []struct {
+		req      AuthRequest
+		ok       bool
+		requests int32
+	}{
+		{AuthRequest{User: "admin", Client: "127.0.0.1:10000", Protocol: "socks5"}, true, 1},
+		{AuthRequest{User: "admin", Client: "127.0.0.1:10001", Protocol: "socks5"}, true, 1}, // the client port is ignored
+		{AuthRequest{User: "admin", Client: "127.0.0.2:10000", Protocol: "socks5"}, false, 2},
+		{AuthRequest{User: "admin", Client: "127.0.0.1:10000", Target: "example.com:443"}, false, 3},
+	}
+	for i, tc := range tests {
+		p, ok := au.AuthenticateV2(&tc.req)
+		if ok != tc.ok {
+			t.Errorf("#%d test failed: authenticated %v, want %v", i, ok, tc.ok)
+		}
+		if ok && (len(p.Chains) != 1 || p.Chains[0] != "us" || p.ReadLimit != 1024) {
+			t.Errorf("#%d test failed: got principal %+v", i, p)
+		}
+		if n := atomic.LoadInt32(&requests); n != tc.requests {
+			t.Errorf("#%d test failed: got %d requests, want %d", i, n, tc.requests)
+		}
+	}
+}
+
+// TestHTTPAuthenticatorBody checks the client and the target are posted by the handlers.
+func TestHTTPAuthenticatorBody(t *testing.T) {
+	reqs := make(chan AuthRequest, 1)
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AuthRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		reqs <- req
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer authSrv.Close()
+
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+	target, _ := url.Parse(httpSrv.URL)
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{
+		Connector:   HTTPConnector(url.UserPassword("admin", "123456")),
+		Transporter: TCPTransporter(),
+	}
+	server := &Server{
+		Listener: ln,
+		Handler:  HTTPHandler(AuthenticatorHandlerOption(NewHTTPAuthenticator(authSrv.URL))),
+	}
+	go server.Run()
+	defer server.Close()
+
+	if err := proxyRoundtrip(client, server, httpSrv.URL, []byte("gost")); err != nil {
+		t.Fatal(err)
+	}
+	req := <-reqs
+	if req.User != "admin" || req.Password != "123456" || req.Protocol != "http" || req.Target != target.Host {
+		t.Errorf("got request %+v", req)
+	}
+	if _, _, err := net.SplitHostPort(req.Client); err != nil {
+		t.Errorf("got client %s", req.Client)
+	}
+}
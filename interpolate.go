@@ -0,0 +1,177 @@
+package gost
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// redacted takes the place of the credentials in the redacted URLs.
+const redacted = "xxxxx"
+
+// Interpolate replaces the ${NAME} placeholders in s with the environment variables,
+// and the ${file:PATH} placeholders with the content of the files, such as /run/secrets/x,
+// the trailing newlines of the files are trimmed. $${ is taken as the literal ${.
+func Interpolate(s string) (string, error) {
+	lits, vals, err := splitPlaceholders(s)
+	if err != nil {
+		return "", err
+	}
+	return joinPlaceholders(lits, vals, nil), nil
+}
+
+// InterpolateURL is like Interpolate, but the values in the user info and the query of the URL s are escaped,
+// so the secrets can contain any characters.
+func InterpolateURL(s string) (string, error) {
+	lits, vals, err := splitPlaceholders(s)
+	if err != nil {
+		return "", err
+	}
+	return joinPlaceholders(lits, vals, urlEscapes(lits)), nil
+}
+
+// splitPlaceholders splits s into the literals and the values of the placeholders between them.
+func splitPlaceholders(s string) (lits []string, vals []string, err error) {
+	var lit strings.Builder
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$${") {
+			lit.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			lit.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		n := strings.IndexByte(s[i+2:], '}')
+		if n < 0 {
+			return nil, nil, fmt.Errorf("unterminated placeholder in %s", Redact(s))
+		}
+		v, err := lookupPlaceholder(s[i+2 : i+2+n])
+		if err != nil {
+			return nil, nil, err
+		}
+		lits = append(lits, lit.String())
+		vals = append(vals, v)
+		lit.Reset()
+		i += n + 3
+	}
+	lits = append(lits, lit.String())
+	return
+}
+
+func lookupPlaceholder(name string) (string, error) {
+	if strings.HasPrefix(name, "file:") {
+		b, err := ioutil.ReadFile(strings.TrimPrefix(name, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if name == "" {
+		return "", fmt.Errorf("empty placeholder")
+	}
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return v, nil
+}
+
+func joinPlaceholders(lits, vals []string, escapes []bool) string {
+	var b strings.Builder
+	for i, v := range vals {
+		b.WriteString(lits[i])
+		if escapes != nil && escapes[i] {
+			v = strings.Replace(url.QueryEscape(v), "+", "%20", -1)
+		}
+		b.WriteString(v)
+	}
+	b.WriteString(lits[len(lits)-1])
+	return b.String()
+}
+
+// urlEscapes reports whether each placeholder between the literals is in the user info or the query of the URL.
+// The placeholders in the scheme, host, path and fragment are kept as is, such as ${HOST}:8080.
+func urlEscapes(lits []string) []bool {
+	const mark = "\x00"
+	skel := strings.Join(lits, mark)
+
+	start := 0
+	if i := strings.Index(skel, "://"); i >= 0 {
+		start = i + 3
+	}
+	end := len(skel)
+	if i := strings.IndexAny(skel[start:], "/?#"); i >= 0 {
+		end = start + i
+	}
+	at := strings.LastIndexByte(skel[start:end], '@')
+	if at >= 0 {
+		at += start
+	}
+	query, fragment := len(skel), len(skel)
+	if i := strings.IndexByte(skel[end:], '#'); i >= 0 {
+		fragment = end + i
+	}
+	if i := strings.IndexByte(skel[end:fragment], '?'); i >= 0 {
+		query = end + i
+	}
+
+	escapes := make([]bool, 0, len(lits)-1)
+	pos := 0
+	for _, lit := range lits[:len(lits)-1] {
+		pos += len(lit)
+		escapes = append(escapes, (pos >= start && pos < at) || (pos > query && pos < fragment))
+		pos += len(mark)
+	}
+	return escapes
+}
+
+// redactError redacts the URL of the *url.Error err.
+func redactError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		ue.URL = Redact(ue.URL)
+	}
+	return err
+}
+
+// Redact replaces the password in the user info and the auth parameter in the query of the URL s,
+// it works on the malformed URLs as well, so s can be logged safely.
+func Redact(s string) string {
+	start := 0
+	if i := strings.Index(s, "://"); i >= 0 {
+		start = i + 3
+	}
+	end := len(s)
+	if i := strings.IndexAny(s[start:], "/?#"); i >= 0 {
+		end = start + i
+	}
+	if at := strings.LastIndexByte(s[start:end], '@'); at >= 0 {
+		at += start
+		if i := strings.IndexByte(s[start:at], ':'); i >= 0 {
+			s = s[:start+i+1] + redacted + s[at:]
+			end = start + i + 1 + len(redacted) + (end - at)
+		}
+	}
+
+	q := strings.IndexByte(s[end:], '?')
+	if q < 0 {
+		return s
+	}
+	q += end + 1
+	qend := len(s)
+	if i := strings.IndexByte(s[q:], '#'); i >= 0 {
+		qend = q + i
+	}
+	params := strings.Split(s[q:qend], "&")
+	for i, p := range params {
+		if strings.HasPrefix(p, "auth=") {
+			params[i] = "auth=" + redacted
+		}
+	}
+	return s[:q] + strings.Join(params, "&") + s[qend:]
+}
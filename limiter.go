@@ -0,0 +1,390 @@
+package gost
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	globalLimiter    *Limiter
+	globalLimiterMux sync.RWMutex
+)
+
+// GlobalLimiter returns the bandwidth limiter shared by all serve nodes, nil means unlimited.
+func GlobalLimiter() *Limiter {
+	globalLimiterMux.RLock()
+	defer globalLimiterMux.RUnlock()
+
+	return globalLimiter
+}
+
+// SetGlobalLimiter sets the bandwidth limiter shared by all serve nodes,
+// the active connections keep the limiter they are accepted with.
+func SetGlobalLimiter(l *Limiter) {
+	globalLimiterMux.Lock()
+	defer globalLimiterMux.Unlock()
+
+	globalLimiter = l
+}
+
+// ParseBytes parses a human readable byte size such as 512, 64KB, 10MB or 1GB.
+// The units are powers of 1024, the suffix 'B' and the letter case are optional.
+func ParseBytes(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	s = strings.TrimSuffix(s, "B")
+	var unit int64 = 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	case strings.HasSuffix(s, "T"):
+		unit = 1 << 40
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	if n < 0 {
+		return 0, errors.New("size must not be negative")
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// RateLimiter is a token bucket that limits the throughput in bytes per second.
+// A RateLimiter with a non-positive rate does not limit anything.
+type RateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mux    sync.Mutex
+}
+
+// NewRateLimiter creates a RateLimiter that allows rate bytes per second.
+func NewRateLimiter(rate int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimit(rate)
+	return l
+}
+
+// Limit returns the rate in bytes per second.
+func (l *RateLimiter) Limit() int64 {
+	if l == nil {
+		return 0
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return int64(l.rate)
+}
+
+// SetLimit changes the rate in bytes per second, it takes effect immediately.
+func (l *RateLimiter) SetLimit(rate int64) {
+	if l == nil {
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if rate < 0 {
+		rate = 0
+	}
+	l.rate = float64(rate)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = time.Now()
+}
+
+// reserve takes n bytes from the bucket and returns the time to wait before they can be used.
+// The bucket may go into debt, so a single large reservation never blocks forever.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	if l == nil || n <= 0 {
+		return 0
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate { // the burst size is one second of traffic.
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until n bytes are allowed to pass.
+func (l *RateLimiter) Wait(n int) {
+	if d := l.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// waitAll blocks until n bytes are allowed to pass all the limiters.
+func waitAll(n int, limiters ...*RateLimiter) {
+	var d time.Duration
+	for _, l := range limiters {
+		if v := l.reserve(n); v > d {
+			d = v
+		}
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+type limiterPair struct {
+	rlimit, wlimit int64
+	r, w           *RateLimiter
+}
+
+func newLimiterPair(rlimit, wlimit int64) *limiterPair {
+	return &limiterPair{
+		rlimit: rlimit,
+		wlimit: wlimit,
+		r:      NewRateLimiter(rlimit),
+		w:      NewRateLimiter(wlimit),
+	}
+}
+
+func (p *limiterPair) set(rlimit, wlimit int64) {
+	p.rlimit, p.wlimit = rlimit, wlimit
+	p.r.SetLimit(rlimit)
+	p.w.SetLimit(wlimit)
+}
+
+// Limiter limits the bandwidth of the client connections.
+// It holds a pair of token buckets shared by all the connections passing through it (such as a listener),
+// and a pair of token buckets for each authenticated user.
+// The read limit applies to the data read from the client (upload),
+// the write limit applies to the data written to the client (download).
+//
+// The config file format:
+//
+//	reload 10s       # reload period
+//	*     10MB 2MB   # limits for all the connections, overrides the initial limits
+//	admin 1MB  512KB # limits for user admin
+//	guest 64KB 64KB
+//
+// Users not listed in the file are only limited by the shared limits.
+type Limiter struct {
+	base    [2]int64 // the initial limits
+	all     *limiterPair
+	users   map[string]*limiterPair
+	period  time.Duration
+	stopped chan struct{}
+	mux     sync.RWMutex
+}
+
+// NewLimiter creates a Limiter with the limits shared by all the connections,
+// a non-positive limit means unlimited.
+func NewLimiter(rlimit, wlimit int64) *Limiter {
+	return &Limiter{
+		base:    [2]int64{rlimit, wlimit},
+		all:     newLimiterPair(rlimit, wlimit),
+		users:   make(map[string]*limiterPair),
+		stopped: make(chan struct{}),
+	}
+}
+
+// SetUserLimit sets the limits for the user, a non-positive limit means unlimited.
+func (l *Limiter) SetUserLimit(user string, rlimit, wlimit int64) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if p := l.users[user]; p != nil {
+		p.set(rlimit, wlimit)
+		return
+	}
+	l.users[user] = newLimiterPair(rlimit, wlimit)
+}
+
+// Limits returns the read and write limits for the user,
+// an empty user means the limits shared by all the connections.
+func (l *Limiter) Limits(user string) (rlimit, wlimit int64) {
+	if l == nil {
+		return
+	}
+
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	p := l.all
+	if user != "" {
+		p = l.users[user]
+	}
+	if p == nil {
+		return
+	}
+	return p.rlimit, p.wlimit
+}
+
+func (l *Limiter) limiters(user string) (r, w []*RateLimiter) {
+	if l == nil {
+		return
+	}
+
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	r = append(r, l.all.r)
+	w = append(w, l.all.w)
+	if p := l.users[user]; user != "" && p != nil {
+		r = append(r, p.r)
+		w = append(w, p.w)
+	}
+	return
+}
+
+// Reload parses config from r, then live reloads the Limiter.
+// The token buckets are updated in place, so the active connections get the new limits immediately.
+func (l *Limiter) Reload(r io.Reader) error {
+	var period time.Duration
+	all := l.base
+	users := make(map[string][2]int64)
+
+	if r == nil || l.Stopped() {
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ss := splitLine(scanner.Text())
+		if len(ss) < 2 {
+			continue // invalid lines are ignored
+		}
+
+		switch ss[0] {
+		case "reload": // reload option
+			period, _ = time.ParseDuration(ss[1])
+		default:
+			var limits [2]int64
+			for i := 0; i < 2 && i+1 < len(ss); i++ {
+				n, err := ParseBytes(ss[i+1])
+				if err != nil {
+					return err
+				}
+				limits[i] = n
+			}
+			if ss[0] == "*" {
+				all = limits
+				break
+			}
+			users[ss[0]] = limits
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.period = period
+	l.all.set(all[0], all[1])
+	for user, p := range l.users {
+		if _, ok := users[user]; !ok {
+			p.set(0, 0) // release the connections that are still referring to it.
+			delete(l.users, user)
+		}
+	}
+	for user, limits := range users {
+		if p := l.users[user]; p != nil {
+			p.set(limits[0], limits[1])
+			continue
+		}
+		l.users[user] = newLimiterPair(limits[0], limits[1])
+	}
+
+	return nil
+}
+
+// Period returns the reload period.
+func (l *Limiter) Period() time.Duration {
+	if l.Stopped() {
+		return -1
+	}
+
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	return l.period
+}
+
+// Stop stops reloading.
+func (l *Limiter) Stop() {
+	select {
+	case <-l.stopped:
+	default:
+		close(l.stopped)
+	}
+}
+
+// Stopped checks whether the reloader is stopped.
+func (l *Limiter) Stopped() bool {
+	select {
+	case <-l.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// limitConn wraps the client connection conn with the bandwidth limiters
+// of the global limiter and the limiter l for the user.
+func limitConn(conn net.Conn, l *Limiter, user string) net.Conn {
+	gr, gw := GlobalLimiter().limiters(user)
+	r, w := l.limiters(user)
+	r, w = append(gr, r...), append(gw, w...)
+	if len(r) == 0 && len(w) == 0 {
+		return conn
+	}
+	return &limitedConn{Conn: conn, rlimiters: r, wlimiters: w}
+}
+
+type limitedConn struct {
+	net.Conn
+	rlimiters []*RateLimiter
+	wlimiters []*RateLimiter
+}
+
+func (c *limitedConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	waitAll(n, c.rlimiters...)
+	return
+}
+
+func (c *limitedConn) Write(b []byte) (n int, err error) {
+	waitAll(len(b), c.wlimiters...)
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,145 @@
+package gost
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+var parseBytesTests = []struct {
+	s   string
+	n   int64
+	err bool
+}{
+	{"", 0, false},
+	{"0", 0, false},
+	{"512", 512, false},
+	{"512B", 512, false},
+	{"64k", 64 * 1024, false},
+	{"64KB", 64 * 1024, false},
+	{"10MB", 10 * 1024 * 1024, false},
+	{"1.5M", 1024 * 1024 * 3 / 2, false},
+	{"1GB", 1024 * 1024 * 1024, false},
+	{"-1MB", 0, true},
+	{"MB", 0, true},
+	{"abc", 0, true},
+}
+
+func TestParseBytes(t *testing.T) {
+	for i, tc := range parseBytesTests {
+		n, err := ParseBytes(tc.s)
+		if (err != nil) != tc.err {
+			t.Errorf("#%d test failed: %s, error %v", i, tc.s, err)
+			continue
+		}
+		if n != tc.n {
+			t.Errorf("#%d test failed: %s, got %d, want %d", i, tc.s, n, tc.n)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(0)
+	start := time.Now()
+	l.Wait(1024 * 1024)
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("unlimited rate limiter should not block")
+	}
+
+	l.SetLimit(100 * 1024)
+	if l.Limit() != 100*1024 {
+		t.Errorf("wrong limit %d", l.Limit())
+	}
+
+	start = time.Now()
+	l.Wait(20 * 1024) // the bucket is empty after SetLimit, 200ms
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("wait %v, want about 200ms", d)
+	}
+}
+
+var limiterReloadTests = []struct {
+	r      io.Reader
+	period time.Duration
+	all    [2]int64
+	users  map[string][2]int64
+}{
+	{
+		r:   nil,
+		all: [2]int64{1024, 2048},
+	},
+	{
+		r:   bytes.NewBufferString(""),
+		all: [2]int64{1024, 2048},
+	},
+	{
+		r:      bytes.NewBufferString("reload 10s\n* 1MB 2MB"),
+		period: 10 * time.Second,
+		all:    [2]int64{1 << 20, 2 << 20},
+	},
+	{
+		r:   bytes.NewBufferString("# * 1MB 2MB\nadmin 64KB 1KB\nguest 1KB # 2KB\ntest"),
+		all: [2]int64{1024, 2048},
+		users: map[string][2]int64{
+			"admin": {64 << 10, 1 << 10},
+			"guest": {1 << 10, 0},
+		},
+	},
+}
+
+func TestLimiterReload(t *testing.T) {
+	for i, tc := range limiterReloadTests {
+		l := NewLimiter(1024, 2048)
+		l.SetUserLimit("test", 1, 1)
+		if err := l.Reload(tc.r); err != nil {
+			t.Errorf("#%d test failed: %v", i, err)
+			continue
+		}
+		if l.Period() != tc.period {
+			t.Errorf("#%d test failed: period %v, want %v", i, l.Period(), tc.period)
+		}
+		if r, w := l.Limits(""); r != tc.all[0] || w != tc.all[1] {
+			t.Errorf("#%d test failed: limits %d/%d, want %d/%d", i, r, w, tc.all[0], tc.all[1])
+		}
+		for user, limits := range tc.users {
+			if r, w := l.Limits(user); r != limits[0] || w != limits[1] {
+				t.Errorf("#%d test failed: %s limits %d/%d, want %d/%d", i, user, r, w, limits[0], limits[1])
+			}
+		}
+		if tc.r != nil {
+			if r, w := l.Limits("test"); r != 0 || w != 0 {
+				t.Errorf("#%d test failed: user test should be removed", i)
+			}
+		}
+
+		l.Stop()
+		if l.Period() >= 0 {
+			t.Errorf("#%d test failed: period of the stopped reloader should be minus value", i)
+		}
+	}
+}
+
+func TestLimitConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	if conn := limitConn(c1, nil, ""); conn != c1 {
+		t.Error("connection should not be wrapped without limiter")
+	}
+
+	l := NewLimiter(0, 0)
+	l.SetUserLimit("admin", 0, 50*1024)
+	conn := limitConn(c1, l, "admin")
+
+	go io.Copy(ioutil.Discard, c2)
+
+	start := time.Now()
+	conn.Write(make([]byte, 10*1024)) // the bucket is empty, 200ms
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("write takes %v, want about 200ms", d)
+	}
+}
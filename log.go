@@ -3,6 +3,9 @@ package gost
 import (
 	"fmt"
 	"log"
+	"strings"
+
+	golog "github.com/go-log/log"
 )
 
 func init() {
@@ -34,3 +37,127 @@ func (l *NopLogger) Log(v ...interface{}) {
 // Logf does nothing
 func (l *NopLogger) Logf(format string, v ...interface{}) {
 }
+
+// LogLevel is the severity of the log message.
+type LogLevel int
+
+// Log levels.
+const (
+	DebugLevel LogLevel = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// ParseLogLevel parses the level name debug, info, warn or error.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("invalid log level: %s", s)
+}
+
+// LeveledLogger is a leveled logger with structured fields.
+type LeveledLogger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+	// Enabled reports whether the messages of the level are logged,
+	// it can be used to avoid building expensive messages.
+	Enabled(level LogLevel) bool
+	// WithFields returns a logger that adds the fields to each message,
+	// the fields are alternating keys and values.
+	WithFields(fields ...interface{}) LeveledLogger
+}
+
+var (
+	// DefaultLeveledLogger writes the messages through the logger set by SetLogger,
+	// the debug messages are only logged when Debug is enabled.
+	DefaultLeveledLogger LeveledLogger = &goLogger{auto: true}
+)
+
+// NewLeveledLogger creates a LeveledLogger that writes the messages of the level and above
+// through the logger set by SetLogger.
+func NewLeveledLogger(level LogLevel) LeveledLogger {
+	return &goLogger{level: level}
+}
+
+type goLogger struct {
+	level  LogLevel
+	auto   bool // the level follows the global Debug switch
+	fields string
+	out    golog.Logger // nil for the logger set by SetLogger
+}
+
+func (l *goLogger) Enabled(level LogLevel) bool {
+	if l.auto {
+		return Debug || level > DebugLevel
+	}
+	return level >= l.level
+}
+
+func (l *goLogger) logf(level LogLevel, format string, v ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	if l.fields != "" {
+		format += l.fields
+	}
+	out := l.out
+	if out == nil {
+		out = golog.DefaultLogger
+	}
+	if _, ok := out.(*LogLogger); ok {
+		log.Output(3, fmt.Sprintf(format, v...)) // report the file and line of the caller.
+		return
+	}
+	out.Logf(format, v...)
+}
+
+func (l *goLogger) Debugf(format string, v ...interface{}) {
+	l.logf(DebugLevel, format, v...)
+}
+
+func (l *goLogger) Infof(format string, v ...interface{}) {
+	l.logf(InfoLevel, format, v...)
+}
+
+func (l *goLogger) Warnf(format string, v ...interface{}) {
+	l.logf(WarnLevel, format, v...)
+}
+
+func (l *goLogger) Errorf(format string, v ...interface{}) {
+	l.logf(ErrorLevel, format, v...)
+}
+
+func (l *goLogger) WithFields(fields ...interface{}) LeveledLogger {
+	b := &strings.Builder{}
+	b.WriteString(l.fields)
+	for i := 0; i+1 < len(fields); i += 2 {
+		// escape the verbs, the fields are appended to the format.
+		fmt.Fprintf(b, " %v=%v", fields[i], strings.Replace(fmt.Sprint(fields[i+1]), "%", "%%", -1))
+	}
+	return &goLogger{level: l.level, auto: l.auto, fields: b.String(), out: l.out}
+}
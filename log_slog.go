@@ -0,0 +1,63 @@
+//go:build go1.21
+// +build go1.21
+
+package gost
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// NewSlogLogger creates a LeveledLogger that writes the messages to the slog logger l.
+func NewSlogLogger(l *slog.Logger) LeveledLogger {
+	return &slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case DebugLevel:
+		return slog.LevelDebug
+	case WarnLevel:
+		return slog.LevelWarn
+	case ErrorLevel:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+func (l *slogLogger) logf(level LogLevel, format string, v ...interface{}) {
+	ctx := context.Background()
+	if !l.l.Enabled(ctx, slogLevel(level)) {
+		return
+	}
+	l.l.Log(ctx, slogLevel(level), fmt.Sprintf(format, v...))
+}
+
+func (l *slogLogger) Debugf(format string, v ...interface{}) {
+	l.logf(DebugLevel, format, v...)
+}
+
+func (l *slogLogger) Infof(format string, v ...interface{}) {
+	l.logf(InfoLevel, format, v...)
+}
+
+func (l *slogLogger) Warnf(format string, v ...interface{}) {
+	l.logf(WarnLevel, format, v...)
+}
+
+func (l *slogLogger) Errorf(format string, v ...interface{}) {
+	l.logf(ErrorLevel, format, v...)
+}
+
+func (l *slogLogger) Enabled(level LogLevel) bool {
+	return l.l.Enabled(context.Background(), slogLevel(level))
+}
+
+func (l *slogLogger) WithFields(fields ...interface{}) LeveledLogger {
+	return &slogLogger{l: l.l.With(fields...)}
+}
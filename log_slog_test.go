@@ -0,0 +1,30 @@
+//go:build go1.21
+// +build go1.21
+
+package gost
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	logger.Debugf("debug")
+	logger.WithFields("node", "http://:8080").Warnf("warn %d", 1)
+
+	s := buf.String()
+	if strings.Contains(s, "debug") {
+		t.Errorf("debug message should be dropped: %s", s)
+	}
+	if !strings.Contains(s, `level=WARN msg="warn 1" node=http://:8080`) {
+		t.Errorf("unexpected output: %s", s)
+	}
+	if logger.Enabled(DebugLevel) || !logger.Enabled(InfoLevel) {
+		t.Error("wrong enabled levels")
+	}
+}
@@ -0,0 +1,99 @@
+package gost
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// captureLogger keeps the logs, the tests use it on the local loggers,
+// so the global logger shared by the running servers is untouched.
+type captureLogger struct {
+	logs []string
+	mux  sync.Mutex
+}
+
+func (l *captureLogger) Log(v ...interface{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.logs = append(l.logs, fmt.Sprint(v...))
+}
+
+func (l *captureLogger) Logf(format string, v ...interface{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.logs = append(l.logs, fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) String() string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return fmt.Sprint(l.logs)
+}
+
+var parseLogLevelTests = []struct {
+	s     string
+	level LogLevel
+	err   bool
+}{
+	{"debug", DebugLevel, false},
+	{"INFO", InfoLevel, false},
+	{"warn", WarnLevel, false},
+	{"warning", WarnLevel, false},
+	{"error", ErrorLevel, false},
+	{"", InfoLevel, true},
+	{"trace", InfoLevel, true},
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for i, tc := range parseLogLevelTests {
+		level, err := ParseLogLevel(tc.s)
+		if (err != nil) != tc.err {
+			t.Errorf("#%d test failed: %s, error %v", i, tc.s, err)
+			continue
+		}
+		if level != tc.level {
+			t.Errorf("#%d test failed: %s, got %v, want %v", i, tc.s, level, tc.level)
+		}
+	}
+}
+
+func TestLeveledLogger(t *testing.T) {
+	l := &captureLogger{}
+	logger := LeveledLogger(&goLogger{level: WarnLevel, out: l})
+	logger.Debugf("debug")
+	logger.Infof("info")
+	logger.Warnf("warn %d", 1)
+	logger.WithFields("node", "socks5://:1080", "user", "100%").Errorf("error %d", 2)
+
+	want := []string{"warn 1", "error 2 node=socks5://:1080 user=100%"}
+	if l.String() != fmt.Sprint(want) {
+		t.Errorf("got %s, want %q", l, want)
+	}
+	if logger.Enabled(InfoLevel) || !logger.Enabled(ErrorLevel) {
+		t.Error("wrong enabled levels")
+	}
+}
+
+func TestDefaultLeveledLogger(t *testing.T) {
+	// the auto level follows the global Debug switch, which is only read here.
+	l := &captureLogger{}
+	logger := &goLogger{auto: true, out: l}
+	logger.Debugf("debug 1")
+	logger.Infof("info 1")
+
+	want := []string{"info 1"}
+	if Debug {
+		want = []string{"debug 1", "info 1"}
+	}
+	if l.String() != fmt.Sprint(want) {
+		t.Errorf("got %s, want %q", l, want)
+	}
+	if logger.Enabled(DebugLevel) != Debug || !logger.Enabled(InfoLevel) {
+		t.Error("wrong enabled levels")
+	}
+
+	if (&HandlerOptions{}).logger() != DefaultLeveledLogger {
+		t.Error("handler should use the default logger")
+	}
+}
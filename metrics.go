@@ -0,0 +1,284 @@
+package gost
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// DefaultMetrics is the metrics collector used by the servers, chains and resolvers,
+	// nil means the metrics collection is disabled.
+	DefaultMetrics *Metrics
+)
+
+const (
+	metricConnsTotal   = "gost_connections_total"
+	metricConnsActive  = "gost_connections_active"
+	metricBytesTotal   = "gost_transfer_bytes_total"
+	metricDialDuration = "gost_chain_dial_duration_seconds"
+	metricNodeFailures = "gost_chain_node_failures_total"
+	metricCacheHits    = "gost_resolver_cache_hits_total"
+	metricCacheMisses  = "gost_resolver_cache_misses_total"
+	metricAuthFailures = "gost_auth_failures_total"
+)
+
+var metricDescs = map[string]struct {
+	typ  string
+	help string
+}{
+	metricConnsTotal:   {"counter", "Total number of the accepted client connections."},
+	metricConnsActive:  {"gauge", "Number of the active client connections."},
+	metricBytesTotal:   {"counter", "Total bytes transferred with the clients."},
+	metricDialDuration: {"histogram", "Duration of connecting and handshaking with the chain node."},
+	metricNodeFailures: {"counter", "Total number of the failures of the chain node."},
+	metricCacheHits:    {"counter", "Total number of the resolver cache hits."},
+	metricCacheMisses:  {"counter", "Total number of the resolver cache misses."},
+	metricAuthFailures: {"counter", "Total number of the client authentication failures."},
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dialDurationBuckets are the upper bounds of the dial duration histogram in seconds.
+var dialDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricSeries struct {
+	labels  string
+	bits    uint64 // float64 value for counter and gauge
+	buckets []uint64
+	sum     float64
+	count   uint64
+	mux     sync.Mutex
+}
+
+func (s *metricSeries) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&s.bits)
+		nv := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&s.bits, old, nv) {
+			return
+		}
+	}
+}
+
+func (s *metricSeries) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.bits))
+}
+
+func (s *metricSeries) observe(v float64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(dialDurationBuckets))
+	}
+	for i, b := range dialDurationBuckets {
+		if v <= b {
+			s.buckets[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Metrics collects the runtime metrics and exposes them in the Prometheus text format.
+type Metrics struct {
+	series map[string]map[string]*metricSeries // name -> labels -> series
+	mux    sync.RWMutex
+}
+
+// NewMetrics creates a Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		series: make(map[string]map[string]*metricSeries),
+	}
+}
+
+// get returns the series of the metric name with the label pairs, the series will be created if it does not exist.
+func (m *Metrics) get(name string, labels ...string) *metricSeries {
+	b := &strings.Builder{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	key := b.String()
+
+	m.mux.RLock()
+	s := m.series[name][key]
+	m.mux.RUnlock()
+	if s != nil {
+		return s
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	ss := m.series[name]
+	if ss == nil {
+		ss = make(map[string]*metricSeries)
+		m.series[name] = ss
+	}
+	if s = ss[key]; s == nil {
+		s = &metricSeries{labels: key}
+		ss[key] = s
+	}
+	return s
+}
+
+// Value returns the current value of the counter or gauge metric name with the label pairs.
+func (m *Metrics) Value(name string, labels ...string) float64 {
+	if m == nil {
+		return 0
+	}
+	return m.get(name, labels...).value()
+}
+
+func (m *Metrics) add(name string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.get(name, labels...).add(v)
+}
+
+func (m *Metrics) observe(name string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.get(name, labels...).observe(v)
+}
+
+// ServeHTTP writes all the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	names := make([]string, 0, len(m.series))
+	for name := range m.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		desc := metricDescs[name]
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, desc.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, desc.typ)
+
+		ss := m.series[name]
+		keys := make([]string, 0, len(ss))
+		for k := range ss {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := ss[k]
+			if desc.typ != "histogram" {
+				fmt.Fprintf(bw, "%s%s %v\n", name, braces(s.labels), s.value())
+				continue
+			}
+
+			s.mux.Lock()
+			for i, b := range dialDurationBuckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, braces(joinLabels(s.labels, fmt.Sprintf("le=\"%v\"", b))), s.buckets[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, braces(joinLabels(s.labels, `le="+Inf"`)), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %v\n", name, braces(s.labels), s.sum)
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, braces(s.labels), s.count)
+			s.mux.Unlock()
+		}
+	}
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// connAccepted records a new client connection accepted by the serve node,
+// the returned function must be called when the connection is finished.
+func (m *Metrics) connAccepted(node, handler string) func() {
+	if m == nil {
+		return func() {}
+	}
+	m.add(metricConnsTotal, 1, "node", node, "handler", handler)
+	active := m.get(metricConnsActive, "node", node, "handler", handler)
+	active.add(1)
+	return func() { active.add(-1) }
+}
+
+func (m *Metrics) dialObserved(node Node, d time.Duration) {
+	m.observe(metricDialDuration, d.Seconds(), "node", node.String())
+}
+
+func (m *Metrics) nodeFailed(node *Node) {
+	m.add(metricNodeFailures, 1, "node", node.String())
+}
+
+func (m *Metrics) cacheLookup(hit bool) {
+	if hit {
+		m.add(metricCacheHits, 1)
+		return
+	}
+	m.add(metricCacheMisses, 1)
+}
+
+func (m *Metrics) authFailed(handler string) {
+	m.add(metricAuthFailures, 1, "handler", handler)
+}
+
+// wrapConn wraps the client connection conn of the serve node to count the transferred bytes.
+func (m *Metrics) wrapConn(conn net.Conn, node Node) net.Conn {
+	if m == nil {
+		return conn
+	}
+	handler := node.Protocol
+	if handler == "" {
+		handler = "auto"
+	}
+
+	return &metricsConn{
+		Conn: conn,
+		in:   m.get(metricBytesTotal, "node", node.String(), "handler", handler, "direction", "in"),
+		out:  m.get(metricBytesTotal, "node", node.String(), "handler", handler, "direction", "out"),
+	}
+}
+
+type metricsConn struct {
+	net.Conn
+	in, out *metricSeries
+}
+
+func (c *metricsConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.in.add(float64(n))
+	return
+}
+
+func (c *metricsConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.out.add(float64(n))
+	return
+}
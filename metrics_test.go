@@ -0,0 +1,104 @@
+package gost
+
+import (
+	"crypto/rand"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHTTPProxy(t *testing.T) {
+	DefaultMetrics = NewMetrics()
+	defer func() { DefaultMetrics = nil }()
+
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := Node{Protocol: "http", Transport: "tcp", Addr: ln.Addr().String()}
+	server := &Server{
+		Listener: ln,
+		Handler: HTTPHandler(
+			UsersHandlerOption(url.UserPassword("admin", "123456")),
+			NodeHandlerOption(node),
+		),
+	}
+	server.Init(NodeServerOption(node))
+	go server.Run()
+	defer server.Close()
+
+	client := &Client{
+		Connector:   HTTPConnector(url.UserPassword("admin", "123456")),
+		Transporter: TCPTransporter(),
+	}
+	if err := proxyRoundtrip(client, server, httpSrv.URL, sendData); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Connector = HTTPConnector(url.UserPassword("admin", "654321"))
+	if err := proxyRoundtrip(client, server, httpSrv.URL, sendData); err == nil {
+		t.Error("authentication should fail")
+	}
+
+	// the handler goroutines may still be finishing.
+	time.Sleep(100 * time.Millisecond)
+
+	labels := []string{"node", node.String(), "handler", "http"}
+	if v := DefaultMetrics.Value(metricConnsTotal, labels...); v != 2 {
+		t.Errorf("total connections %v, want 2", v)
+	}
+	if v := DefaultMetrics.Value(metricConnsActive, labels...); v != 0 {
+		t.Errorf("active connections %v, want 0", v)
+	}
+	if v := DefaultMetrics.Value(metricBytesTotal, append(labels, "direction", "in")...); v == 0 {
+		t.Error("inbound bytes should be counted")
+	}
+	if v := DefaultMetrics.Value(metricAuthFailures, "handler", "http"); v != 1 {
+		t.Errorf("auth failures %v, want 1", v)
+	}
+
+	w := httptest.NewRecorder()
+	DefaultMetrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, s := range []string{
+		"# TYPE gost_connections_total counter",
+		`gost_connections_total{node="` + node.String() + `",handler="http"} 2`,
+		`gost_auth_failures_total{handler="http"} 1`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%q not found in metrics:\n%s", s, body)
+		}
+	}
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	m := NewMetrics()
+	node := Node{Protocol: "socks5", Transport: "tcp", Addr: "127.0.0.1:1080"}
+	m.dialObserved(node, 20*time.Millisecond)
+	m.dialObserved(node, 2*time.Second)
+	m.nodeFailed(&node)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, s := range []string{
+		"# TYPE gost_chain_dial_duration_seconds histogram",
+		`gost_chain_dial_duration_seconds_bucket{node="socks5+tcp://127.0.0.1:1080",le="0.025"} 1`,
+		`gost_chain_dial_duration_seconds_bucket{node="socks5+tcp://127.0.0.1:1080",le="+Inf"} 2`,
+		`gost_chain_dial_duration_seconds_count{node="socks5+tcp://127.0.0.1:1080"} 2`,
+		`gost_chain_node_failures_total{node="socks5+tcp://127.0.0.1:1080"} 1`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%q not found in metrics:\n%s", s, body)
+		}
+	}
+}
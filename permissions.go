@@ -1,20 +1,26 @@
 package gost
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	glob "github.com/ryanuber/go-glob"
 )
 
 // Permission is a rule for blacklist and whitelist.
 type Permission struct {
-	Actions StringSet
-	Hosts   StringSet
-	Ports   PortSet
+	Actions  StringSet
+	Hosts    StringSet
+	Ports    PortSet
+	Schedule *Schedule // the permission applies only within the schedule if it is set
 }
 
 // PortRange specifies the range of port, such as 1000-2000.
@@ -119,9 +125,12 @@ func ParseStringSet(s string) (*StringSet, error) {
 }
 
 // Contains checks whether the string subj within this StringSet.
+// Besides the glob patterns, the hosts of the permissions can be the CIDRs, such as 10.0.0.0/8,
+// the IP ranges, such as 10.0.0.1-10.0.0.100, and the regular expressions prefixed with re:,
+// such as re:^(www|api)\.example\.com$.
 func (ss *StringSet) Contains(subj string) bool {
 	for _, s := range *ss {
-		if glob.Glob(s, subj) {
+		if matchHost(s, subj) {
 			return true
 		}
 	}
@@ -129,10 +138,85 @@ func (ss *StringSet) Contains(subj string) bool {
 	return false
 }
 
+// the compiled regular expressions of the permissions, by the patterns.
+var permRegexps sync.Map
+
+func matchHost(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "re:") {
+		re, err := permRegexp(pattern)
+		return err == nil && re.MatchString(host)
+	}
+	if ipnet, first, last := parseIPRule(pattern); ipnet != nil || first != nil {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		if ipnet != nil {
+			return ipnet.Contains(ip)
+		}
+		return bytes.Compare(ip.To16(), first.To16()) >= 0 && bytes.Compare(ip.To16(), last.To16()) <= 0 &&
+			(ip.To4() == nil) == (first.To4() == nil)
+	}
+	return glob.Glob(pattern, host)
+}
+
+func permRegexp(pattern string) (*regexp.Regexp, error) {
+	if v, ok := permRegexps.Load(pattern); ok {
+		return v.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+	if err != nil {
+		return nil, err
+	}
+	permRegexps.Store(pattern, re)
+	return re, nil
+}
+
+// parseIPRule parses the CIDR or the IP range s, both are nil if s is neither of them.
+func parseIPRule(s string) (ipnet *net.IPNet, first, last net.IP) {
+	if strings.Contains(s, "/") {
+		if _, ipnet, err := net.ParseCIDR(s); err == nil {
+			return ipnet, nil, nil
+		}
+		return
+	}
+	if n := strings.IndexByte(s, '-'); n > 0 {
+		first, last = net.ParseIP(s[:n]), net.ParseIP(s[n+1:])
+		if first == nil || last == nil {
+			return nil, nil, nil
+		}
+	}
+	return
+}
+
+func isIPRule(s string) bool {
+	ipnet, first, _ := parseIPRule(s)
+	return ipnet != nil || first != nil
+}
+
+func checkHostRule(s string) error {
+	switch {
+	case strings.HasPrefix(s, "re:"):
+		_, err := permRegexp(s)
+		return err
+	case strings.Contains(s, "/") && !isIPRule(s):
+		return fmt.Errorf("invalid CIDR: %s", s)
+	}
+	if _, first, last := parseIPRule(s); first != nil && (first.To4() == nil) != (last.To4() == nil) {
+		return fmt.Errorf("invalid IP range: %s", s)
+	}
+	return nil
+}
+
 // Permissions is a set of Permission.
 type Permissions []Permission
 
 // ParsePermissions parses the s to a Permissions.
+// The permissions are separated by spaces, each of them is in the form of actions:hosts:ports,
+// the hosts may contain colons, such as fd00::/8, and a host of re: takes the rest of the hosts,
+// so the regular expression may contain commas, but not spaces.
+// The optional schedule in the format of ParseSchedule follows the last @,
+// such as bind,udp:*:*@mon-fri,09:00-18:00,Europe/Berlin.
 func ParsePermissions(s string) (*Permissions, error) {
 	ps := &Permissions{}
 
@@ -143,7 +227,20 @@ func ParsePermissions(s string) (*Permissions, error) {
 	perms := strings.Split(s, " ")
 
 	for _, perm := range perms {
+		var schedule *Schedule
+		if n := strings.LastIndexByte(perm, '@'); n >= 0 {
+			var err error
+			if schedule, err = ParseSchedule(perm[n+1:]); err != nil {
+				return nil, fmt.Errorf("schedule must look like mon-fri,09:00-18:00,UTC given: %s: %v", perm[n+1:], err)
+			}
+			perm = perm[:n]
+		}
+
 		parts := strings.Split(perm, ":")
+		if len(parts) > 3 {
+			i, j := strings.IndexByte(perm, ':'), strings.LastIndexByte(perm, ':')
+			parts = []string{perm[:i], perm[i+1 : j], perm[j+1:]}
+		}
 
 		switch len(parts) {
 		case 3:
@@ -153,10 +250,10 @@ func ParsePermissions(s string) (*Permissions, error) {
 				return nil, fmt.Errorf("action list must look like connect,bind given: %s", parts[0])
 			}
 
-			hosts, err := ParseStringSet(parts[1])
+			hosts, err := parseHosts(parts[1])
 
 			if err != nil {
-				return nil, fmt.Errorf("hosts list must look like google.pl,*.google.com given: %s", parts[1])
+				return nil, fmt.Errorf("hosts list must look like google.pl,*.google.com,10.0.0.0/8 given: %s: %v", parts[1], err)
 			}
 
 			ports, err := ParsePortSet(parts[2])
@@ -165,7 +262,7 @@ func ParsePermissions(s string) (*Permissions, error) {
 				return nil, fmt.Errorf("ports list must look like 80,8000-9000, given: %s", parts[2])
 			}
 
-			permission := Permission{Actions: *actions, Hosts: *hosts, Ports: *ports}
+			permission := Permission{Actions: *actions, Hosts: *hosts, Ports: *ports, Schedule: schedule}
 
 			*ps = append(*ps, permission)
 		default:
@@ -176,10 +273,99 @@ func ParsePermissions(s string) (*Permissions, error) {
 	return ps, nil
 }
 
-// Can tests whether the given action and host:port is allowed by this Permissions.
+func parseHosts(s string) (*StringSet, error) {
+	var re string
+	if strings.HasPrefix(s, "re:") {
+		s, re = "", s
+	} else if n := strings.Index(s, ",re:"); n >= 0 {
+		s, re = s[:n], s[n+1:]
+	}
+
+	ss := &StringSet{}
+	if s != "" {
+		*ss = strings.Split(s, ",")
+	}
+	if re != "" {
+		*ss = append(*ss, re)
+	}
+	if len(*ss) == 0 {
+		return nil, errors.New("cannot be empty")
+	}
+	for _, h := range *ss {
+		if err := checkHostRule(h); err != nil {
+			return nil, err
+		}
+	}
+	return ss, nil
+}
+
+// hasIPRules checks whether the hosts of the permissions have the CIDRs or the IP ranges.
+func (ps *Permissions) hasIPRules() bool {
+	if ps == nil {
+		return false
+	}
+	for _, p := range *ps {
+		for _, h := range p.Hosts {
+			if isIPRule(h) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String returns the permissions in the format of ParsePermissions.
+func (ps *Permissions) String() string {
+	if ps == nil {
+		return ""
+	}
+	var ss []string
+	for _, p := range *ps {
+		var ports []string
+		for _, r := range p.Ports {
+			if r.Min == r.Max {
+				ports = append(ports, strconv.Itoa(r.Min))
+			} else {
+				ports = append(ports, fmt.Sprintf("%d-%d", r.Min, r.Max))
+			}
+		}
+		s := strings.Join(p.Actions, ",") + ":" + strings.Join(p.Hosts, ",") + ":" + strings.Join(ports, ",")
+		if p.Schedule != nil {
+			s += "@" + p.Schedule.String()
+		}
+		ss = append(ss, s)
+	}
+	return strings.Join(ss, " ")
+}
+
+// MarshalJSON encodes the permissions as a string in the format of ParsePermissions.
+func (ps *Permissions) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ps.String())
+}
+
+// UnmarshalJSON decodes the permissions from a string in the format of ParsePermissions.
+func (ps *Permissions) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := ParsePermissions(s)
+	if err != nil {
+		return err
+	}
+	*ps = *v
+	return nil
+}
+
+// Can tests whether the given action and host:port is allowed by this Permissions at the moment.
 func (ps *Permissions) Can(action string, host string, port int) bool {
+	return ps.CanAt(action, host, port, time.Now())
+}
+
+// CanAt tests whether the given action and host:port is allowed by this Permissions at the time t.
+func (ps *Permissions) CanAt(action string, host string, port int, t time.Time) bool {
 	for _, p := range *ps {
-		if p.Actions.Contains(action) && p.Hosts.Contains(host) && p.Ports.Contains(port) {
+		if p.Actions.Contains(action) && p.Hosts.Contains(host) && p.Ports.Contains(port) && p.Schedule.Active(t) {
 			return true
 		}
 	}
@@ -203,6 +389,19 @@ func maxint(x, y int) int {
 
 // Can tests whether the given action and address is allowed by the whitelist and blacklist.
 func Can(action string, addr string, whitelist, blacklist *Permissions) bool {
+	return CanResolve(action, addr, whitelist, blacklist, nil)
+}
+
+// CanResolve is like Can, but if the permissions have the CIDRs or the IP ranges,
+// the hostname of the address is resolved by lookup, so the IP rules apply to it as well.
+// The hostname is allowed by the whitelist if all its IPs are allowed,
+// and it is denied by the blacklist if any of its IPs is denied, or it can not be resolved.
+func CanResolve(action string, addr string, whitelist, blacklist *Permissions, lookup func(host string) ([]net.IP, error)) bool {
+	return canResolveAt(action, addr, whitelist, blacklist, lookup, time.Now())
+}
+
+// canResolveAt is like CanResolve, the schedules of the permissions are checked at the time now.
+func canResolveAt(action string, addr string, whitelist, blacklist *Permissions, lookup func(host string) ([]net.IP, error), now time.Time) bool {
 	if !strings.Contains(addr, ":") {
 		addr = addr + ":80"
 	}
@@ -218,6 +417,34 @@ func Can(action string, addr string, whitelist, blacklist *Permissions) bool {
 		return false
 	}
 
-	return (whitelist == nil || whitelist.Can(action, host, port)) &&
-		(blacklist == nil || !blacklist.Can(action, host, port))
+	var ips []net.IP
+	if lookup != nil && net.ParseIP(host) == nil &&
+		(whitelist.hasIPRules() || blacklist.hasIPRules()) {
+		ips, err = lookup(host)
+		if (err != nil || len(ips) == 0) && blacklist.hasIPRules() {
+			return false
+		}
+	}
+
+	if whitelist != nil && !whitelist.CanAt(action, host, port, now) {
+		if len(ips) == 0 {
+			return false
+		}
+		for _, ip := range ips {
+			if !whitelist.CanAt(action, ip.String(), port, now) {
+				return false
+			}
+		}
+	}
+	if blacklist != nil {
+		if blacklist.CanAt(action, host, port, now) {
+			return false
+		}
+		for _, ip := range ips {
+			if blacklist.CanAt(action, ip.String(), port, now) {
+				return false
+			}
+		}
+	}
+	return true
 }
@@ -1,8 +1,21 @@
 package gost
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var portRangeTests = []struct {
@@ -150,3 +163,329 @@ func TestPermissionsParse(t *testing.T) {
 		}
 	}
 }
+
+func TestPermissionsJSON(t *testing.T) {
+	for i, s := range []string{"", "tcp:*:80", "tcp,udp:*.example.com,example.org:80,443,1000-2000 rtcp:*:*"} {
+		ps, err := ParsePermissions(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Replace(s, "*:*", "*:0-65535", -1)
+		if ps.String() != want {
+			t.Errorf("#%d test failed: got %s, want %s", i, ps.String(), want)
+		}
+
+		b, err := json.Marshal(&Principal{Whitelist: ps})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p Principal
+		if err := json.Unmarshal(b, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Whitelist.String() != want {
+			t.Errorf("#%d test failed: got %s from %s", i, p.Whitelist.String(), b)
+		}
+	}
+
+	var p Principal
+	if err := json.Unmarshal([]byte(`{"whitelist":"tcp:*"}`), &p); err == nil {
+		t.Error("invalid permissions are decoded")
+	}
+}
+
+func TestPermissionsHosts(t *testing.T) {
+	tests := []struct {
+		perms string
+		addr  string
+		can   bool
+	}{
+		{"tcp:10.0.0.0/8:*", "10.1.2.3:80", true},
+		{"tcp:10.0.0.0/8:*", "11.1.2.3:80", false},
+		{"tcp:10.0.0.0/8:*", "example.com:80", false},
+		{"tcp:fd00::/8:443", "[fd12::1]:443", true},
+		{"tcp:fd00::/8:443", "[fe80::1]:443", false},
+		{"tcp:::1:*", "[::1]:80", true},
+		{"tcp:192.168.1.10-192.168.1.20,localhost:*", "192.168.1.15:80", true},
+		{"tcp:192.168.1.10-192.168.1.20,localhost:*", "192.168.1.21:80", false},
+		{"tcp:192.168.1.10-192.168.1.20,localhost:*", "localhost:80", true},
+		{"tcp:192.168.1.10-192.168.1.20:*", "[::ffff:c0a8:10f]:80", true},
+		{"tcp:re:^(www|api)\\.example\\.com$:*", "api.example.com:80", true},
+		{"tcp:re:^(www|api)\\.example\\.com$:*", "ftp.example.com:80", false},
+		{"tcp:example.org,re:^[a-z]{1,3}\\.example\\.com$:*", "www.example.com:80", true},
+		{"tcp:example.org,re:^[a-z]{1,3}\\.example\\.com$:*", "example.org:80", true},
+		{"tcp:example.org,re:^[a-z]{1,3}\\.example\\.com$:*", "abcd.example.com:80", false},
+		{"tcp:my-host.example.com:*", "my-host.example.com:80", true},
+	}
+	for i, tc := range tests {
+		ps, err := ParsePermissions(tc.perms)
+		if err != nil {
+			t.Errorf("#%d test failed: %v", i, err)
+			continue
+		}
+		if Can("tcp", tc.addr, ps, nil) != tc.can {
+			t.Errorf("#%d test failed: %s %s, want %v", i, tc.perms, tc.addr, tc.can)
+		}
+		if s := ps.String(); s != strings.Replace(tc.perms, ":*", ":0-65535", -1) && !strings.HasPrefix(tc.perms, "tcp:::") {
+			t.Errorf("#%d test failed: got %s", i, s)
+		}
+	}
+
+	for _, s := range []string{"tcp:10.0.0.0/33:*", "tcp:re:(:*", "tcp:10.0.0.1-::1:*", "tcp:example.com/24:*"} {
+		if _, err := ParsePermissions(s); err == nil {
+			t.Errorf("invalid permissions %s are parsed", s)
+		}
+	}
+}
+
+func TestCanResolve(t *testing.T) {
+	lookup := func(host string) ([]net.IP, error) {
+		switch host {
+		case "internal.example.com":
+			return []net.IP{net.ParseIP("10.0.0.1")}, nil
+		case "mixed.example.com":
+			return []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("10.0.0.2")}, nil
+		case "public.example.com":
+			return []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("1.0.0.1")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	private, _ := ParsePermissions("tcp:10.0.0.0/8:*")
+	public, _ := ParsePermissions("tcp:1.0.0.0-1.255.255.255:*")
+	named, _ := ParsePermissions("tcp:*.example.com:*")
+
+	tests := []struct {
+		addr                 string
+		whitelist, blacklist *Permissions
+		can                  bool
+	}{
+		{"internal.example.com:80", nil, private, false},
+		{"mixed.example.com:80", nil, private, false},
+		{"public.example.com:80", nil, private, true},
+		{"unknown.example.com:80", nil, private, false},
+		{"unknown.example.com:80", nil, named, false},
+		{"1.1.1.1:80", nil, private, true},
+		{"public.example.com:80", public, nil, true},
+		{"mixed.example.com:80", public, nil, false},
+		{"unknown.example.com:80", public, nil, false},
+		{"unknown.example.com:80", named, private, false},
+		{"unknown.example.org:80", named, nil, false},
+	}
+	for i, tc := range tests {
+		if CanResolve("tcp", tc.addr, tc.whitelist, tc.blacklist, lookup) != tc.can {
+			t.Errorf("#%d test failed: %s, want %v", i, tc.addr, tc.can)
+		}
+	}
+
+	// the hostnames are not resolved without lookup.
+	if !Can("tcp", "internal.example.com:80", nil, private) {
+		t.Error("the hostname is resolved without lookup")
+	}
+}
+
+type staticResolver map[string][]net.IP
+
+func (r staticResolver) Init(opts ...ResolverOption) error { return nil }
+
+func (r staticResolver) Resolve(host string) ([]net.IP, error) {
+	if ips, ok := r[host]; ok {
+		return ips, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (r staticResolver) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestHTTPProxyResolvePermissions(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+	targetURL := strings.Replace(httpSrv.URL, "127.0.0.1", "localhost", 1)
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	blacklist, _ := ParsePermissions("tcp:127.0.0.0/8:*")
+	tests := []struct {
+		resolver Resolver
+		ok       bool
+	}{
+		{nil, true},
+		{staticResolver{"localhost": {net.ParseIP("127.0.0.1")}}, false},
+		{staticResolver{}, false},
+	}
+	for i, tc := range tests {
+		ln, err := TCPListener("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &Client{
+			Connector:   HTTPConnector(nil),
+			Transporter: TCPTransporter(),
+		}
+		server := &Server{
+			Listener: ln,
+			Handler: HTTPHandler(
+				BlacklistHandlerOption(blacklist),
+				ResolverHandlerOption(tc.resolver),
+			),
+		}
+		go server.Run()
+		err = proxyRoundtrip(client, server, targetURL, sendData)
+		server.Close()
+		if (err == nil) != tc.ok {
+			t.Errorf("#%d test failed: %v", i, err)
+		}
+	}
+}
+
+// rebindResolver resolves the host to the first IP once, then to the second IP,
+// it fails after the first time if the second IP is nil.
+type rebindResolver struct {
+	ips   [2]net.IP
+	count int32
+}
+
+func (r *rebindResolver) Init(opts ...ResolverOption) error { return nil }
+
+func (r *rebindResolver) Resolve(host string) ([]net.IP, error) {
+	if atomic.AddInt32(&r.count, 1) == 1 {
+		return []net.IP{r.ips[0]}, nil
+	}
+	if r.ips[1] == nil {
+		return nil, errors.New("no such host")
+	}
+	return []net.IP{r.ips[1]}, nil
+}
+
+func (r *rebindResolver) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestHTTPProxyResolveOnce(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+	targetURL := strings.Replace(httpSrv.URL, "127.0.0.1", "rebind.example.com", 1)
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	blacklist, _ := ParsePermissions("tcp:192.0.2.0/24:*")
+	tests := []struct {
+		user *url.Userinfo
+		ok   bool
+		n    int32 // the expected times of resolving
+	}{
+		{url.UserPassword("admin", "123456"), true, 1},
+		{nil, false, 0}, // not authenticated
+	}
+	for i, tc := range tests {
+		resolver := &rebindResolver{ips: [2]net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("192.0.2.1")}}
+		ln, err := TCPListener("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &Client{
+			Connector:   HTTPConnector(tc.user),
+			Transporter: TCPTransporter(),
+		}
+		server := &Server{
+			Listener: ln,
+			Handler: HTTPHandler(
+				UsersHandlerOption(url.UserPassword("admin", "123456")),
+				BlacklistHandlerOption(blacklist),
+				ResolverHandlerOption(resolver),
+				TimeoutHandlerOption(time.Second),
+			),
+		}
+		go server.Run()
+		err = proxyRoundtrip(client, server, targetURL, sendData)
+		server.Close()
+		if (err == nil) != tc.ok {
+			t.Errorf("#%d test failed: %v", i, err)
+		}
+		if n := atomic.LoadInt32(&resolver.count); n != tc.n {
+			t.Errorf("#%d test failed: resolved %d times, want %d", i, n, tc.n)
+		}
+	}
+}
+
+func TestHTTPProxyResolveChain(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+	targetURL := strings.Replace(httpSrv.URL, "127.0.0.1", "rebind.example.com", 1)
+
+	// the upstream proxy records the target and connects to the test server.
+	upLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upLn.Close()
+	targets := make(chan string, 1)
+	go func() {
+		conn, err := upLn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		targets <- req.Host
+		cc, err := net.Dial("tcp", httpSrv.Listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer cc.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(cc, br)
+		io.Copy(conn, cc)
+	}()
+
+	// the host is resolved for the check only, the later resolving fails.
+	resolver := &rebindResolver{ips: [2]net.IP{net.ParseIP("127.0.0.1"), nil}}
+	blacklist, _ := ParsePermissions("tcp:192.0.2.0/24:*")
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{
+		Connector:   HTTPConnector(nil),
+		Transporter: TCPTransporter(),
+	}
+	server := &Server{
+		Listener: ln,
+		Handler: HTTPHandler(
+			ChainHandlerOption(NewChain(Node{
+				ID:       1,
+				Protocol: "http",
+				Addr:     upLn.Addr().String(),
+				Client: &Client{
+					Connector:   HTTPConnector(nil),
+					Transporter: TCPTransporter(),
+				},
+			})),
+			BlacklistHandlerOption(blacklist),
+			ResolverHandlerOption(resolver),
+			TimeoutHandlerOption(time.Second),
+		),
+	}
+	go server.Run()
+	defer server.Close()
+
+	if err := proxyRoundtrip(client, server, targetURL, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(targetURL)
+	select {
+	case target := <-targets:
+		if target != u.Host {
+			t.Errorf("the chain is given %s, want %s", target, u.Host)
+		}
+	case <-time.After(time.Second):
+		t.Error("no request to the upstream proxy")
+	}
+}
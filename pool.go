@@ -0,0 +1,210 @@
+package gost
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultPoolIdleTimeout is the default max idle time of the pooled connections.
+	DefaultPoolIdleTimeout = 30 * time.Second
+)
+
+// ConnPool is a pool of the handshaked connections to a node.
+// It keeps at most Size connections warm and replenishes them in the background,
+// so the chain can skip the dial and handshake to its first node.
+// A pooled connection is dropped if it stays idle longer than IdleTimeout.
+type ConnPool struct {
+	Size        int
+	IdleTimeout time.Duration
+	conns       []pooledConn
+	notify      chan struct{}
+	stopped     chan struct{}
+	startOnce   sync.Once
+	stopOnce    sync.Once
+	mux         sync.Mutex
+}
+
+type pooledConn struct {
+	net.Conn
+	time time.Time
+}
+
+// NewConnPool creates a connection pool with the pool size and idle timeout.
+func NewConnPool(size int, idleTimeout time.Duration) *ConnPool {
+	return &ConnPool{
+		Size:        size,
+		IdleTimeout: idleTimeout,
+		notify:      make(chan struct{}, 1),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// Start starts warming up the connections to the node in the background.
+// It is called automatically when the first connection is taken from the pool.
+func (p *ConnPool) Start(node Node) {
+	if p == nil || p.Size <= 0 || node.Client == nil || node.Client.Transporter.Multiplex() {
+		return
+	}
+	p.startOnce.Do(func() {
+		go p.fill(node)
+	})
+}
+
+// get takes a warm connection to the node from the pool, nil is returned if there is none.
+// The connections expired or closed by the peer while idle are dropped.
+func (p *ConnPool) get(node Node) net.Conn {
+	if p == nil || p.Size <= 0 {
+		return nil
+	}
+	p.Start(node)
+
+	var conn net.Conn
+	for conn == nil {
+		p.mux.Lock()
+		if len(p.conns) == 0 {
+			p.mux.Unlock()
+			break
+		}
+		pc := p.conns[0]
+		p.conns = p.conns[1:]
+		p.mux.Unlock()
+
+		if p.expired(pc) || !alive(pc.Conn) {
+			pc.Close()
+			continue
+		}
+		conn = pc.Conn
+	}
+
+	// replenish the pool
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+
+	return conn
+}
+
+func (p *ConnPool) fill(node Node) {
+	ticker := time.NewTicker(p.idleTimeout() / 2)
+	defer ticker.Stop()
+
+	for {
+		p.evict()
+		for p.Len() < p.Size {
+			conn, err := node.handshake()
+			if err != nil {
+				break // wait for the next round
+			}
+			if !p.put(conn) {
+				conn.Close()
+				return
+			}
+		}
+
+		select {
+		case <-p.notify:
+		case <-ticker.C:
+		case <-p.stopped:
+			return
+		}
+	}
+}
+
+func (p *ConnPool) put(conn net.Conn) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.Stopped() {
+		return false
+	}
+	p.conns = append(p.conns, pooledConn{Conn: conn, time: time.Now()})
+	return true
+}
+
+// evict drops the expired connections.
+func (p *ConnPool) evict() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var conns []pooledConn
+	for _, pc := range p.conns {
+		if p.expired(pc) {
+			pc.Close()
+			continue
+		}
+		conns = append(conns, pc)
+	}
+	p.conns = conns
+}
+
+// alive probes the idle connection conn by a read with a short deadline,
+// it is alive if the read times out, as the peer has neither closed it nor sent any data.
+func alive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{})
+
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return true
+	}
+	return false
+}
+
+func (p *ConnPool) expired(pc pooledConn) bool {
+	return time.Since(pc.time) >= p.idleTimeout()
+}
+
+func (p *ConnPool) idleTimeout() time.Duration {
+	if p.IdleTimeout <= 0 {
+		return DefaultPoolIdleTimeout
+	}
+	return p.IdleTimeout
+}
+
+// Len returns the number of the warm connections in the pool.
+func (p *ConnPool) Len() int {
+	if p == nil {
+		return 0
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return len(p.conns)
+}
+
+// Stop stops the pool and closes the pooled connections.
+func (p *ConnPool) Stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() {
+		p.mux.Lock()
+		defer p.mux.Unlock()
+
+		close(p.stopped)
+		for _, pc := range p.conns {
+			pc.Close()
+		}
+		p.conns = nil
+	})
+}
+
+// Stopped checks whether the pool is stopped.
+func (p *ConnPool) Stopped() bool {
+	if p == nil {
+		return true
+	}
+	select {
+	case <-p.stopped:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,248 @@
+package gost
+
+import (
+	"net"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countListener struct {
+	Listener
+	accepted int32
+}
+
+func (l *countListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestConnPool(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	tlsLn, err := TLSListener("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &countListener{Listener: tlsLn}
+	server := &Server{
+		Listener: ln,
+		Handler:  SOCKS5Handler(),
+	}
+	go server.Run()
+	defer server.Close()
+
+	pool := NewConnPool(2, time.Second)
+	node := Node{
+		ID:   1,
+		Addr: ln.Addr().String(),
+		Client: &Client{
+			Connector:   SOCKS5Connector(nil),
+			Transporter: TLSTransporter(),
+		},
+		marker: &failMarker{},
+		Pool:   pool,
+	}
+	pool.Start(node)
+	if !waitFor(func() bool { return pool.Len() == 2 }) {
+		t.Fatalf("pool is not warmed up, %d connections", pool.Len())
+	}
+
+	conn, err := NewChain(node).Dial(httpSrv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := httpRoundtrip(conn, httpSrv.URL, []byte("ping")); err != nil {
+		t.Error(err)
+	}
+	conn.Close()
+
+	if !waitFor(func() bool { return pool.Len() == 2 }) {
+		t.Errorf("pool is not replenished, %d connections", pool.Len())
+	}
+	if n := atomic.LoadInt32(&ln.accepted); n != 3 {
+		t.Errorf("server accepted %d connections, want 3", n)
+	}
+
+	pool.Stop()
+	if !pool.Stopped() || pool.Len() != 0 {
+		t.Errorf("pool is not stopped, %d connections", pool.Len())
+	}
+	if conn := pool.get(node); conn != nil {
+		t.Error("stopped pool should be empty")
+	}
+}
+
+func TestConnPoolIdle(t *testing.T) {
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cln := &countListener{Listener: ln}
+	go func() {
+		for {
+			conn, err := cln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	defer ln.Close()
+
+	pool := NewConnPool(1, 50*time.Millisecond)
+	defer pool.Stop()
+	pool.Start(Node{
+		Addr:   ln.Addr().String(),
+		Client: &Client{Connector: SOCKS5Connector(nil), Transporter: TCPTransporter()},
+	})
+
+	// the idle connections are dropped and replaced
+	if !waitFor(func() bool { return atomic.LoadInt32(&cln.accepted) >= 3 }) {
+		t.Errorf("idle connections are not replaced, accepted %d", atomic.LoadInt32(&cln.accepted))
+	}
+	if pool.Len() > 1 {
+		t.Errorf("pool has %d connections", pool.Len())
+	}
+}
+
+// connsListener keeps the accepted connections, so they can be closed by the server side.
+type connsListener struct {
+	Listener
+	conns []net.Conn
+	mux   sync.Mutex
+}
+
+func (l *connsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mux.Lock()
+		l.conns = append(l.conns, conn)
+		l.mux.Unlock()
+	}
+	return conn, err
+}
+
+func (l *connsListener) closeConns() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
+
+func TestConnPoolStale(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	tcpLn, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &connsListener{Listener: tcpLn}
+	server := &Server{
+		Listener: ln,
+		Handler:  SOCKS5Handler(),
+	}
+	go server.Run()
+	defer server.Close()
+
+	pool := NewConnPool(1, time.Minute)
+	defer pool.Stop()
+	node := Node{
+		ID:   1,
+		Addr: ln.Addr().String(),
+		Client: &Client{
+			Connector:   SOCKS5Connector(nil),
+			Transporter: TCPTransporter(),
+		},
+		marker: &failMarker{},
+		Pool:   pool,
+	}
+	pool.Start(node)
+	if !waitFor(func() bool { return pool.Len() == 1 }) {
+		t.Fatalf("pool is not warmed up, %d connections", pool.Len())
+	}
+
+	// the pooled connection is closed by the server while idle
+	ln.closeConns()
+
+	conn, err := NewChain(node).Dial(httpSrv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := httpRoundtrip(conn, httpSrv.URL, []byte("ping")); err != nil {
+		t.Error(err)
+	}
+	if n := node.marker.FailCount(); n != 0 {
+		t.Errorf("node is marked dead, fail count %d", n)
+	}
+}
+
+func TestConnPoolStaleConn(t *testing.T) {
+	tcpLn, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &connsListener{Listener: tcpLn}
+	go func() {
+		for {
+			if _, err := ln.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	defer ln.Close()
+
+	pool := NewConnPool(1, time.Minute)
+	defer pool.Stop()
+	node := Node{
+		ID:   1,
+		Addr: ln.Addr().String(),
+		Client: &Client{
+			Connector:   HTTPConnector(nil),
+			Transporter: TCPTransporter(),
+		},
+		marker: &failMarker{},
+		Pool:   pool,
+	}
+	pool.Start(node)
+	if !waitFor(func() bool {
+		ln.mux.Lock()
+		defer ln.mux.Unlock()
+		return pool.Len() == 1 && len(ln.conns) == 1
+	}) {
+		t.Fatalf("pool is not warmed up, %d connections", pool.Len())
+	}
+
+	// the single node chain returns the pooled connection as is, without any connect on it.
+	ln.closeConns()
+
+	conn, err := NewChain(node).Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !alive(conn) {
+		t.Error("the stale pooled connection is returned")
+	}
+}
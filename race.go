@@ -0,0 +1,119 @@
+package gost
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+var (
+	// DefaultRaceDelay is the default delay between the racing connection attempts,
+	// as recommended by RFC 8305.
+	DefaultRaceDelay = 250 * time.Millisecond
+)
+
+type raceResult struct {
+	conn  net.Conn
+	index int
+	err   error
+}
+
+// raceDial races n connection attempts in the Happy Eyeballs (RFC 8305) style.
+// The attempt i+1 is started when the attempt i fails or has not finished in the delay.
+// The first succeeded connection and its index are returned, the other connections are closed.
+func raceDial(ctx context.Context, n int, delay time.Duration, dial func(i int) (net.Conn, error)) (net.Conn, int, error) {
+	if delay <= 0 {
+		delay = DefaultRaceDelay
+	}
+
+	results := make(chan raceResult, n)
+	started, pending := 0, 0
+	start := func() {
+		i := started
+		started++
+		pending++
+		go func() {
+			conn, err := dial(i)
+			results <- raceResult{conn: conn, index: i, err: err}
+		}()
+	}
+	// closeLosers closes the connections of the pending attempts when they are finished.
+	closeLosers := func() {
+		go func(pending int) {
+			for ; pending > 0; pending-- {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}(pending)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	resetTimer := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(delay)
+	}
+
+	start()
+
+	var err error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				closeLosers()
+				return r.conn, r.index, nil
+			}
+			err = r.err
+			if started < n {
+				start()
+				resetTimer()
+			}
+		case <-timer.C:
+			if started < n {
+				start()
+				timer.Reset(delay)
+			}
+		case <-ctx.Done():
+			closeLosers()
+			return nil, -1, ctx.Err()
+		}
+	}
+	return nil, -1, err
+}
+
+// interleaveAddrs reorders the addresses to alternate between the IPv6 and IPv4 families,
+// starting with the family of the first address.
+func interleaveAddrs(addrs []string) []string {
+	var first, second []string
+	isIPv4 := func(addr string) bool {
+		host, _, _ := net.SplitHostPort(addr)
+		ip := net.ParseIP(host)
+		return ip != nil && ip.To4() != nil
+	}
+	for _, addr := range addrs {
+		if isIPv4(addr) == isIPv4(addrs[0]) {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+
+	nl := make([]string, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			nl = append(nl, first[i])
+		}
+		if i < len(second) {
+			nl = append(nl, second[i])
+		}
+	}
+	return nl
+}
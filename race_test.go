@@ -0,0 +1,154 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type raceTestConn struct {
+	net.Conn
+	closed chan int
+	i      int
+}
+
+func (c *raceTestConn) Close() error {
+	c.closed <- c.i
+	return nil
+}
+
+func TestRaceDial(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		delays []time.Duration // negative delay means failure
+		winner int
+		closed []int
+	}{
+		{[]time.Duration{0, 0}, 0, nil},
+		{[]time.Duration{100 * time.Millisecond, 0}, 1, []int{0}},
+		{[]time.Duration{-1, 100 * time.Millisecond, 0}, 2, []int{1}},
+		{[]time.Duration{-1, -1}, -1, nil},
+	}
+
+	for i, tc := range tests {
+		closed := make(chan int, len(tc.delays))
+		conn, winner, err := raceDial(context.Background(), len(tc.delays), 10*time.Millisecond, func(i int) (net.Conn, error) {
+			if tc.delays[i] < 0 {
+				return nil, errFailed
+			}
+			time.Sleep(tc.delays[i])
+			return &raceTestConn{closed: closed, i: i}, nil
+		})
+		if winner != tc.winner {
+			t.Errorf("#%d test failed: winner %d, want %d", i, winner, tc.winner)
+		}
+		if tc.winner < 0 {
+			if err != errFailed {
+				t.Errorf("#%d test failed: got error %v", i, err)
+			}
+			continue
+		}
+		if conn == nil || err != nil {
+			t.Errorf("#%d test failed: got conn %v, error %v", i, conn, err)
+			continue
+		}
+
+		var losers []int
+		for range tc.closed {
+			select {
+			case n := <-closed:
+				losers = append(losers, n)
+			case <-time.After(time.Second):
+			}
+		}
+		if !reflect.DeepEqual(losers, tc.closed) {
+			t.Errorf("#%d test failed: closed %v, want %v", i, losers, tc.closed)
+		}
+	}
+}
+
+func TestInterleaveAddrs(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		want  []string
+	}{
+		{[]string{"1.1.1.1:80"}, []string{"1.1.1.1:80"}},
+		{[]string{"[::1]:80", "[::2]:80", "1.1.1.1:80"}, []string{"[::1]:80", "1.1.1.1:80", "[::2]:80"}},
+		{[]string{"1.1.1.1:80", "2.2.2.2:80", "[::1]:80", "[::2]:80"}, []string{"1.1.1.1:80", "[::1]:80", "2.2.2.2:80", "[::2]:80"}},
+	}
+	for i, tc := range tests {
+		if addrs := interleaveAddrs(tc.addrs); !reflect.DeepEqual(addrs, tc.want) {
+			t.Errorf("#%d test failed: got %v, want %v", i, addrs, tc.want)
+		}
+	}
+}
+
+func TestChainRace(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	ln, err := TLSListener("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{
+		Listener: ln,
+		Handler:  SOCKS5Handler(),
+	}
+	go server.Run()
+	defer server.Close()
+
+	// a node that never finishes the TLS handshake
+	blackhole, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blackhole.Close()
+	go func() {
+		for {
+			conn, err := blackhole.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := &Client{
+		Connector:   SOCKS5Connector(nil),
+		Transporter: TLSTransporter(),
+	}
+	newNode := func(id int, addr string) Node {
+		return Node{ID: id, Addr: addr, Client: client, marker: &failMarker{}}
+	}
+	group := NewNodeGroup(newNode(1, blackhole.Addr().String()), newNode(2, ln.Addr().String()))
+	group.SetSelector(nil, WithStrategy(&FIFOStrategy{}))
+	chain := NewChain()
+	chain.AddNodeGroup(group)
+
+	route, err := chain.selectRouteFor(httpSrv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	conn, err := route.Dial(httpSrv.Listener.Addr().String(), RaceChainOption(2, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("racing takes %v", d)
+	}
+	if route.route[0].ID != 2 {
+		t.Errorf("wrong node %d is selected", route.route[0].ID)
+	}
+	if err := httpRoundtrip(conn, httpSrv.URL, []byte("ping")); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,6 +1,7 @@
 package gost
 
 import (
+	"errors"
 	"io"
 	"os"
 	"time"
@@ -20,12 +21,88 @@ type Stoppable interface {
 	Stopped() bool
 }
 
-// PeriodReload reloads the config configFile periodically according to the period of the Reloader r.
+var (
+	errWatchNotSupported = errors.New("file watching is not supported")
+
+	// reloadDebounce is the quiet time after the last file event before reloading.
+	reloadDebounce = 100 * time.Millisecond
+)
+
+// fileWatcher watches the changes of a file by the file system events.
+type fileWatcher interface {
+	// Wait waits for the changes of the file until timeout, false is returned on timeout.
+	Wait(timeout time.Duration) (bool, error)
+	Close() error
+}
+
+// PeriodReload reloads the config configFile when it is changed.
+// The file is watched by the file system events if possible, such as inotify on Linux,
+// then it is reloaded on change without waiting for the reload period.
+// Otherwise it is polled periodically according to the period of the Reloader r.
+// The reloading is disabled if the period is zero.
 func PeriodReload(r Reloader, configFile string) error {
 	if r == nil || configFile == "" {
 		return nil
 	}
+	if r.Period() == 0 {
+		log.Log("[reload] disabled:", configFile)
+		return nil
+	}
+
+	w, err := newFileWatcher(configFile)
+	if err != nil {
+		if err != errWatchNotSupported {
+			log.Logf("[reload] watch %s: %v, fall back to polling", configFile, err)
+		}
+		return pollReload(r, configFile)
+	}
+	defer w.Close()
+
+	for {
+		switch period := r.Period(); {
+		case period < 0:
+			log.Log("[reload] stopped:", configFile)
+			return nil
+		case period == 0:
+			log.Log("[reload] disabled:", configFile)
+			return nil
+		}
+
+		changed, err := w.Wait(time.Second)
+		// debounce the bursts of the events, such as the truncate and write of an editor.
+		for changed && err == nil {
+			changed, err = w.Wait(reloadDebounce)
+			if !changed {
+				reloadFile(r, configFile)
+			}
+		}
+		if err != nil {
+			log.Logf("[reload] watch %s: %v, fall back to polling", configFile, err)
+			return pollReload(r, configFile)
+		}
+	}
+}
+
+func reloadFile(r Reloader, configFile string) {
+	if r.Period() < 0 {
+		return
+	}
+
+	f, err := os.Open(configFile)
+	if err != nil {
+		log.Logf("[reload] %s: %s", configFile, err)
+		return
+	}
+	defer f.Close()
+
+	log.Log("[reload]", configFile)
+	if err := r.Reload(f); err != nil {
+		log.Logf("[reload] %s: %s", configFile, err)
+	}
+}
 
+// pollReload reloads the config configFile periodically according to the period of the Reloader r.
+func pollReload(r Reloader, configFile string) error {
 	var lastMod time.Time
 	for {
 		if r.Period() < 0 {
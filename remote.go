@@ -0,0 +1,305 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-log/log"
+)
+
+var (
+	// DefaultRemotePeriod is the default polling period of the remote sources without the reload period.
+	DefaultRemotePeriod = 5 * time.Minute
+	// DefaultRemoteTimeout is the default timeout of fetching the remote sources.
+	DefaultRemoteTimeout = 30 * time.Second
+
+	// ErrChecksumMismatch is an error that implies the content of the remote source does not match the checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrInvalidSignature is an error that implies the signature of the remote source is invalid.
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// RemoteSource is a config file served over HTTP(S), such as a bypass list or a peer list.
+// It is polled with the conditional requests by ETag and If-Modified-Since,
+// the content is verified by the SHA-256 checksum or the ed25519 signature published at URL.sig,
+// and is saved to the cache file for the cold starts.
+type RemoteSource struct {
+	URL       string
+	Chain     *Chain // fetch through the chain, nil for direct
+	CacheFile string
+	Checksum  []byte            // the expected SHA-256 digest of the content
+	PublicKey ed25519.PublicKey // the key to verify the signature
+	Timeout   time.Duration
+
+	etag         string
+	lastModified string
+	client       *http.Client
+	once         sync.Once
+	mux          sync.Mutex
+}
+
+// IsRemoteSource checks whether s is the URL of a remote source.
+func IsRemoteSource(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// String returns the URL of the source with the credentials redacted.
+func (s *RemoteSource) String() string {
+	return Redact(s.URL)
+}
+
+func (s *RemoteSource) httpClient() *http.Client {
+	s.once.Do(func() {
+		chain := s.Chain
+		s.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return chain.DialContext(ctx, network, addr)
+				},
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			Timeout: s.timeout(),
+		}
+	})
+	return s.client
+}
+
+func (s *RemoteSource) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultRemoteTimeout
+	}
+	return s.Timeout
+}
+
+// Fetch fetches the content of the source, nil data is returned if it is not modified since the last fetch.
+// The verified content is saved to the cache file, along with its signature.
+func (s *RemoteSource) Fetch() ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, sig, err := s.fetch(true)
+	if err == ErrInvalidSignature {
+		// the content may be updated between fetching it and its signature, try again.
+		data, sig, err = s.fetch(false)
+	}
+	if data == nil || err != nil {
+		return nil, err
+	}
+
+	if s.CacheFile != "" {
+		if err := ioutil.WriteFile(s.CacheFile, data, 0644); err != nil {
+			log.Logf("[remote] %s: cache: %v", s, err)
+		}
+		if sig != nil {
+			if err := ioutil.WriteFile(s.CacheFile+".sig", sig, 0644); err != nil {
+				log.Logf("[remote] %s: cache: %v", s, err)
+			}
+		}
+	}
+	return data, nil
+}
+
+// fetch fetches and verifies the content and its signature,
+// the request is conditional on the last fetch if conditional is true.
+func (s *RemoteSource) fetch(conditional bool) (data, sig []byte, err error) {
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, nil, redactError(err)
+	}
+	if conditional && s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if conditional && s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, redactError(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil, nil
+	case http.StatusOK:
+	default:
+		return nil, nil, fmt.Errorf("%s: %s", s, resp.Status)
+	}
+
+	if data, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, nil, err
+	}
+	if len(s.PublicKey) > 0 {
+		if sig, err = s.signature(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := s.verify(data, sig); err != nil {
+		return nil, nil, err
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	return data, sig, nil
+}
+
+// LoadCache loads the verified content from the cache file,
+// the signature is loaded from the cache file with the .sig suffix.
+func (s *RemoteSource) LoadCache() ([]byte, error) {
+	if s.CacheFile == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(s.CacheFile)
+	if err != nil {
+		return nil, err
+	}
+	var sig []byte
+	if len(s.PublicKey) > 0 {
+		if sig, err = ioutil.ReadFile(s.CacheFile + ".sig"); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.verify(data, sig); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *RemoteSource) verify(data, sig []byte) error {
+	if len(s.Checksum) > 0 {
+		sum := sha256.Sum256(data)
+		if !bytes.Equal(sum[:], s.Checksum) {
+			return ErrChecksumMismatch
+		}
+	}
+	if len(s.PublicKey) > 0 && !ed25519.Verify(s.PublicKey, data, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// signature fetches the signature of the content from URL.sig, in raw or base64.
+// The suffix is appended to the path, the query of the URL is kept.
+func (s *RemoteSource) signature() ([]byte, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	u.Path += ".sig"
+	if u.RawPath != "" {
+		u.RawPath += ".sig"
+	}
+
+	resp, err := s.httpClient().Get(u.String())
+	if err != nil {
+		return nil, redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", Redact(u.String()), resp.Status)
+	}
+	sig, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) == ed25519.SignatureSize {
+		return sig, nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+}
+
+// ParseChecksum parses the hex encoded SHA-256 checksum.
+func ParseChecksum(s string) ([]byte, error) {
+	sum, err := hex.DecodeString(strings.TrimPrefix(s, "sha256:"))
+	if err != nil {
+		return nil, err
+	}
+	if len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid checksum %s", s)
+	}
+	return sum, nil
+}
+
+// ParsePublicKey parses the base64 encoded ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key %s", s)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Load loads the Reloader r from the source, the cache file is used if the source is unavailable.
+func (s *RemoteSource) Load(r Reloader) error {
+	data, err := s.Fetch()
+	if err != nil {
+		log.Logf("[remote] %s: %v", s, err)
+		var cerr error
+		if data, cerr = s.LoadCache(); cerr != nil {
+			return err
+		}
+		log.Logf("[remote] %s: load the cache %s", s, s.CacheFile)
+	}
+	if data == nil {
+		return nil
+	}
+	return r.Reload(bytes.NewReader(data))
+}
+
+// RemoteReload reloads the Reloader r from the remote source periodically according to the period of r,
+// DefaultRemotePeriod is used if r has no reload period.
+func RemoteReload(r Reloader, s *RemoteSource) error {
+	if r == nil || s == nil {
+		return nil
+	}
+
+	for {
+		period := r.Period()
+		if period < 0 {
+			log.Log("[reload] stopped:", s)
+			return nil
+		}
+		if period == 0 {
+			period = DefaultRemotePeriod
+		}
+		if period < time.Second {
+			period = time.Second
+		}
+		<-time.After(period)
+
+		if r.Period() < 0 {
+			continue
+		}
+		data, err := s.Fetch()
+		if err != nil {
+			log.Logf("[reload] %s: %v", s, err)
+			continue
+		}
+		if data == nil {
+			continue // not modified
+		}
+		log.Log("[reload]", s)
+		if err := r.Reload(bytes.NewReader(data)); err != nil {
+			log.Logf("[reload] %s: %v", s, err)
+		}
+	}
+}
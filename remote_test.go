@@ -0,0 +1,228 @@
+package gost
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// remoteTestServer serves the content with ETag and its signature at .sig.
+type remoteTestServer struct {
+	content  string
+	etag     string
+	sig      []byte
+	requests int
+	notMod   int
+	mux      sync.Mutex
+}
+
+func (s *remoteTestServer) set(content, etag string, sig []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.content, s.etag, s.sig = content, etag, sig
+}
+
+func (s *remoteTestServer) counts() (int, int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.requests, s.notMod
+}
+
+func (s *remoteTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if filepath.Ext(r.URL.Path) == ".sig" {
+		w.Write([]byte(base64.StdEncoding.EncodeToString(s.sig)))
+		return
+	}
+	s.requests++
+	if s.etag != "" && r.Header.Get("If-None-Match") == s.etag {
+		s.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", s.etag)
+	w.Write([]byte(s.content))
+}
+
+func TestRemoteSourceFetch(t *testing.T) {
+	ts := &remoteTestServer{}
+	ts.set("example.com\n", `"v1"`, nil)
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "gost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := &RemoteSource{
+		URL:       srv.URL + "/bypass.txt",
+		CacheFile: filepath.Join(dir, "bypass.txt"),
+	}
+	data, err := src.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "example.com\n" {
+		t.Errorf("fetched %q", data)
+	}
+
+	// not modified
+	data, err = src.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != nil {
+		t.Errorf("not modified source is fetched: %q", data)
+	}
+	if _, notMod := ts.counts(); notMod != 1 {
+		t.Errorf("got %d not modified responses, want 1", notMod)
+	}
+
+	// cold start from the cache file
+	srv.Close()
+	bp := NewBypass(false)
+	src = &RemoteSource{
+		URL:       srv.URL + "/bypass.txt",
+		CacheFile: filepath.Join(dir, "bypass.txt"),
+		Timeout:   time.Second,
+	}
+	if err := src.Load(bp); err != nil {
+		t.Fatal(err)
+	}
+	if !bp.Contains("example.com") {
+		t.Error("the cache file is not loaded")
+	}
+
+	src.CacheFile = filepath.Join(dir, "none")
+	if err := src.Load(bp); err == nil {
+		t.Error("unavailable source without cache should fail")
+	}
+}
+
+func TestRemoteSourceVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "example.com\n"
+	sum := sha256.Sum256([]byte(content))
+
+	ts := &remoteTestServer{}
+	ts.set(content, "", ed25519.Sign(priv, []byte(content)))
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	tests := []struct {
+		checksum  []byte
+		publicKey ed25519.PublicKey
+		err       error
+	}{
+		{checksum: sum[:]},
+		{checksum: make([]byte, sha256.Size), err: ErrChecksumMismatch},
+		{publicKey: pub},
+		{checksum: sum[:], publicKey: pub},
+	}
+	for i, tc := range tests {
+		src := &RemoteSource{
+			URL:       srv.URL + "/hosts",
+			Checksum:  tc.checksum,
+			PublicKey: tc.publicKey,
+		}
+		if _, err := src.Fetch(); err != tc.err {
+			t.Errorf("#%d test failed: got error %v, want %v", i, err, tc.err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "gost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the signature is fetched from the path with the .sig suffix, the query is kept,
+	// and is cached along with the content.
+	src := &RemoteSource{
+		URL:       srv.URL + "/hosts?v=1",
+		PublicKey: pub,
+		CacheFile: filepath.Join(dir, "hosts"),
+	}
+	if _, err := src.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.LoadCache(); err != nil {
+		t.Errorf("cache: %v", err)
+	}
+
+	// the content is tampered
+	ts.set("evil.com\n", "", ed25519.Sign(priv, []byte(content)))
+	src = &RemoteSource{URL: srv.URL + "/hosts", PublicKey: pub}
+	if _, err := src.Fetch(); err != ErrInvalidSignature {
+		t.Errorf("got error %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("gost"))
+	s := "sha256:" + base64.StdEncoding.EncodeToString(sum[:])
+	if _, err := ParseChecksum(s); err == nil {
+		t.Errorf("invalid checksum %s is parsed", s)
+	}
+	if _, err := ParseChecksum("abcd"); err == nil {
+		t.Error("short checksum is parsed")
+	}
+	if _, err := ParsePublicKey(base64.StdEncoding.EncodeToString(sum[:])); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoteReload(t *testing.T) {
+	ts := &remoteTestServer{}
+	ts.set("reload 1s\nexample.com\n", `"v1"`, nil)
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	bp := NewBypass(false)
+	src := &RemoteSource{URL: srv.URL + "/bypass.txt"}
+	if err := src.Load(bp); err != nil {
+		t.Fatal(err)
+	}
+	if !bp.Contains("example.com") {
+		t.Fatal("the source is not loaded")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		RemoteReload(bp, src)
+		close(done)
+	}()
+
+	ts.set("reload 1s\nexample.org\n", `"v2"`, nil)
+	reloaded := waitFor(func() bool { return bp.Contains("example.org") }) ||
+		waitFor(func() bool { return bp.Contains("example.org") })
+	if !reloaded {
+		t.Fatal("the modified source is not reloaded")
+	}
+	if bp.Contains("example.com") {
+		t.Error("the old content is kept")
+	}
+
+	bp.Stop()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Error("the reloader is not stopped")
+	}
+}
@@ -16,7 +16,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/go-log/log"
 	"github.com/miekg/dns"
 )
 
@@ -125,6 +124,7 @@ type resolverOptions struct {
 	ttl     time.Duration
 	prefer  string
 	srcIP   net.IP
+	logger  LeveledLogger
 }
 
 // ResolverOption allows a common way to set Resolver options.
@@ -165,6 +165,13 @@ func SrcIPResolverOption(ip net.IP) ResolverOption {
 	}
 }
 
+// LoggerResolverOption sets the leveled logger for Resolver.
+func LoggerResolverOption(logger LeveledLogger) ResolverOption {
+	return func(opts *resolverOptions) {
+		opts.logger = logger
+	}
+}
+
 // Resolver is a name resolver for domain name.
 // It contains a list of name servers.
 type Resolver interface {
@@ -241,6 +248,9 @@ func (r *resolver) Init(opts ...ResolverOption) error {
 	if r.options.srcIP != nil {
 		r.srcIP = r.options.srcIP
 	}
+	if r.options.logger != nil {
+		r.cache.logger = r.options.logger
+	}
 
 	var nss []NameServer
 	for _, ns := range r.servers {
@@ -258,6 +268,13 @@ func (r *resolver) Init(opts ...ResolverOption) error {
 	return nil
 }
 
+func (r *resolver) logger() LeveledLogger {
+	if r.options.logger == nil {
+		return DefaultLeveledLogger
+	}
+	return r.options.logger
+}
+
 func (r *resolver) copyServers() []NameServer {
 	r.mux.RLock()
 	defer r.mux.RUnlock()
@@ -287,13 +304,11 @@ func (r *resolver) Resolve(host string) (ips []net.IP, err error) {
 	for _, ns := range r.copyServers() {
 		ips, err = r.resolve(ctx, ns.exchanger, host)
 		if err != nil {
-			log.Logf("[resolver] %s via %s : %s", host, ns.String(), err)
+			r.logger().Errorf("[resolver] %s via %s : %s", host, ns.String(), err)
 			continue
 		}
 
-		if Debug {
-			log.Logf("[resolver] %s via %s %v", host, ns.String(), ips)
-		}
+		r.logger().Debugf("[resolver] %s via %s %v", host, ns.String(), ips)
 		if len(ips) > 0 {
 			break
 		}
@@ -387,7 +402,7 @@ func (r *resolver) Exchange(ctx context.Context, query []byte) (reply []byte, er
 		key := newResolverCacheKey(&mq.Question[0])
 		mr = r.cache.loadCache(key)
 		if mr != nil {
-			log.Logf("[dns] exchange message %d (cached): %s", mq.Id, mq.Question[0].String())
+			r.logger().Infof("[dns] exchange message %d (cached): %s", mq.Id, mq.Question[0].String())
 			mr.Id = mq.Id
 			return mr.Pack()
 		}
@@ -402,12 +417,12 @@ func (r *resolver) Exchange(ctx context.Context, query []byte) (reply []byte, er
 	r.addSubnetOpt(mq)
 
 	for _, ns := range r.copyServers() {
-		log.Logf("[dns] exchange message %d via %s: %s", mq.Id, ns.String(), mq.Question[0].String())
+		r.logger().Infof("[dns] exchange message %d via %s: %s", mq.Id, ns.String(), mq.Question[0].String())
 		mr, err = r.exchangeMsg(ctx, ns.exchanger, mq)
 		if err == nil {
 			break
 		}
-		log.Logf("[dns] exchange message %d via %s: %s", mq.Id, ns.String(), err)
+		r.logger().Errorf("[dns] exchange message %d via %s: %s", mq.Id, ns.String(), err)
 	}
 	if err != nil {
 		return
@@ -595,14 +610,19 @@ type resolverCacheItem struct {
 }
 
 type resolverCache struct {
-	m sync.Map
+	m      sync.Map
+	logger LeveledLogger
 }
 
 func newResolverCache(ttl time.Duration) *resolverCache {
-	return &resolverCache{}
+	return &resolverCache{logger: DefaultLeveledLogger}
 }
 
-func (rc *resolverCache) loadCache(key resolverCacheKey) *dns.Msg {
+func (rc *resolverCache) loadCache(key resolverCacheKey) (mr *dns.Msg) {
+	defer func() {
+		DefaultMetrics.cacheLookup(mr != nil)
+	}()
+
 	v, ok := rc.m.Load(key)
 	if !ok {
 		return nil
@@ -625,9 +645,7 @@ func (rc *resolverCache) loadCache(key resolverCacheKey) *dns.Msg {
 		}
 	}
 
-	if Debug {
-		log.Logf("[resolver] cache hit %s", key)
-	}
+	rc.logger.Debugf("[resolver] cache hit %s", key)
 
 	return item.mr.Copy()
 }
@@ -642,9 +660,7 @@ func (rc *resolverCache) storeCache(key resolverCacheKey, mr *dns.Msg, ttl time.
 		ts:  time.Now().Unix(),
 		ttl: ttl,
 	})
-	if Debug {
-		log.Logf("[resolver] cache store %s", key)
-	}
+	rc.logger.Debugf("[resolver] cache store %s", key)
 }
 
 // Exchanger is an interface for DNS synchronous query.
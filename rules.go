@@ -0,0 +1,310 @@
+package gost
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRuleRejected is an error that implies the connection is rejected by the routing rules.
+	ErrRuleRejected = errors.New("rejected by rules")
+)
+
+// rule targets
+const (
+	RuleDirect = "DIRECT" // connect to the target directly
+	RuleReject = "REJECT" // reject the connection
+)
+
+// Rule is a routing rule that directs the matched connections to the target.
+type Rule struct {
+	Type   string // DOMAIN, DOMAIN-SUFFIX, DOMAIN-KEYWORD, IP-CIDR, SRC-IP-CIDR, DST-PORT, SRC-PORT, USER or MATCH
+	Value  string
+	Target string // DIRECT, REJECT or the name of a chain
+	match  func(ctx *ruleContext) bool
+}
+
+type ruleContext struct {
+	host    string // host of the target address
+	port    int    // port of the target address
+	srcIP   net.IP
+	srcPort int
+	user    string
+}
+
+// ParseRule parses the rule in the Clash style format TYPE,VALUE,TARGET,
+// the MATCH rule has no value: MATCH,TARGET.
+func ParseRule(s string) (*Rule, error) {
+	var ss []string
+	for _, v := range strings.Split(s, ",") {
+		ss = append(ss, strings.TrimSpace(v))
+	}
+	if len(ss) < 2 {
+		return nil, fmt.Errorf("invalid rule %s", s)
+	}
+
+	rule := &Rule{Type: strings.ToUpper(ss[0])}
+	if rule.Type == "MATCH" {
+		rule.Target = ss[1]
+		rule.match = func(*ruleContext) bool { return true }
+		return rule, nil
+	}
+	if len(ss) < 3 || ss[1] == "" || ss[2] == "" {
+		return nil, fmt.Errorf("invalid rule %s", s)
+	}
+	rule.Value, rule.Target = ss[1], ss[2] // the options such as no-resolve are ignored
+
+	value := strings.ToLower(rule.Value)
+	switch rule.Type {
+	case "DOMAIN":
+		rule.match = func(ctx *ruleContext) bool {
+			return ctx.host == value
+		}
+	case "DOMAIN-SUFFIX":
+		rule.match = func(ctx *ruleContext) bool {
+			return ctx.host == value || strings.HasSuffix(ctx.host, "."+value)
+		}
+	case "DOMAIN-KEYWORD":
+		rule.match = func(ctx *ruleContext) bool {
+			return strings.Contains(ctx.host, value)
+		}
+	case "IP-CIDR", "IP-CIDR6", "SRC-IP-CIDR":
+		_, inet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Type == "SRC-IP-CIDR" {
+			rule.match = func(ctx *ruleContext) bool {
+				return ctx.srcIP != nil && inet.Contains(ctx.srcIP)
+			}
+		} else {
+			// the domain target is not resolved for matching
+			rule.match = func(ctx *ruleContext) bool {
+				ip := net.ParseIP(ctx.host)
+				return ip != nil && inet.Contains(ip)
+			}
+		}
+	case "DST-PORT", "SRC-PORT":
+		min, max, err := parsePortRange(value)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Type == "SRC-PORT" {
+			rule.match = func(ctx *ruleContext) bool {
+				return ctx.srcPort >= min && ctx.srcPort <= max
+			}
+		} else {
+			rule.match = func(ctx *ruleContext) bool {
+				return ctx.port >= min && ctx.port <= max
+			}
+		}
+	case "USER":
+		rule.match = func(ctx *ruleContext) bool {
+			return ctx.user == rule.Value
+		}
+	default:
+		return nil, fmt.Errorf("unknown rule type %s", ss[0])
+	}
+
+	return rule, nil
+}
+
+// parsePortRange parses the port or port range such as 8000-9000.
+func parsePortRange(s string) (min, max int, err error) {
+	ports := strings.SplitN(s, "-", 2)
+	if min, err = strconv.Atoi(ports[0]); err != nil {
+		return
+	}
+	max = min
+	if len(ports) == 2 {
+		if max, err = strconv.Atoi(ports[1]); err != nil {
+			return
+		}
+	}
+	if min < 0 || max > 65535 || min > max {
+		err = fmt.Errorf("invalid port range %s", s)
+	}
+	return
+}
+
+func (r *Rule) String() string {
+	if r.Type == "MATCH" {
+		return r.Type + "," + r.Target
+	}
+	return r.Type + "," + r.Value + "," + r.Target
+}
+
+// Rules is a routing table, it directs the connections to the named chains,
+// directly to the target or rejects them by the first matched rule.
+type Rules struct {
+	chains  map[string]*Chain
+	rules   []*Rule
+	period  time.Duration // the period for live reloading
+	stopped chan struct{}
+	mux     sync.RWMutex
+}
+
+// NewRules creates a routing table with the rules created by ParseRule,
+// the rule targets refer to the chains by name.
+func NewRules(chains map[string]*Chain, rules ...*Rule) *Rules {
+	return &Rules{
+		chains:  chains,
+		rules:   rules,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Match finds the route of the connection described by the selection context ctx.
+// If a rule is matched, its chain is returned, nil chain means connecting directly,
+// and ErrRuleRejected is returned if the connection should be rejected,
+// or the chain is not in the chains allowed for the user by ctx.Chains.
+func (r *Rules) Match(ctx SelectContext) (chain *Chain, matched bool, err error) {
+	if r == nil {
+		return
+	}
+
+	rctx := &ruleContext{
+		host: ctx.Host,
+		user: ctx.User,
+	}
+	if host, port, err := net.SplitHostPort(ctx.Host); err == nil {
+		rctx.host = host
+		rctx.port, _ = strconv.Atoi(port)
+	}
+	rctx.host = strings.ToLower(strings.TrimSuffix(rctx.host, "."))
+	if host, port, err := net.SplitHostPort(ctx.Client); err == nil {
+		rctx.srcIP = net.ParseIP(host)
+		rctx.srcPort, _ = strconv.Atoi(port)
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for _, rule := range r.rules {
+		if !rule.match(rctx) {
+			continue
+		}
+		switch rule.Target {
+		case RuleDirect:
+			return nil, true, nil
+		case RuleReject:
+			return nil, true, ErrRuleRejected
+		default:
+			if !allowChain(ctx.Chains, rule.Target) {
+				return nil, true, ErrRuleRejected
+			}
+			return r.chains[rule.Target], true, nil
+		}
+	}
+	return
+}
+
+func allowChain(chains []string, name string) bool {
+	if len(chains) == 0 {
+		return true
+	}
+	for _, s := range chains {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules returns the routing rules.
+func (r *Rules) Rules() []*Rule {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return r.rules
+}
+
+// Reload parses the rules from r, one rule per line.
+// The targets of the rules must be DIRECT, REJECT or the known chain names.
+func (r *Rules) Reload(rd io.Reader) error {
+	var rules []*Rule
+	var period time.Duration
+
+	if rd == nil || r.Stopped() {
+		return nil
+	}
+
+	n := 0
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "- "))
+		if line == "" {
+			continue
+		}
+		if ss := splitLine(line); ss[0] == "reload" { // reload option
+			if len(ss) > 1 {
+				period, _ = time.ParseDuration(ss[1])
+			}
+			continue
+		}
+
+		rule, err := ParseRule(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		if _, ok := r.chains[rule.Target]; !ok && rule.Target != RuleDirect && rule.Target != RuleReject {
+			return fmt.Errorf("line %d: unknown target %s", n, rule.Target)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.rules = rules
+	r.period = period
+
+	return nil
+}
+
+// Period returns the reload period.
+func (r *Rules) Period() time.Duration {
+	if r.Stopped() {
+		return -1
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return r.period
+}
+
+// Stop stops reloading.
+func (r *Rules) Stop() {
+	select {
+	case <-r.stopped:
+	default:
+		close(r.stopped)
+	}
+}
+
+// Stopped checks whether the reloader is stopped.
+func (r *Rules) Stopped() bool {
+	select {
+	case <-r.stopped:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,127 @@
+package gost
+
+import (
+	"bytes"
+	"testing"
+)
+
+var rulesTestData = `
+# routing rules
+reload 10s
+DOMAIN,www.example.com,us
+- DOMAIN-SUFFIX,google.com,us # Clash style list item
+DOMAIN-KEYWORD,ads,REJECT
+IP-CIDR,10.0.0.0/8,DIRECT,no-resolve
+SRC-IP-CIDR,192.168.1.0/24,eu
+DST-PORT,8000-9000,eu
+SRC-PORT,1234,REJECT
+USER,alice,us
+MATCH,DIRECT
+`
+
+func TestRules(t *testing.T) {
+	us, eu := NewChain(Node{ID: 1}), NewChain(Node{ID: 2})
+	rules := NewRules(map[string]*Chain{"us": us, "eu": eu})
+	if err := rules.Reload(bytes.NewBufferString(rulesTestData)); err != nil {
+		t.Fatal(err)
+	}
+	if rules.Period().String() != "10s" || len(rules.Rules()) != 9 {
+		t.Errorf("wrong rules %v, period %v", rules.Rules(), rules.Period())
+	}
+
+	tests := []struct {
+		ctx   SelectContext
+		chain *Chain
+		err   error
+	}{
+		{SelectContext{Host: "www.example.com:443"}, us, nil},
+		{SelectContext{Host: "WWW.EXAMPLE.COM.:443"}, us, nil},
+		{SelectContext{Host: "example.com:443"}, nil, nil},
+		{SelectContext{Host: "google.com:80"}, us, nil},
+		{SelectContext{Host: "mail.google.com:80"}, us, nil},
+		{SelectContext{Host: "notgoogle.com:80"}, nil, nil},
+		{SelectContext{Host: "ads.example.org:80"}, nil, ErrRuleRejected},
+		{SelectContext{Host: "10.1.2.3:80", Client: "192.168.1.2:5000"}, nil, nil},
+		{SelectContext{Host: "1.2.3.4:80", Client: "192.168.1.2:5000"}, eu, nil},
+		{SelectContext{Host: "1.2.3.4:8080"}, eu, nil},
+		{SelectContext{Host: "1.2.3.4:9001", Client: "127.0.0.1:1234"}, nil, ErrRuleRejected},
+		{SelectContext{Host: "1.2.3.4:80", User: "alice"}, us, nil},
+		{SelectContext{Host: "1.2.3.4:80", User: "bob"}, nil, nil},
+	}
+	for i, tc := range tests {
+		chain, matched, err := rules.Match(tc.ctx)
+		if !matched || chain != tc.chain || err != tc.err {
+			t.Errorf("#%d test failed: %+v got chain %v, matched %v, error %v", i, tc.ctx, chain, matched, err)
+		}
+	}
+}
+
+func TestRulesReload(t *testing.T) {
+	tests := []struct {
+		data string
+		ok   bool
+	}{
+		{"DOMAIN,example.com,DIRECT", true},
+		{"DOMAIN,example.com,us", true},
+		{"DOMAIN,example.com,unknown", false},
+		{"DOMAIN,example.com", false},
+		{"GEOIP,CN,DIRECT", false},
+		{"IP-CIDR,10.0.0.1,DIRECT", false},
+		{"DST-PORT,80-70,DIRECT", false},
+		{"MATCH", false},
+	}
+	for i, tc := range tests {
+		rules := NewRules(map[string]*Chain{"us": NewChain()})
+		if err := rules.Reload(bytes.NewBufferString(tc.data)); (err == nil) != tc.ok {
+			t.Errorf("#%d test failed: %s got error %v", i, tc.data, err)
+		}
+	}
+}
+
+func TestChainRules(t *testing.T) {
+	us := NewChain(Node{ID: 1, Client: DefaultClient})
+	chain := NewChain(Node{ID: 2, Client: DefaultClient})
+	var rules []*Rule
+	for _, s := range []string{"DOMAIN,example.com,us", "DOMAIN,example.org,DIRECT", "DOMAIN,example.net,REJECT"} {
+		rule, err := ParseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	chain.Rules = NewRules(map[string]*Chain{"us": us}, rules...)
+
+	tests := []struct {
+		host   string
+		chains []string
+		route  []int
+		err    error
+	}{
+		{"example.com:80", nil, []int{1}, nil},
+		{"example.org:80", nil, nil, nil},
+		{"example.net:80", nil, nil, ErrRuleRejected},
+		{"example.io:80", nil, []int{2}, nil},
+		// the chains allowed for the user
+		{"example.com:80", []string{"us"}, []int{1}, nil},
+		{"example.com:80", []string{"eu"}, nil, ErrRuleRejected},
+		{"example.org:80", []string{"eu"}, nil, nil},
+		{"example.io:80", []string{"eu"}, []int{2}, nil},
+	}
+	for i, tc := range tests {
+		route, err := chain.selectRouteContext(SelectContext{Host: tc.host, Chains: tc.chains})
+		if err != tc.err {
+			t.Errorf("#%d test failed: got error %v", i, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var ids []int
+		for _, node := range route.route {
+			ids = append(ids, node.ID)
+		}
+		if len(ids) != len(tc.route) || (len(ids) > 0 && ids[0] != tc.route[0]) {
+			t.Errorf("#%d test failed: got route %v, want %v", i, ids, tc.route)
+		}
+	}
+}
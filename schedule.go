@@ -0,0 +1,206 @@
+package gost
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// TimeRange specifies the range of the time of day in minutes, such as 09:00-18:00.
+// The range crossing midnight, such as 22:00-06:00, belongs to the day it starts.
+type TimeRange struct {
+	Start, End int
+}
+
+// Contains checks whether the minute of day is within this range.
+func (tr *TimeRange) Contains(minute int) bool {
+	if tr.Start < tr.End {
+		return minute >= tr.Start && minute < tr.End
+	}
+	return minute >= tr.Start || minute < tr.End
+}
+
+// Schedule is a set of the weekly time windows of a Permission.
+type Schedule struct {
+	Days     []time.Weekday // empty means every day
+	Times    []TimeRange    // empty means all day
+	Location *time.Location // nil means the local time
+}
+
+// ParseSchedule parses the s to a Schedule.
+// The s should be a comma separated list of the days, such as mon or mon-fri,
+// the time ranges, such as 09:00-18:00, and the optional time zone, such as UTC or Europe/Berlin.
+func ParseSchedule(s string) (*Schedule, error) {
+	if s == "" {
+		return nil, fmt.Errorf("cannot be empty")
+	}
+
+	sched := &Schedule{}
+	for _, v := range strings.Split(s, ",") {
+		switch {
+		case v == "":
+			return nil, fmt.Errorf("empty item: %s", s)
+		case strings.Contains(v, ":"):
+			tr, err := parseTimeRange(v)
+			if err != nil {
+				return nil, err
+			}
+			sched.Times = append(sched.Times, *tr)
+		case isWeekdays(v):
+			days, err := parseWeekdays(v)
+			if err != nil {
+				return nil, err
+			}
+			sched.Days = append(sched.Days, days...)
+		default:
+			if sched.Location != nil {
+				return nil, fmt.Errorf("duplicate time zone: %s", v)
+			}
+			loc, err := time.LoadLocation(v)
+			if err != nil {
+				return nil, err
+			}
+			sched.Location = loc
+		}
+	}
+	return sched, nil
+}
+
+func isWeekdays(s string) bool {
+	first := strings.SplitN(s, "-", 2)[0]
+	return weekday(first) >= 0
+}
+
+func weekday(s string) time.Weekday {
+	for i, d := range weekdays {
+		if strings.EqualFold(s, d) {
+			return time.Weekday(i)
+		}
+	}
+	return -1
+}
+
+// parseWeekdays parses the day, such as mon, or the range of days, such as mon-fri or fri-mon.
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	minmax := strings.Split(s, "-")
+	switch len(minmax) {
+	case 1:
+		return []time.Weekday{weekday(s)}, nil
+	case 2:
+		first, last := weekday(minmax[0]), weekday(minmax[1])
+		if last < 0 {
+			return nil, fmt.Errorf("invalid day: %s", minmax[1])
+		}
+		var days []time.Weekday
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+		return days, nil
+	default:
+		return nil, fmt.Errorf("invalid days: %s", s)
+	}
+}
+
+func parseTimeRange(s string) (*TimeRange, error) {
+	minmax := strings.Split(s, "-")
+	if len(minmax) != 2 {
+		return nil, fmt.Errorf("invalid time range: %s", s)
+	}
+	start, err := parseMinute(minmax[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseMinute(minmax[1])
+	if err != nil {
+		return nil, err
+	}
+	if start == end || start == 24*60 {
+		return nil, fmt.Errorf("empty time range: %s", s)
+	}
+	return &TimeRange{Start: start, End: end}, nil
+}
+
+// parseMinute parses the time of day in the form of HH:MM to the minutes, 24:00 is the end of day.
+func parseMinute(s string) (int, error) {
+	hm := strings.Split(s, ":")
+	if len(hm) != 2 || len(hm[1]) != 2 {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	h, err := strconv.Atoi(hm[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	m, err := strconv.Atoi(hm[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return h*60 + m, nil
+}
+
+// Active checks whether the time t is within the schedule, the nil schedule is always active.
+func (s *Schedule) Active(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	if s.Location != nil {
+		t = t.In(s.Location)
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if len(s.Times) == 0 {
+		return s.hasDay(t.Weekday())
+	}
+	for _, tr := range s.Times {
+		if !tr.Contains(minute) {
+			continue
+		}
+		day := t.Weekday()
+		if tr.Start >= tr.End && minute < tr.End {
+			// the early hours of the range crossing midnight belong to the previous day.
+			day = (day + 6) % 7
+		}
+		if s.hasDay(day) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schedule) hasDay(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the schedule in the format of ParseSchedule.
+func (s *Schedule) String() string {
+	if s == nil {
+		return ""
+	}
+	var ss []string
+	for _, d := range s.Days {
+		ss = append(ss, weekdays[d])
+	}
+	for _, tr := range s.Times {
+		ss = append(ss, fmt.Sprintf("%02d:%02d-%02d:%02d", tr.Start/60, tr.Start%60, tr.End/60, tr.End%60))
+	}
+	if s.Location != nil {
+		ss = append(ss, s.Location.String())
+	}
+	return strings.Join(ss, ",")
+}
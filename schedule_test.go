@@ -0,0 +1,126 @@
+package gost
+
+import (
+	"testing"
+	"time"
+)
+
+var scheduleParseTests = []struct {
+	in   string
+	out  string
+	fail bool
+}{
+	{in: "mon", out: "mon"},
+	{in: "Mon-Fri,09:00-18:00", out: "mon,tue,wed,thu,fri,09:00-18:00"},
+	{in: "fri-mon", out: "fri,sat,sun,mon"},
+	{in: "22:00-06:00,UTC", out: "22:00-06:00,UTC"},
+	{in: "sat,sun,00:00-24:00", out: "sat,sun,00:00-24:00"},
+	{in: "09:00-12:00,13:00-18:00,Europe/Berlin", out: "09:00-12:00,13:00-18:00,Europe/Berlin"},
+	{in: "", fail: true},
+	{in: "mon-xyz", fail: true},
+	{in: "mon-tue-wed", fail: true},
+	{in: "9-18", fail: true},
+	{in: "09:00", fail: true},
+	{in: "09:00-25:00", fail: true},
+	{in: "09:60-18:00", fail: true},
+	{in: "09:00-09:00", fail: true},
+	{in: "24:00-06:00", fail: true},
+	{in: "mon,Nowhere/City", fail: true},
+	{in: "UTC,UTC", fail: true},
+	{in: "mon-fri,,09:00-18:00", fail: true},
+	{in: "mon,", fail: true},
+}
+
+func TestParseSchedule(t *testing.T) {
+	for i, tc := range scheduleParseTests {
+		s, err := ParseSchedule(tc.in)
+		if (err != nil) != tc.fail {
+			t.Errorf("#%d test failed: %s, error %v", i, tc.in, err)
+			continue
+		}
+		if s.String() != tc.out {
+			t.Errorf("#%d test failed: got %s, want %s", i, s.String(), tc.out)
+		}
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	// 2021-06-07 is a Monday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2021, 6, 7+day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		schedule string
+		t        time.Time
+		active   bool
+	}{
+		{"mon-fri,09:00-18:00,UTC", at(0, 9, 0), true},
+		{"mon-fri,09:00-18:00,UTC", at(4, 17, 59), true},
+		{"mon-fri,09:00-18:00,UTC", at(4, 18, 0), false},
+		{"mon-fri,09:00-18:00,UTC", at(5, 12, 0), false},
+		{"sat,sun,UTC", at(6, 23, 59), true},
+		{"sat,sun,UTC", at(7, 0, 0), false},
+		{"22:00-06:00,UTC", at(2, 23, 0), true},
+		{"22:00-06:00,UTC", at(2, 5, 59), true},
+		{"22:00-06:00,UTC", at(2, 6, 0), false},
+		{"fri,22:00-06:00,UTC", at(5, 3, 0), true}, // Friday night
+		{"fri,22:00-06:00,UTC", at(4, 3, 0), false},
+		{"fri,22:00-06:00,UTC", at(5, 23, 0), false},
+		{"mon,09:00-18:00,Asia/Tokyo", at(0, 0, 0), true},
+		{"mon,09:00-18:00,Asia/Tokyo", at(0, 9, 0), false},
+	}
+	for i, tc := range tests {
+		s, err := ParseSchedule(tc.schedule)
+		if err != nil {
+			t.Errorf("#%d test failed: %v", i, err)
+			continue
+		}
+		if s.Active(tc.t) != tc.active {
+			t.Errorf("#%d test failed: %s at %v, want %v", i, tc.schedule, tc.t, tc.active)
+		}
+	}
+}
+
+func TestPermissionsSchedule(t *testing.T) {
+	whitelist, err := ParsePermissions("bind,udp:*:*@mon-fri,09:00-18:00,UTC tcp:*:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blacklist, err := ParsePermissions("tcp:*:25@22:00-06:00,UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		t      time.Time
+		action string
+		addr   string
+		can    bool
+	}{
+		{time.Date(2021, 6, 7, 10, 0, 0, 0, time.UTC), "bind", "0.0.0.0:8080", true},
+		{time.Date(2021, 6, 7, 20, 0, 0, 0, time.UTC), "bind", "0.0.0.0:8080", false},
+		{time.Date(2021, 6, 12, 10, 0, 0, 0, time.UTC), "udp", "8.8.8.8:53", false},
+		{time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC), "tcp", "example.com:25", true},
+		{time.Date(2021, 6, 7, 23, 0, 0, 0, time.UTC), "tcp", "example.com:25", false},
+		{time.Date(2021, 6, 7, 23, 0, 0, 0, time.UTC), "tcp", "example.com:443", true},
+	}
+	for i, tc := range tests {
+		now := tc.t
+		opts := &HandlerOptions{
+			Whitelist: whitelist,
+			Blacklist: blacklist,
+			Now:       func() time.Time { return now },
+		}
+		if _, ok := opts.can(tc.action, tc.addr, nil); ok != tc.can {
+			t.Errorf("#%d test failed: %s %s at %v, want %v", i, tc.action, tc.addr, tc.t, tc.can)
+		}
+	}
+
+	if s := whitelist.String(); s != "bind,udp:*:0-65535@mon,tue,wed,thu,fri,09:00-18:00,UTC tcp:*:0-65535" {
+		t.Errorf("got %s", s)
+	}
+	if _, err := ParsePermissions("tcp:*:25@22:00"); err == nil {
+		t.Error("invalid schedule is parsed")
+	}
+}
@@ -2,8 +2,11 @@ package gost
 
 import (
 	"errors"
+	"hash/fnv"
 	"math/rand"
 	"net"
+	"reflect"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -39,6 +42,9 @@ func (s *defaultSelector) Select(nodes []Node, opts ...SelectOption) (Node, erro
 	if strategy == nil {
 		strategy = &RoundStrategy{}
 	}
+	if s, ok := strategy.(ContextStrategy); ok {
+		return s.ApplyContext(sopts.Context, nodes), nil
+	}
 	return strategy.Apply(nodes), nil
 }
 
@@ -49,6 +55,16 @@ type SelectOption func(*SelectOptions)
 type SelectOptions struct {
 	Filters  []Filter
 	Strategy Strategy
+	Context  SelectContext
+}
+
+// SelectContext is the context of a node selection.
+type SelectContext struct {
+	Client string // address of the client
+	Host   string // target address
+	User   string // authenticated user
+	// Chains are the named chains that the rules can route the user to, empty for any.
+	Chains []string
 }
 
 // WithFilter adds a filter function to the list of filters
@@ -66,12 +82,25 @@ func WithStrategy(s Strategy) SelectOption {
 	}
 }
 
+// WithContext sets the selection context.
+func WithContext(ctx SelectContext) SelectOption {
+	return func(o *SelectOptions) {
+		o.Context = ctx
+	}
+}
+
 // Strategy is a selection strategy e.g random, round-robin.
 type Strategy interface {
 	Apply([]Node) Node
 	String() string
 }
 
+// ContextStrategy is a Strategy that selects the node according to the selection context.
+type ContextStrategy interface {
+	Strategy
+	ApplyContext(ctx SelectContext, nodes []Node) Node
+}
+
 // NewStrategy creates a Strategy by the name s.
 func NewStrategy(s string) Strategy {
 	switch s {
@@ -79,6 +108,14 @@ func NewStrategy(s string) Strategy {
 		return &RandomStrategy{}
 	case "fifo":
 		return &FIFOStrategy{}
+	case "least_conn":
+		return &LeastConnStrategy{}
+	case "latency":
+		return &LatencyStrategy{}
+	case "weighted":
+		return &WeightedStrategy{}
+	case "hash":
+		return &HashStrategy{}
 	case "round":
 		fallthrough
 	default:
@@ -156,6 +193,230 @@ func (s *FIFOStrategy) String() string {
 	return "fifo"
 }
 
+// LeastConnStrategy is a strategy for node selector.
+// The node with the fewest active connections will be selected,
+// the ties are broken by round-robin.
+type LeastConnStrategy struct {
+	counter uint64
+}
+
+// Apply applies the least-connections strategy for the nodes.
+func (s *LeastConnStrategy) Apply(nodes []Node) Node {
+	if len(nodes) == 0 {
+		return Node{}
+	}
+
+	offset := int((atomic.AddUint64(&s.counter, 1) - 1) % uint64(len(nodes)))
+	best := nodes[offset]
+	for i := 1; i < len(nodes); i++ {
+		node := nodes[(offset+i)%len(nodes)]
+		if node.Conns() < best.Conns() {
+			best = node
+		}
+	}
+	return best
+}
+
+func (s *LeastConnStrategy) String() string {
+	return "least_conn"
+}
+
+// LatencyStrategy is a strategy for node selector.
+// The node with the lowest handshake latency will be selected.
+// The nodes that have not been measured yet are preferred, so that each node gets measured.
+type LatencyStrategy struct{}
+
+// Apply applies the latency strategy for the nodes.
+func (s *LatencyStrategy) Apply(nodes []Node) Node {
+	if len(nodes) == 0 {
+		return Node{}
+	}
+
+	best := nodes[0]
+	for _, node := range nodes[1:] {
+		if best.Latency() == 0 {
+			break
+		}
+		if node.Latency() < best.Latency() {
+			best = node
+		}
+	}
+	return best
+}
+
+func (s *LatencyStrategy) String() string {
+	return "latency"
+}
+
+// WeightedStrategy is a strategy for node selector.
+// The node will be selected by the smooth weighted round-robin algorithm,
+// the weight of the node is specified by the node parameter weight, default is 1.
+type WeightedStrategy struct {
+	weights map[int]int // current weights by node ID
+	mux     sync.Mutex
+}
+
+// Apply applies the weighted round-robin strategy for the nodes.
+func (s *WeightedStrategy) Apply(nodes []Node) Node {
+	if len(nodes) == 0 {
+		return Node{}
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.weights == nil {
+		s.weights = make(map[int]int)
+	}
+
+	total := 0
+	best := -1
+	for i := range nodes {
+		weight := nodes[i].GetInt("weight")
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		s.weights[nodes[i].ID] += weight
+		if best < 0 || s.weights[nodes[i].ID] > s.weights[nodes[best].ID] {
+			best = i
+		}
+	}
+	s.weights[nodes[best].ID] -= total
+
+	return nodes[best]
+}
+
+func (s *WeightedStrategy) String() string {
+	return "weighted"
+}
+
+// hash keys for HashStrategy
+const (
+	HashKeySrc  = "src"  // IP of the client
+	HashKeyHost = "host" // host of the target address
+	HashKeyUser = "user" // authenticated user
+)
+
+// hashReplicas is the number of the virtual nodes of a node on the hash ring.
+const hashReplicas = 100
+
+// HashStrategy is a strategy for node selector.
+// The node will be selected by consistent hashing of the key from the selection context,
+// so the same key sticks to the same node, and adding or removing nodes only remaps a small fraction of the keys.
+// The selection falls back to round-robin if the key is empty.
+type HashStrategy struct {
+	Key   string // HashKeySrc, HashKeyHost or HashKeyUser, default is HashKeySrc
+	round RoundStrategy
+	ring  []hashPoint
+	names []string // node names of the ring
+	mux   sync.Mutex
+}
+
+type hashPoint struct {
+	hash uint32
+	name string
+}
+
+// Apply applies the round-robin strategy for the nodes, as there is no selection context.
+func (s *HashStrategy) Apply(nodes []Node) Node {
+	return s.round.Apply(nodes)
+}
+
+// ApplyContext applies the consistent hash strategy for the nodes.
+func (s *HashStrategy) ApplyContext(ctx SelectContext, nodes []Node) Node {
+	if len(nodes) == 0 {
+		return Node{}
+	}
+
+	key := s.key(ctx)
+	if key == "" || len(nodes) == 1 {
+		return s.round.Apply(nodes)
+	}
+
+	names := hashNames(nodes)
+	name := s.lookup(key, names)
+	for i := range nodes {
+		if names[i] == name {
+			return nodes[i]
+		}
+	}
+	return nodes[0]
+}
+
+func (s *HashStrategy) key(ctx SelectContext) string {
+	switch s.Key {
+	case HashKeyHost:
+		if host, _, err := net.SplitHostPort(ctx.Host); err == nil {
+			return host
+		}
+		return ctx.Host
+	case HashKeyUser:
+		return ctx.User
+	default:
+		if host, _, err := net.SplitHostPort(ctx.Client); err == nil {
+			return host
+		}
+		return ctx.Client
+	}
+}
+
+// lookup finds the name of the node that the key is mapped to on the hash ring of the node names.
+func (s *HashStrategy) lookup(key string, names []string) string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, s.names) {
+		s.names = names
+		s.ring = s.ring[:0]
+		for _, name := range names {
+			for i := 0; i < hashReplicas; i++ {
+				s.ring = append(s.ring, hashPoint{
+					hash: hashString(name + "#" + strconv.Itoa(i)),
+					name: name,
+				})
+			}
+		}
+		sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	}
+
+	h := hashString(key)
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].name
+}
+
+func (s *HashStrategy) String() string {
+	return "hash"
+}
+
+// hashNames returns the names of the nodes on the hash ring by their addresses,
+// the IDs are not used, as they are assigned by the positions of the peers.
+// The nodes with the same address, such as the different protocols, are numbered in order,
+// so they are not collapsed.
+func hashNames(nodes []Node) []string {
+	names := make([]string, len(nodes))
+	seen := make(map[string]int)
+	for i, node := range nodes {
+		names[i] = node.Addr
+		if n := seen[node.Addr]; n > 0 {
+			names[i] = strconv.Itoa(n) + "/" + node.Addr
+		}
+		seen[node.Addr]++
+	}
+	return names
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
 // Filter is used to filter a node during the selection process
 type Filter interface {
 	Filter([]Node) []Node
@@ -292,3 +553,62 @@ func (m *failMarker) Clone() *failMarker {
 		failTime:  ft,
 	}
 }
+
+// latencyDecay is the weight of the latest sample in the EWMA of the node latency.
+const latencyDecay = 0.3
+
+// nodeStats is the runtime statistics of a node used by the strategies.
+type nodeStats struct {
+	conns   int64 // active connections through the node
+	latency int64 // EWMA of the handshake latency in nanoseconds
+}
+
+func (s *nodeStats) Conns() int64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.conns)
+}
+
+func (s *nodeStats) addConn(delta int64) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.conns, delta)
+}
+
+func (s *nodeStats) Latency() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&s.latency))
+}
+
+func (s *nodeStats) observeLatency(d time.Duration) {
+	if s == nil {
+		return
+	}
+	if d <= 0 {
+		d = 1
+	}
+
+	for {
+		old := atomic.LoadInt64(&s.latency)
+		v := int64(d)
+		if old > 0 {
+			v = old + int64(latencyDecay*float64(int64(d)-old))
+		}
+		if atomic.CompareAndSwapInt64(&s.latency, old, v) {
+			return
+		}
+	}
+}
+
+func (s *nodeStats) Clone() *nodeStats {
+	if s == nil {
+		return nil
+	}
+	return &nodeStats{
+		latency: atomic.LoadInt64(&s.latency),
+	}
+}
@@ -1,6 +1,9 @@
 package gost
 
 import (
+	"fmt"
+	"net/url"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -65,6 +68,158 @@ func TestFIFOStrategy(t *testing.T) {
 	}
 }
 
+func TestLeastConnStrategy(t *testing.T) {
+	nodes := []Node{
+		Node{ID: 1, stats: &nodeStats{conns: 2}},
+		Node{ID: 2, stats: &nodeStats{conns: 1}},
+		Node{ID: 3, stats: &nodeStats{conns: 1}},
+	}
+	s := NewStrategy("least_conn")
+	t.Log(s.String())
+
+	if node := s.Apply(nil); node.ID > 0 {
+		t.Error("unexpected node", node.String())
+	}
+	for i := 0; i <= len(nodes); i++ {
+		node := s.Apply(nodes)
+		if node.ID == 1 {
+			t.Error("unexpected node", node.String())
+		}
+	}
+
+	nodes[2].stats.addConn(-1)
+	if node := s.Apply(nodes); node.ID != 3 {
+		t.Error("unexpected node", node.String())
+	}
+}
+
+func TestLatencyStrategy(t *testing.T) {
+	nodes := []Node{
+		Node{ID: 1, stats: &nodeStats{}},
+		Node{ID: 2, stats: &nodeStats{}},
+		Node{ID: 3, stats: &nodeStats{}},
+	}
+	s := NewStrategy("latency")
+	t.Log(s.String())
+
+	if node := s.Apply(nil); node.ID > 0 {
+		t.Error("unexpected node", node.String())
+	}
+
+	tests := []struct {
+		node    int
+		latency time.Duration
+		want    int
+	}{
+		{0, 30 * time.Millisecond, 2},
+		{1, 20 * time.Millisecond, 3},
+		{2, 10 * time.Millisecond, 3},
+		{2, 50 * time.Millisecond, 2}, // 10 + 0.3 * (50 - 10) = 22
+		{1, 40 * time.Millisecond, 3}, // 20 + 0.3 * (40 - 20) = 26
+	}
+	for i, tc := range tests {
+		nodes[tc.node].stats.observeLatency(tc.latency)
+		if node := s.Apply(nodes); node.ID != tc.want {
+			t.Errorf("#%d test failed: got node %d, want %d", i, node.ID, tc.want)
+		}
+	}
+}
+
+func TestWeightedStrategy(t *testing.T) {
+	nodes := []Node{
+		Node{ID: 1, Values: url.Values{"weight": []string{"5"}}},
+		Node{ID: 2, Values: url.Values{"weight": []string{"1"}}},
+		Node{ID: 3},
+	}
+	s := NewStrategy("weighted")
+	t.Log(s.String())
+
+	if node := s.Apply(nil); node.ID > 0 {
+		t.Error("unexpected node", node.String())
+	}
+
+	var ids []int
+	for i := 0; i < 7; i++ {
+		ids = append(ids, s.Apply(nodes).ID)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 1, 2, 1, 3, 1, 1}) {
+		t.Error("unexpected nodes", ids)
+	}
+}
+
+func TestHashStrategy(t *testing.T) {
+	var nodes []Node
+	for i := 1; i <= 5; i++ {
+		nodes = append(nodes, Node{ID: i, Addr: fmt.Sprintf("192.168.1.%d:1080", i)})
+	}
+
+	tests := []struct {
+		key string
+		ctx func(i int) SelectContext
+	}{
+		{"", func(i int) SelectContext {
+			return SelectContext{Client: fmt.Sprintf("10.0.%d.%d:%d", i/256, i%256, 10000+i)}
+		}},
+		{HashKeySrc, func(i int) SelectContext {
+			return SelectContext{Client: fmt.Sprintf("10.0.%d.%d:%d", i/256, i%256, 10000+i)}
+		}},
+		{HashKeyHost, func(i int) SelectContext { return SelectContext{Host: fmt.Sprintf("www%d.example.com:%d", i, 80+i)} }},
+		{HashKeyUser, func(i int) SelectContext { return SelectContext{User: fmt.Sprintf("user%d", i)} }},
+	}
+
+	for i, tc := range tests {
+		s := &HashStrategy{Key: tc.key}
+
+		mapped := make(map[int]int)
+		counts := make(map[int]int)
+		for j := 0; j < 1000; j++ {
+			mapped[j] = s.ApplyContext(tc.ctx(j), nodes).ID
+			counts[mapped[j]]++
+			if id := s.ApplyContext(tc.ctx(j), nodes).ID; id != mapped[j] {
+				t.Errorf("#%d test failed: key %d is mapped to node %d, then %d", i, j, mapped[j], id)
+			}
+		}
+		if len(counts) != len(nodes) {
+			t.Errorf("#%d test failed: keys are mapped to %v", i, counts)
+		}
+
+		// remove the node 3, the IDs of the later nodes are shifted as the peers are reloaded.
+		nl := append(append([]Node{}, nodes[:2]...), nodes[3:]...)
+		for k := range nl {
+			nl[k].ID = k + 1
+		}
+		for j := 0; j < 1000; j++ {
+			addr := s.ApplyContext(tc.ctx(j), nl).Addr
+			if addr == nodes[2].Addr || (mapped[j] != 3 && addr != nodes[mapped[j]-1].Addr) {
+				t.Errorf("#%d test failed: key %d is remapped from node %s to %s", i, j, nodes[mapped[j]-1].Addr, addr)
+			}
+		}
+	}
+
+	// the nodes with the same address, such as the different protocols, are all on the ring.
+	same := []Node{{ID: 1, Addr: "192.168.1.1:1080"}, {ID: 2, Addr: "192.168.1.1:1080"}}
+	counts := make(map[int]int)
+	hs := &HashStrategy{Key: HashKeyUser}
+	for j := 0; j < 100; j++ {
+		counts[hs.ApplyContext(SelectContext{User: fmt.Sprintf("user%d", j)}, same).ID]++
+	}
+	if len(counts) != len(same) {
+		t.Errorf("keys are mapped to %v", counts)
+	}
+
+	s := NewStrategy("hash")
+	t.Log(s.String())
+	if node := s.Apply(nil); node.ID > 0 {
+		t.Error("unexpected node", node.String())
+	}
+	// no key in the context, fall back to round-robin
+	for i := 0; i <= len(nodes); i++ {
+		if node := s.(ContextStrategy).ApplyContext(SelectContext{}, nodes); node.ID != nodes[i%len(nodes)].ID {
+			t.Error("unexpected node", node.String())
+		}
+	}
+}
+
 func TestFailFilter(t *testing.T) {
 	nodes := []Node{
 		Node{ID: 1, marker: &failMarker{}},
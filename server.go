@@ -59,6 +59,12 @@ func (s *Server) Serve(h Handler, opts ...ServerOption) error {
 		h = HTTPHandler()
 	}
 
+	node := s.options.Node
+	handler := node.Protocol
+	if handler == "" {
+		handler = "auto"
+	}
+
 	l := s.Listener
 	var tempDelay time.Duration
 	for {
@@ -81,7 +87,11 @@ func (s *Server) Serve(h Handler, opts ...ServerOption) error {
 		}
 		tempDelay = 0
 
-		go h.Handle(conn)
+		done := DefaultMetrics.connAccepted(node.String(), handler)
+		go func() {
+			defer done()
+			h.Handle(conn)
+		}()
 	}
 }
 
@@ -92,11 +102,19 @@ func (s *Server) Run() error {
 
 // ServerOptions holds the options for Server.
 type ServerOptions struct {
+	Node Node
 }
 
 // ServerOption allows a common way to set server options.
 type ServerOption func(opts *ServerOptions)
 
+// NodeServerOption sets the serve node of the server.
+func NodeServerOption(node Node) ServerOption {
+	return func(opts *ServerOptions) {
+		opts.Node = node
+	}
+}
+
 // Listener is a proxy server listener, just like a net.Listener.
 type Listener interface {
 	net.Listener
@@ -0,0 +1,191 @@
+package gost
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-log/log"
+)
+
+var (
+	// DefaultSessions is the registry of the active proxy sessions of all the serve nodes,
+	// nil means the active sessions are not tracked.
+	DefaultSessions *Sessions
+)
+
+var sessionID uint64
+
+// Sessions is a registry of the active proxy sessions.
+type Sessions struct {
+	m   map[uint64]*session
+	mux sync.RWMutex
+}
+
+// NewSessions creates a session registry.
+func NewSessions() *Sessions {
+	return &Sessions{
+		m: make(map[uint64]*session),
+	}
+}
+
+// List returns the records of the active sessions ordered by ID.
+func (s *Sessions) List() []AccessRecord {
+	if s == nil {
+		return nil
+	}
+
+	s.mux.RLock()
+	records := make([]AccessRecord, 0, len(s.m))
+	for _, ss := range s.m {
+		records = append(records, ss.record())
+	}
+	s.mux.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+// Kill closes the client connection of the session id, it reports whether the session exists.
+func (s *Sessions) Kill(id uint64) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mux.RLock()
+	ss := s.m[id]
+	s.mux.RUnlock()
+	if ss == nil {
+		return false
+	}
+
+	atomic.StoreInt32(&ss.killed, 1)
+	ss.conn.Close()
+	return true
+}
+
+func (s *Sessions) add(ss *session) {
+	if s == nil {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.m[ss.rec.ID] = ss
+}
+
+func (s *Sessions) remove(ss *session) {
+	if s == nil {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.m, ss.rec.ID)
+}
+
+// session starts a proxy session from the client connection conn to the host through the route.
+// The session is tracked by the session registry and recorded to the access log when it is finished.
+func (opts *HandlerOptions) session(handler string, conn net.Conn, user, host string, route *Chain) *session {
+	if opts.AccessLog == nil && DefaultSessions == nil {
+		return nil
+	}
+
+	s := &session{
+		logger:   opts.AccessLog,
+		sessions: DefaultSessions,
+		conn:     conn,
+		rec: AccessRecord{
+			ID:      atomic.AddUint64(&sessionID, 1),
+			Time:    time.Now(),
+			Node:    opts.Node.String(),
+			Handler: handler,
+			Client:  conn.RemoteAddr().String(),
+			User:    user,
+			Host:    host,
+		},
+	}
+	if route != nil {
+		for _, node := range route.route {
+			s.rec.Route = append(s.rec.Route, node.ID)
+		}
+	}
+	s.sessions.add(s)
+	return s
+}
+
+type session struct {
+	logger       *AccessLogger
+	sessions     *Sessions
+	conn         net.Conn
+	rec          AccessRecord
+	up, down     uint64
+	clientClosed int32
+	killed       int32
+}
+
+// record returns the snapshot of the session record.
+func (s *session) record() AccessRecord {
+	rec := s.rec
+	rec.BytesUp = atomic.LoadUint64(&s.up)
+	rec.BytesDown = atomic.LoadUint64(&s.down)
+	rec.Duration = time.Since(rec.Time).Seconds()
+	return rec
+}
+
+// wrapConn wraps the client connection conn to count the transferred bytes of the session.
+func (s *session) wrapConn(conn net.Conn) net.Conn {
+	if s == nil {
+		return conn
+	}
+	return &sessionConn{Conn: conn, s: s}
+}
+
+// end finishes the session with the error returned by transport, and writes the record to the access log.
+func (s *session) end(err error) {
+	if s == nil {
+		return
+	}
+	s.sessions.remove(s)
+
+	rec := s.record()
+	switch {
+	case atomic.LoadInt32(&s.killed) == 1:
+		rec.Reason = "killed"
+	case err != nil:
+		rec.Reason = err.Error()
+	case atomic.LoadInt32(&s.clientClosed) == 1:
+		rec.Reason = "client closed"
+	default:
+		rec.Reason = "server closed"
+	}
+
+	if err := s.logger.Log(&rec); err != nil {
+		log.Logf("[accesslog] %s: %v", rec.Client, err)
+	}
+}
+
+type sessionConn struct {
+	net.Conn
+	s *session
+}
+
+func (c *sessionConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	atomic.AddUint64(&c.s.up, uint64(n))
+	if err == io.EOF {
+		atomic.StoreInt32(&c.s.clientClosed, 1)
+	}
+	return
+}
+
+func (c *sessionConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddUint64(&c.s.down, uint64(n))
+	return
+}
@@ -0,0 +1,91 @@
+package gost
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionsKill(t *testing.T) {
+	DefaultSessions = NewSessions()
+	defer func() { DefaultSessions = nil }()
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := make(chanWriter, 1)
+	client := &Client{
+		Connector:   SOCKS5Connector(nil),
+		Transporter: TCPTransporter(),
+	}
+	server := &Server{
+		Listener: ln,
+		Handler:  SOCKS5Handler(AccessLogHandlerOption(NewAccessLogger(records))),
+	}
+	go server.Run()
+	defer server.Close()
+
+	conn, err := proxyConn(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn, err = client.Connect(conn, echo.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	b := []byte("ping")
+	conn.Write(b)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := DefaultSessions.List()
+	if len(sessions) != 1 || sessions[0].Host != echo.Addr().String() || sessions[0].BytesUp != 4 {
+		t.Fatalf("wrong sessions %+v", sessions)
+	}
+	if DefaultSessions.Kill(sessions[0].ID + 1) {
+		t.Error("unknown session should not be killed")
+	}
+	if !DefaultSessions.Kill(sessions[0].ID) {
+		t.Fatal("session should be killed")
+	}
+
+	if _, err := conn.Read(b); err == nil {
+		t.Error("connection should be closed")
+	}
+
+	var rec AccessRecord
+	select {
+	case data := <-records:
+		json.Unmarshal(data, &rec)
+	case <-time.After(time.Second):
+		t.Fatal("no record is written")
+	}
+	if rec.ID != sessions[0].ID || rec.Reason != "killed" {
+		t.Errorf("wrong record %+v", rec)
+	}
+	if len(DefaultSessions.List()) != 0 {
+		t.Error("session should be removed")
+	}
+}
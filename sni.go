@@ -71,7 +71,7 @@ func (h *sniHandler) Handle(conn net.Conn) {
 	br := bufio.NewReader(conn)
 	hdr, err := br.Peek(dissector.RecordHeaderLen)
 	if err != nil {
-		log.Logf("[sni] %s -> %s : %s",
+		h.options.logger().Errorf("[sni] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		return
 	}
@@ -81,7 +81,7 @@ func (h *sniHandler) Handle(conn net.Conn) {
 		// We assume it is an HTTP request
 		req, err := http.ReadRequest(bufio.NewReader(conn))
 		if err != nil {
-			log.Logf("[sni] %s -> %s : %s",
+			h.options.logger().Errorf("[sni] %s -> %s : %s",
 				conn.RemoteAddr(), conn.LocalAddr(), err)
 			return
 		}
@@ -94,7 +94,7 @@ func (h *sniHandler) Handle(conn net.Conn) {
 
 	b, host, err := readClientHelloRecord(conn, "", false)
 	if err != nil {
-		log.Logf("[sni] %s -> %s : %s",
+		h.options.logger().Errorf("[sni] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		return
 	}
@@ -105,19 +105,26 @@ func (h *sniHandler) Handle(conn net.Conn) {
 	}
 	host = net.JoinHostPort(host, sport)
 
-	log.Logf("[sni] %s -> %s -> %s",
+	h.options.logger().Infof("[sni] %s -> %s -> %s",
 		conn.RemoteAddr(), h.options.Node.String(), host)
 
-	if !Can("tcp", host, h.options.Whitelist, h.options.Blacklist) {
-		log.Logf("[sni] %s -> %s : Unauthorized to tcp connect to %s",
+	checked, ok := h.options.can("tcp", host, nil)
+	if !ok {
+		h.options.logger().Warnf("[sni] %s -> %s : Unauthorized to tcp connect to %s",
 			conn.RemoteAddr(), conn.LocalAddr(), host)
 		return
 	}
 	if h.options.Bypass.Contains(host) {
-		log.Log("[sni] %s - %s bypass %s",
+		h.options.logger().Warnf("[sni] %s - %s bypass %s",
 			conn.RemoteAddr(), conn.LocalAddr(), host)
 		return
 	}
+	if h.options.Traffic.Exceeded("") {
+		h.options.logger().Warnf("[sni] %s - %s : %s",
+			conn.RemoteAddr(), conn.LocalAddr(), ErrQuotaExceeded)
+		conn.Write(tlsAccessDeniedAlert)
+		return
+	}
 
 	retries := 1
 	if h.options.Chain != nil && h.options.Chain.Retries > 0 {
@@ -130,9 +137,12 @@ func (h *sniHandler) Handle(conn net.Conn) {
 	var cc net.Conn
 	var route *Chain
 	for i := 0; i < retries; i++ {
-		route, err = h.options.Chain.selectRouteFor(host)
+		route, err = h.options.Chain.selectRouteContext(SelectContext{
+			Client: conn.RemoteAddr().String(),
+			Host:   host,
+		})
 		if err != nil {
-			log.Logf("[sni] %s -> %s : %s",
+			h.options.logger().Errorf("[sni] %s -> %s : %s",
 				conn.RemoteAddr(), conn.LocalAddr(), err)
 			continue
 		}
@@ -144,17 +154,19 @@ func (h *sniHandler) Handle(conn net.Conn) {
 			fmt.Fprintf(&buf, "%d@%s -> ", nd.ID, nd.String())
 		}
 		fmt.Fprintf(&buf, "%s", host)
-		log.Log("[route]", buf.String())
+		h.options.logger().Infof("[route] %s", buf.String())
 
-		cc, err = route.Dial(host,
+		cc, err = route.Dial(dialAddr(route, host, checked),
 			TimeoutChainOption(h.options.Timeout),
 			HostsChainOption(h.options.Hosts),
 			ResolverChainOption(h.options.Resolver),
+			LoggerChainOption(h.options.Logger),
+			RaceChainOption(h.options.Race, h.options.RaceDelay),
 		)
 		if err == nil {
 			break
 		}
-		log.Logf("[sni] %s -> %s : %s",
+		h.options.logger().Errorf("[sni] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 	}
 
@@ -164,15 +176,19 @@ func (h *sniHandler) Handle(conn net.Conn) {
 	defer cc.Close()
 
 	if _, err := cc.Write(b); err != nil {
-		log.Logf("[sni] %s -> %s : %s",
+		h.options.logger().Errorf("[sni] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 	}
 
-	log.Logf("[sni] %s <-> %s", cc.LocalAddr(), host)
-	transport(conn, cc)
-	log.Logf("[sni] %s >-< %s", cc.LocalAddr(), host)
+	h.options.logger().Infof("[sni] %s <-> %s", cc.LocalAddr(), host)
+	s := h.options.session("sni", conn, "", host, route)
+	s.end(transport(s.wrapConn(h.options.clientConn(conn, nil)), cc))
+	h.options.logger().Infof("[sni] %s >-< %s", cc.LocalAddr(), host)
 }
 
+// tlsAccessDeniedAlert is a fatal TLS alert record of access_denied.
+var tlsAccessDeniedAlert = []byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 0x31}
+
 // sniSniffConn is a net.Conn that reads from r, fails on Writes,
 // and crashes otherwise.
 type sniSniffConn struct {
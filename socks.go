@@ -111,6 +111,9 @@ type serverSelector struct {
 	// Users     []*url.Userinfo
 	Authenticator Authenticator
 	TLSConfig     *tls.Config
+	node          string // the serve node
+	logger        LeveledLogger
+	principal     *Principal // the authenticated user
 }
 
 func (selector *serverSelector) Methods() []uint8 {
@@ -122,9 +125,7 @@ func (selector *serverSelector) AddMethod(methods ...uint8) {
 }
 
 func (selector *serverSelector) Select(methods ...uint8) (method uint8) {
-	if Debug {
-		log.Logf("[socks5] %d %d %v", gosocks5.Ver5, len(methods), methods)
-	}
+	selector.logger.Debugf("[socks5] %d %d %v", gosocks5.Ver5, len(methods), methods)
 	method = gosocks5.MethodNoAuth
 	for _, m := range methods {
 		if m == MethodTLS {
@@ -147,9 +148,7 @@ func (selector *serverSelector) Select(methods ...uint8) (method uint8) {
 }
 
 func (selector *serverSelector) OnSelected(method uint8, conn net.Conn) (net.Conn, error) {
-	if Debug {
-		log.Logf("[socks5] %d %d", gosocks5.Ver5, method)
-	}
+	selector.logger.Debugf("[socks5] %d %d", gosocks5.Ver5, method)
 	switch method {
 	case MethodTLS:
 		conn = tls.Server(conn, selector.TLSConfig)
@@ -161,34 +160,38 @@ func (selector *serverSelector) OnSelected(method uint8, conn net.Conn) (net.Con
 
 		req, err := gosocks5.ReadUserPassRequest(conn)
 		if err != nil {
-			log.Logf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+			selector.logger.Errorf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 			return nil, err
 		}
-		if Debug {
-			log.Logf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), req.String())
-		}
-
-		if selector.Authenticator != nil && !selector.Authenticator.Authenticate(req.Username, req.Password) {
+		selector.logger.Debugf("[socks5] %s - %s: %d %s:%s",
+			conn.RemoteAddr(), conn.LocalAddr(), req.Version, req.Username, redacted)
+
+		principal, ok := authenticate(selector.Authenticator, &AuthRequest{
+			User:     req.Username,
+			Password: req.Password,
+			Client:   conn.RemoteAddr().String(),
+			Node:     selector.node,
+			Protocol: "socks5",
+		})
+		if !ok {
 			resp := gosocks5.NewUserPassResponse(gosocks5.UserPassVer, gosocks5.Failure)
 			if err := resp.Write(conn); err != nil {
-				log.Logf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+				selector.logger.Errorf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 				return nil, err
 			}
-			if Debug {
-				log.Logf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), resp)
-			}
-			log.Logf("[socks5] %s - %s: proxy authentication required", conn.RemoteAddr(), conn.LocalAddr())
+			selector.logger.Debugf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), resp)
+			selector.logger.Warnf("[socks5] %s - %s: proxy authentication required", conn.RemoteAddr(), conn.LocalAddr())
+			DefaultMetrics.authFailed("socks5")
 			return nil, gosocks5.ErrAuthFailure
 		}
 
 		resp := gosocks5.NewUserPassResponse(gosocks5.UserPassVer, gosocks5.Succeeded)
 		if err := resp.Write(conn); err != nil {
-			log.Logf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+			selector.logger.Errorf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 			return nil, err
 		}
-		if Debug {
-			log.Logf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), resp)
-		}
+		selector.logger.Debugf("[socks5] %s - %s: %s", conn.RemoteAddr(), conn.LocalAddr(), resp)
+		selector.principal = principal
 	case gosocks5.MethodNoAcceptable:
 		return nil, gosocks5.ErrBadMethod
 	}
@@ -835,6 +838,8 @@ func (h *socks5Handler) Init(options ...HandlerOption) {
 		// Users:     h.options.Users,
 		Authenticator: h.options.Authenticator,
 		TLSConfig:     tlsConfig,
+		node:          h.options.Node.String(),
+		logger:        h.options.logger(),
 	}
 	// methods that socks5 server supported
 	h.selector.AddMethod(
@@ -848,66 +853,73 @@ func (h *socks5Handler) Init(options ...HandlerOption) {
 func (h *socks5Handler) Handle(conn net.Conn) {
 	defer conn.Close()
 
-	conn = gosocks5.ServerConn(conn, h.selector)
+	// each connection has its own selector to hold the authenticated user.
+	selector := *h.selector
+	conn = gosocks5.ServerConn(conn, &selector)
 	req, err := gosocks5.ReadRequest(conn)
 	if err != nil {
-		log.Logf("[socks5] %s -> %s : %s",
+		h.options.logger().Errorf("[socks5] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		return
 	}
-
-	if Debug {
-		log.Logf("[socks5] %s -> %s\n%s",
-			conn.RemoteAddr(), conn.LocalAddr(), req)
+	if user := selector.principal.Name(); h.options.Traffic.Exceeded(user) {
+		h.options.logger().Warnf("[socks5] %s - %s : %s: %s",
+			conn.RemoteAddr(), conn.LocalAddr(), user, ErrQuotaExceeded)
+		rep := gosocks5.NewReply(gosocks5.NotAllowed, nil)
+		rep.Write(conn)
+		h.options.logger().Debugf("[socks5] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
+		return
 	}
+	conn = h.options.clientConn(conn, selector.principal)
+
+	h.options.logger().Debugf("[socks5] %s -> %s\n%s",
+		conn.RemoteAddr(), conn.LocalAddr(), req)
 	switch req.Cmd {
 	case gosocks5.CmdConnect:
-		h.handleConnect(conn, req)
+		h.handleConnect(conn, req, selector.principal)
 
 	case gosocks5.CmdBind:
-		h.handleBind(conn, req)
+		h.handleBind(conn, req, selector.principal)
 
 	case gosocks5.CmdUdp:
-		h.handleUDPRelay(conn, req)
+		h.handleUDPRelay(conn, req, selector.principal)
 
 	case CmdMuxBind:
-		h.handleMuxBind(conn, req)
+		h.handleMuxBind(conn, req, selector.principal)
 
 	case CmdUDPTun:
-		h.handleUDPTunnel(conn, req)
+		h.handleUDPTunnel(conn, req, selector.principal)
 
 	default:
-		log.Logf("[socks5] %s - %s : Unrecognized request: %d",
+		h.options.logger().Infof("[socks5] %s - %s : Unrecognized request: %d",
 			conn.RemoteAddr(), conn.LocalAddr(), req.Cmd)
 	}
 }
 
-func (h *socks5Handler) handleConnect(conn net.Conn, req *gosocks5.Request) {
+func (h *socks5Handler) handleConnect(conn net.Conn, req *gosocks5.Request, principal *Principal) {
 	host := req.Addr.String()
 
-	log.Logf("[socks5] %s -> %s -> %s",
+	h.options.logger().Infof("[socks5] %s -> %s -> %s",
 		conn.RemoteAddr(), h.options.Node.String(), host)
 
-	if !Can("tcp", host, h.options.Whitelist, h.options.Blacklist) {
-		log.Logf("[socks5] %s - %s : Unauthorized to tcp connect to %s",
+	checked, ok := h.options.can("tcp", host, principal)
+	if !ok {
+		h.options.logger().Warnf("[socks5] %s - %s : Unauthorized to tcp connect to %s",
 			conn.RemoteAddr(), conn.LocalAddr(), host)
 		rep := gosocks5.NewReply(gosocks5.NotAllowed, nil)
 		rep.Write(conn)
-		if Debug {
-			log.Logf("[socks5] %s <- %s\n%s",
-				conn.RemoteAddr(), conn.LocalAddr(), rep)
-		}
+		h.options.logger().Debugf("[socks5] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
 		return
 	}
 	if h.options.Bypass.Contains(host) {
-		log.Logf("[socks5] %s - %s : Bypass %s",
+		h.options.logger().Warnf("[socks5] %s - %s : Bypass %s",
 			conn.RemoteAddr(), conn.LocalAddr(), host)
 		rep := gosocks5.NewReply(gosocks5.NotAllowed, nil)
 		rep.Write(conn)
-		if Debug {
-			log.Logf("[socks5] %s <- %s\n%s",
-				conn.RemoteAddr(), conn.LocalAddr(), rep)
-		}
+		h.options.logger().Debugf("[socks5] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
 		return
 	}
 
@@ -923,9 +935,9 @@ func (h *socks5Handler) handleConnect(conn net.Conn, req *gosocks5.Request) {
 	var cc net.Conn
 	var route *Chain
 	for i := 0; i < retries; i++ {
-		route, err = h.options.Chain.selectRouteFor(host)
+		route, err = h.options.Chain.selectRouteContext(principal.selectContext(conn.RemoteAddr().String(), host))
 		if err != nil {
-			log.Logf("[socks5] %s -> %s : %s",
+			h.options.logger().Errorf("[socks5] %s -> %s : %s",
 				conn.RemoteAddr(), conn.LocalAddr(), err)
 			continue
 		}
@@ -937,72 +949,70 @@ func (h *socks5Handler) handleConnect(conn net.Conn, req *gosocks5.Request) {
 			fmt.Fprintf(&buf, "%d@%s -> ", nd.ID, nd.String())
 		}
 		fmt.Fprintf(&buf, "%s", host)
-		log.Log("[route]", buf.String())
+		h.options.logger().Infof("[route] %s", buf.String())
 
-		cc, err = route.Dial(host,
+		cc, err = route.Dial(dialAddr(route, host, checked),
 			TimeoutChainOption(h.options.Timeout),
 			HostsChainOption(h.options.Hosts),
 			ResolverChainOption(h.options.Resolver),
+			LoggerChainOption(h.options.Logger),
+			RaceChainOption(h.options.Race, h.options.RaceDelay),
 		)
 		if err == nil {
 			break
 		}
-		log.Logf("[socks5] %s -> %s : %s",
+		h.options.logger().Errorf("[socks5] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 	}
 
 	if err != nil {
 		rep := gosocks5.NewReply(gosocks5.HostUnreachable, nil)
 		rep.Write(conn)
-		if Debug {
-			log.Logf("[socks5] %s <- %s\n%s",
-				conn.RemoteAddr(), conn.LocalAddr(), rep)
-		}
+		h.options.logger().Debugf("[socks5] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
 		return
 	}
 	defer cc.Close()
 
 	rep := gosocks5.NewReply(gosocks5.Succeeded, nil)
 	if err := rep.Write(conn); err != nil {
-		log.Logf("[socks5] %s <- %s : %s",
+		h.options.logger().Errorf("[socks5] %s <- %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		return
 	}
-	if Debug {
-		log.Logf("[socks5] %s <- %s\n%s",
-			conn.RemoteAddr(), conn.LocalAddr(), rep)
-	}
-	log.Logf("[socks5] %s <-> %s", conn.RemoteAddr(), host)
-	transport(conn, cc)
-	log.Logf("[socks5] %s >-< %s", conn.RemoteAddr(), host)
+	h.options.logger().Debugf("[socks5] %s <- %s\n%s",
+		conn.RemoteAddr(), conn.LocalAddr(), rep)
+	h.options.logger().Infof("[socks5] %s <-> %s", conn.RemoteAddr(), host)
+	s := h.options.session("socks5", conn, principal.Name(), host, route)
+	s.end(transport(s.wrapConn(conn), cc))
+	h.options.logger().Infof("[socks5] %s >-< %s", conn.RemoteAddr(), host)
 }
 
-func (h *socks5Handler) handleBind(conn net.Conn, req *gosocks5.Request) {
+func (h *socks5Handler) handleBind(conn net.Conn, req *gosocks5.Request, principal *Principal) {
 	addr := req.Addr.String()
 
-	log.Logf("[socks5-bind] %s -> %s -> %s",
+	h.options.logger().Infof("[socks5-bind] %s -> %s -> %s",
 		conn.RemoteAddr(), h.options.Node.String(), addr)
 
 	if h.options.Chain.IsEmpty() {
-		if !Can("rtcp", addr, h.options.Whitelist, h.options.Blacklist) {
-			log.Logf("[socks5-bind] %s - %s : Unauthorized to tcp bind to %s",
+		if _, ok := h.options.can("rtcp", addr, principal); !ok {
+			h.options.logger().Warnf("[socks5-bind] %s - %s : Unauthorized to tcp bind to %s",
 				conn.RemoteAddr(), conn.LocalAddr(), addr)
 			return
 		}
-		h.bindOn(conn, addr)
+		s := h.options.session("socks5-bind", conn, principal.Name(), addr, nil)
+		s.end(h.bindOn(s.wrapConn(conn), addr))
 		return
 	}
 
 	cc, err := h.options.Chain.Conn()
 	if err != nil {
-		log.Logf("[socks5-bind] %s <- %s : %s",
+		h.options.logger().Errorf("[socks5-bind] %s <- %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		reply := gosocks5.NewReply(gosocks5.Failure, nil)
 		reply.Write(conn)
-		if Debug {
-			log.Logf("[socks5-bind] %s <- %s\n%s",
-				conn.RemoteAddr(), conn.LocalAddr(), reply)
-		}
+		h.options.logger().Debugf("[socks5-bind] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), reply)
 		return
 	}
 
@@ -1011,19 +1021,21 @@ func (h *socks5Handler) handleBind(conn net.Conn, req *gosocks5.Request) {
 	// so we don't need to authenticate it, as it's as explicit as whitelisting
 	defer cc.Close()
 	req.Write(cc)
-	log.Logf("[socks5-bind] %s <-> %s", conn.RemoteAddr(), addr)
-	transport(conn, cc)
-	log.Logf("[socks5-bind] %s >-< %s", conn.RemoteAddr(), addr)
+	h.options.logger().Infof("[socks5-bind] %s <-> %s", conn.RemoteAddr(), addr)
+	s := h.options.session("socks5-bind", conn, principal.Name(), addr, nil)
+	s.end(transport(s.wrapConn(conn), cc))
+	h.options.logger().Infof("[socks5-bind] %s >-< %s", conn.RemoteAddr(), addr)
 }
 
-func (h *socks5Handler) bindOn(conn net.Conn, addr string) {
+// bindOn binds on the address addr for the client conn, it returns the error which ends the binding.
+func (h *socks5Handler) bindOn(conn net.Conn, addr string) error {
 	bindAddr, _ := net.ResolveTCPAddr("tcp", addr)
 	ln, err := net.ListenTCP("tcp", bindAddr) // strict mode: if the port already in use, it will return error
 	if err != nil {
-		log.Logf("[socks5-bind] %s -> %s : %s",
+		h.options.logger().Errorf("[socks5-bind] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		gosocks5.NewReply(gosocks5.Failure, nil).Write(conn)
-		return
+		return err
 	}
 
 	socksAddr := toSocksAddr(ln.Addr())
@@ -1031,16 +1043,14 @@ func (h *socks5Handler) bindOn(conn net.Conn, addr string) {
 	socksAddr.Host, _, _ = net.SplitHostPort(conn.LocalAddr().String())
 	reply := gosocks5.NewReply(gosocks5.Succeeded, socksAddr)
 	if err := reply.Write(conn); err != nil {
-		log.Logf("[socks5-bind] %s <- %s : %s",
+		h.options.logger().Errorf("[socks5-bind] %s <- %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		ln.Close()
-		return
-	}
-	if Debug {
-		log.Logf("[socks5-bind] %s <- %s\n%s",
-			conn.RemoteAddr(), conn.LocalAddr(), reply)
+		return err
 	}
-	log.Logf("[socks5-bind] %s - %s BIND ON %s OK",
+	h.options.logger().Debugf("[socks5-bind] %s <- %s\n%s",
+		conn.RemoteAddr(), conn.LocalAddr(), reply)
+	h.options.logger().Infof("[socks5-bind] %s - %s BIND ON %s OK",
 		conn.RemoteAddr(), conn.LocalAddr(), socksAddr)
 
 	var pconn net.Conn
@@ -1082,56 +1092,50 @@ func (h *socks5Handler) bindOn(conn net.Conn, addr string) {
 		select {
 		case err := <-accept():
 			if err != nil || pconn == nil {
-				log.Logf("[socks5-bind] %s <- %s : %v", conn.RemoteAddr(), addr, err)
-				return
+				h.options.logger().Errorf("[socks5-bind] %s <- %s : %v", conn.RemoteAddr(), addr, err)
+				return err
 			}
 			defer pconn.Close()
 
 			reply := gosocks5.NewReply(gosocks5.Succeeded, toSocksAddr(pconn.RemoteAddr()))
 			if err := reply.Write(pc2); err != nil {
-				log.Logf("[socks5-bind] %s <- %s : %v", conn.RemoteAddr(), addr, err)
-			}
-			if Debug {
-				log.Logf("[socks5-bind] %s <- %s\n%s", conn.RemoteAddr(), addr, reply)
+				h.options.logger().Errorf("[socks5-bind] %s <- %s : %v", conn.RemoteAddr(), addr, err)
 			}
-			log.Logf("[socks5-bind] %s <- %s PEER %s ACCEPTED", conn.RemoteAddr(), socksAddr, pconn.RemoteAddr())
+			h.options.logger().Debugf("[socks5-bind] %s <- %s\n%s", conn.RemoteAddr(), addr, reply)
+			h.options.logger().Infof("[socks5-bind] %s <- %s PEER %s ACCEPTED", conn.RemoteAddr(), socksAddr, pconn.RemoteAddr())
 
-			log.Logf("[socks5-bind] %s <-> %s", conn.RemoteAddr(), pconn.RemoteAddr())
+			h.options.logger().Infof("[socks5-bind] %s <-> %s", conn.RemoteAddr(), pconn.RemoteAddr())
 			if err = transport(pc2, pconn); err != nil {
-				log.Logf("[socks5-bind] %s - %s : %v", conn.RemoteAddr(), pconn.RemoteAddr(), err)
+				h.options.logger().Errorf("[socks5-bind] %s - %s : %v", conn.RemoteAddr(), pconn.RemoteAddr(), err)
 			}
-			log.Logf("[socks5-bind] %s >-< %s", conn.RemoteAddr(), pconn.RemoteAddr())
-			return
+			h.options.logger().Infof("[socks5-bind] %s >-< %s", conn.RemoteAddr(), pconn.RemoteAddr())
+			return err
 		case err := <-pipe():
 			if err != nil {
-				log.Logf("[socks5-bind] %s -> %s : %v", conn.RemoteAddr(), addr, err)
+				h.options.logger().Errorf("[socks5-bind] %s -> %s : %v", conn.RemoteAddr(), addr, err)
 			}
 			ln.Close()
-			return
+			return err
 		}
 	}
 }
 
-func (h *socks5Handler) handleUDPRelay(conn net.Conn, req *gosocks5.Request) {
+func (h *socks5Handler) handleUDPRelay(conn net.Conn, req *gosocks5.Request, principal *Principal) {
 	addr := req.Addr.String()
-	if !Can("udp", addr, h.options.Whitelist, h.options.Blacklist) {
-		log.Logf("[socks5-udp] Unauthorized to udp connect to %s", addr)
+	if _, ok := h.options.can("udp", addr, principal); !ok {
+		h.options.logger().Warnf("[socks5-udp] Unauthorized to udp connect to %s", addr)
 		rep := gosocks5.NewReply(gosocks5.NotAllowed, nil)
 		rep.Write(conn)
-		if Debug {
-			log.Logf("[socks5-udp] %s <- %s\n%s", conn.RemoteAddr(), req.Addr, rep)
-		}
+		h.options.logger().Debugf("[socks5-udp] %s <- %s\n%s", conn.RemoteAddr(), req.Addr, rep)
 		return
 	}
 
 	relay, err := net.ListenUDP("udp", nil)
 	if err != nil {
-		log.Logf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+		h.options.logger().Errorf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 		reply := gosocks5.NewReply(gosocks5.Failure, nil)
 		reply.Write(conn)
-		if Debug {
-			log.Logf("[socks5-udp] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), reply)
-		}
+		h.options.logger().Debugf("[socks5-udp] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), reply)
 		return
 	}
 	defer relay.Close()
@@ -1140,29 +1144,30 @@ func (h *socks5Handler) handleUDPRelay(conn net.Conn, req *gosocks5.Request) {
 	socksAddr.Host, _, _ = net.SplitHostPort(conn.LocalAddr().String()) // replace the IP to the out-going interface's
 	reply := gosocks5.NewReply(gosocks5.Succeeded, socksAddr)
 	if err := reply.Write(conn); err != nil {
-		log.Logf("[socks5-udp] %s <- %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+		h.options.logger().Errorf("[socks5-udp] %s <- %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 		return
 	}
-	if Debug {
-		log.Logf("[socks5-udp] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), reply)
-	}
-	log.Logf("[socks5-udp] %s - %s BIND ON %s OK", conn.RemoteAddr(), conn.LocalAddr(), socksAddr)
+	h.options.logger().Debugf("[socks5-udp] %s <- %s\n%s", conn.RemoteAddr(), conn.LocalAddr(), reply)
+	h.options.logger().Infof("[socks5-udp] %s - %s BIND ON %s OK", conn.RemoteAddr(), conn.LocalAddr(), socksAddr)
 
 	// serve as standard socks5 udp relay local <-> remote
 	if h.options.Chain.IsEmpty() {
 		peer, er := net.ListenUDP("udp", nil)
 		if er != nil {
-			log.Logf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), er)
+			h.options.logger().Errorf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), conn.LocalAddr(), er)
 			return
 		}
 		defer peer.Close()
 
 		go h.transportUDP(relay, peer)
-		log.Logf("[socks5-udp] %s <-> %s : associated on %s", conn.RemoteAddr(), conn.LocalAddr(), socksAddr)
-		if err := h.discardClientData(conn); err != nil {
-			log.Logf("[socks5-udp] %s - %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
+		h.options.logger().Infof("[socks5-udp] %s <-> %s : associated on %s", conn.RemoteAddr(), conn.LocalAddr(), socksAddr)
+		s := h.options.session("socks5-udp", conn, principal.Name(), addr, nil)
+		err := h.discardClientData(s.wrapConn(conn))
+		s.end(err)
+		if err != nil {
+			h.options.logger().Errorf("[socks5-udp] %s - %s : %s", conn.RemoteAddr(), conn.LocalAddr(), err)
 		}
-		log.Logf("[socks5-udp] %s >-< %s : associated on %s", conn.RemoteAddr(), conn.LocalAddr(), socksAddr)
+		h.options.logger().Infof("[socks5-udp] %s >-< %s : associated on %s", conn.RemoteAddr(), conn.LocalAddr(), socksAddr)
 		return
 	}
 
@@ -1170,50 +1175,49 @@ func (h *socks5Handler) handleUDPRelay(conn net.Conn, req *gosocks5.Request) {
 	cc, err := h.options.Chain.Conn()
 	// connection error
 	if err != nil {
-		log.Logf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), socksAddr, err)
+		h.options.logger().Errorf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), socksAddr, err)
 		return
 	}
 	defer cc.Close()
 
 	cc, err = socks5Handshake(cc, userSocks5HandshakeOption(h.options.Chain.LastNode().User))
 	if err != nil {
-		log.Logf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), socksAddr, err)
+		h.options.logger().Errorf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), socksAddr, err)
 		return
 	}
 
 	cc.SetWriteDeadline(time.Now().Add(WriteTimeout))
 	r := gosocks5.NewRequest(CmdUDPTun, nil)
 	if err := r.Write(cc); err != nil {
-		log.Logf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), cc.RemoteAddr(), err)
+		h.options.logger().Errorf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), cc.RemoteAddr(), err)
 		return
 	}
 	cc.SetWriteDeadline(time.Time{})
-	if Debug {
-		log.Logf("[socks5-udp] %s -> %s\n%s", conn.RemoteAddr(), cc.RemoteAddr(), r)
-	}
+	h.options.logger().Debugf("[socks5-udp] %s -> %s\n%s", conn.RemoteAddr(), cc.RemoteAddr(), r)
 	cc.SetReadDeadline(time.Now().Add(ReadTimeout))
 	reply, err = gosocks5.ReadReply(cc)
 	if err != nil {
-		log.Logf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), cc.RemoteAddr(), err)
+		h.options.logger().Errorf("[socks5-udp] %s -> %s : %s", conn.RemoteAddr(), cc.RemoteAddr(), err)
 		return
 	}
-	if Debug {
-		log.Logf("[socks5-udp] %s <- %s\n%s", conn.RemoteAddr(), cc.RemoteAddr(), reply)
-	}
+	h.options.logger().Debugf("[socks5-udp] %s <- %s\n%s", conn.RemoteAddr(), cc.RemoteAddr(), reply)
 
 	if reply.Rep != gosocks5.Succeeded {
-		log.Logf("[socks5-udp] %s <- %s : udp associate failed", conn.RemoteAddr(), cc.RemoteAddr())
+		h.options.logger().Infof("[socks5-udp] %s <- %s : udp associate failed", conn.RemoteAddr(), cc.RemoteAddr())
 		return
 	}
 	cc.SetReadDeadline(time.Time{})
-	log.Logf("[socks5-udp] %s <-> %s [tun: %s]", conn.RemoteAddr(), socksAddr, reply.Addr)
+	h.options.logger().Infof("[socks5-udp] %s <-> %s [tun: %s]", conn.RemoteAddr(), socksAddr, reply.Addr)
 
 	go h.tunnelClientUDP(relay, cc)
-	log.Logf("[socks5-udp] %s <-> %s", conn.RemoteAddr(), socksAddr)
-	if err := h.discardClientData(conn); err != nil {
-		log.Logf("[socks5-udp] %s - %s : %s", conn.RemoteAddr(), socksAddr, err)
+	h.options.logger().Infof("[socks5-udp] %s <-> %s", conn.RemoteAddr(), socksAddr)
+	s := h.options.session("socks5-udp", conn, principal.Name(), addr, nil)
+	err = h.discardClientData(s.wrapConn(conn))
+	s.end(err)
+	if err != nil {
+		h.options.logger().Errorf("[socks5-udp] %s - %s : %s", conn.RemoteAddr(), socksAddr, err)
 	}
-	log.Logf("[socks5-udp] %s >-< %s", conn.RemoteAddr(), socksAddr)
+	h.options.logger().Infof("[socks5-udp] %s >-< %s", conn.RemoteAddr(), socksAddr)
 }
 
 func (h *socks5Handler) discardClientData(conn net.Conn) (err error) {
@@ -1227,7 +1231,7 @@ func (h *socks5Handler) discardClientData(conn net.Conn) (err error) {
 			}
 			break // client disconnected
 		}
-		log.Logf("[socks5-udp] read %d UNEXPECTED TCP data from client", n)
+		h.options.logger().Infof("[socks5-udp] read %d UNEXPECTED TCP data from client", n)
 	}
 	return
 }
@@ -1261,16 +1265,14 @@ func (h *socks5Handler) transportUDP(relay, peer net.PacketConn) (err error) {
 				continue // drop silently
 			}
 			if h.options.Bypass.Contains(raddr.String()) {
-				log.Log("[socks5-udp] [bypass] write to", raddr)
+				h.options.logger().Warnf("[socks5-udp] [bypass] write to %s", raddr)
 				continue // bypass
 			}
 			if _, err := peer.WriteTo(dgram.Data, raddr); err != nil {
 				errc <- err
 				return
 			}
-			if Debug {
-				log.Logf("[socks5-udp] %s >>> %s length: %d", relay.LocalAddr(), raddr, len(dgram.Data))
-			}
+			h.options.logger().Debugf("[socks5-udp] %s >>> %s length: %d", relay.LocalAddr(), raddr, len(dgram.Data))
 		}
 	}()
 
@@ -1288,7 +1290,7 @@ func (h *socks5Handler) transportUDP(relay, peer net.PacketConn) (err error) {
 				continue
 			}
 			if h.options.Bypass.Contains(raddr.String()) {
-				log.Log("[socks5-udp] [bypass] read from", raddr)
+				h.options.logger().Warnf("[socks5-udp] [bypass] read from %s", raddr)
 				continue // bypass
 			}
 			buf := bytes.Buffer{}
@@ -1298,9 +1300,7 @@ func (h *socks5Handler) transportUDP(relay, peer net.PacketConn) (err error) {
 				errc <- err
 				return
 			}
-			if Debug {
-				log.Logf("[socks5-udp] %s <<< %s length: %d", relay.LocalAddr(), raddr, len(dgram.Data))
-			}
+			h.options.logger().Debugf("[socks5-udp] %s <<< %s length: %d", relay.LocalAddr(), raddr, len(dgram.Data))
 		}
 	}()
 
@@ -1324,7 +1324,7 @@ func (h *socks5Handler) tunnelClientUDP(uc *net.UDPConn, cc net.Conn) (err error
 		for {
 			n, addr, err := uc.ReadFromUDP(b)
 			if err != nil {
-				log.Logf("[udp-tun] %s <- %s : %s", cc.RemoteAddr(), addr, err)
+				h.options.logger().Errorf("[udp-tun] %s <- %s : %s", cc.RemoteAddr(), addr, err)
 				errc <- err
 				return
 			}
@@ -1341,7 +1341,7 @@ func (h *socks5Handler) tunnelClientUDP(uc *net.UDPConn, cc net.Conn) (err error
 			}
 			raddr := dgram.Header.Addr.String()
 			if h.options.Bypass.Contains(raddr) {
-				log.Log("[udp-tun] [bypass] write to", raddr)
+				h.options.logger().Warnf("[udp-tun] [bypass] write to %s", raddr)
 				continue // bypass
 			}
 			dgram.Header.Rsv = uint16(len(dgram.Data))
@@ -1349,9 +1349,7 @@ func (h *socks5Handler) tunnelClientUDP(uc *net.UDPConn, cc net.Conn) (err error
 				errc <- err
 				return
 			}
-			if Debug {
-				log.Logf("[udp-tun] %s >>> %s length: %d", uc.LocalAddr(), dgram.Header.Addr, len(dgram.Data))
-			}
+			h.options.logger().Debugf("[udp-tun] %s >>> %s length: %d", uc.LocalAddr(), dgram.Header.Addr, len(dgram.Data))
 		}
 	}()
 
@@ -1359,7 +1357,7 @@ func (h *socks5Handler) tunnelClientUDP(uc *net.UDPConn, cc net.Conn) (err error
 		for {
 			dgram, err := gosocks5.ReadUDPDatagram(cc)
 			if err != nil {
-				log.Logf("[udp-tun] %s -> 0 : %s", cc.RemoteAddr(), err)
+				h.options.logger().Errorf("[udp-tun] %s -> 0 : %s", cc.RemoteAddr(), err)
 				errc <- err
 				return
 			}
@@ -1370,7 +1368,7 @@ func (h *socks5Handler) tunnelClientUDP(uc *net.UDPConn, cc net.Conn) (err error
 			}
 			raddr := dgram.Header.Addr.String()
 			if h.options.Bypass.Contains(raddr) {
-				log.Log("[udp-tun] [bypass] read from", raddr)
+				h.options.logger().Warnf("[udp-tun] [bypass] read from %s", raddr)
 				continue // bypass
 			}
 			dgram.Header.Rsv = 0
@@ -1381,9 +1379,7 @@ func (h *socks5Handler) tunnelClientUDP(uc *net.UDPConn, cc net.Conn) (err error
 				errc <- err
 				return
 			}
-			if Debug {
-				log.Logf("[udp-tun] %s <<< %s length: %d", uc.LocalAddr(), dgram.Header.Addr, len(dgram.Data))
-			}
+			h.options.logger().Debugf("[udp-tun] %s <<< %s length: %d", uc.LocalAddr(), dgram.Header.Addr, len(dgram.Data))
 		}
 	}()
 
@@ -1394,20 +1390,20 @@ func (h *socks5Handler) tunnelClientUDP(uc *net.UDPConn, cc net.Conn) (err error
 	return
 }
 
-func (h *socks5Handler) handleUDPTunnel(conn net.Conn, req *gosocks5.Request) {
+func (h *socks5Handler) handleUDPTunnel(conn net.Conn, req *gosocks5.Request, principal *Principal) {
 	// serve tunnel udp, tunnel <-> remote, handle tunnel udp request
 	if h.options.Chain.IsEmpty() {
 		addr := req.Addr.String()
 
-		if !Can("rudp", addr, h.options.Whitelist, h.options.Blacklist) {
-			log.Logf("[socks5] udp-tun Unauthorized to udp bind to %s", addr)
+		if _, ok := h.options.can("rudp", addr, principal); !ok {
+			h.options.logger().Warnf("[socks5] udp-tun Unauthorized to udp bind to %s", addr)
 			return
 		}
 
 		bindAddr, _ := net.ResolveUDPAddr("udp", addr)
 		uc, err := net.ListenUDP("udp", bindAddr)
 		if err != nil {
-			log.Logf("[socks5] udp-tun %s -> %s : %s", conn.RemoteAddr(), req.Addr, err)
+			h.options.logger().Errorf("[socks5] udp-tun %s -> %s : %s", conn.RemoteAddr(), req.Addr, err)
 			return
 		}
 		defer uc.Close()
@@ -1416,32 +1412,31 @@ func (h *socks5Handler) handleUDPTunnel(conn net.Conn, req *gosocks5.Request) {
 		socksAddr.Host, _, _ = net.SplitHostPort(conn.LocalAddr().String())
 		reply := gosocks5.NewReply(gosocks5.Succeeded, socksAddr)
 		if err := reply.Write(conn); err != nil {
-			log.Logf("[socks5] udp-tun %s <- %s : %s", conn.RemoteAddr(), socksAddr, err)
+			h.options.logger().Errorf("[socks5] udp-tun %s <- %s : %s", conn.RemoteAddr(), socksAddr, err)
 			return
 		}
-		if Debug {
-			log.Logf("[socks5] udp-tun %s <- %s\n%s", conn.RemoteAddr(), socksAddr, reply)
-		}
-		log.Logf("[socks5] udp-tun %s <-> %s", conn.RemoteAddr(), socksAddr)
-		h.tunnelServerUDP(conn, uc)
-		log.Logf("[socks5] udp-tun %s >-< %s", conn.RemoteAddr(), socksAddr)
+		h.options.logger().Debugf("[socks5] udp-tun %s <- %s\n%s", conn.RemoteAddr(), socksAddr, reply)
+		h.options.logger().Infof("[socks5] udp-tun %s <-> %s", conn.RemoteAddr(), socksAddr)
+		s := h.options.session("socks5-udp-tun", conn, principal.Name(), addr, nil)
+		s.end(h.tunnelServerUDP(s.wrapConn(conn), uc))
+		h.options.logger().Infof("[socks5] udp-tun %s >-< %s", conn.RemoteAddr(), socksAddr)
 		return
 	}
 
 	cc, err := h.options.Chain.Conn()
 	// connection error
 	if err != nil {
-		log.Logf("[socks5] udp-tun %s -> %s : %s", conn.RemoteAddr(), req.Addr, err)
+		h.options.logger().Errorf("[socks5] udp-tun %s -> %s : %s", conn.RemoteAddr(), req.Addr, err)
 		reply := gosocks5.NewReply(gosocks5.Failure, nil)
 		reply.Write(conn)
-		log.Logf("[socks5] udp-tun %s -> %s\n%s", conn.RemoteAddr(), req.Addr, reply)
+		h.options.logger().Infof("[socks5] udp-tun %s -> %s\n%s", conn.RemoteAddr(), req.Addr, reply)
 		return
 	}
 	defer cc.Close()
 
 	cc, err = socks5Handshake(cc, userSocks5HandshakeOption(h.options.Chain.LastNode().User))
 	if err != nil {
-		log.Logf("[socks5] udp-tun %s -> %s : %s", conn.RemoteAddr(), req.Addr, err)
+		h.options.logger().Errorf("[socks5] udp-tun %s -> %s : %s", conn.RemoteAddr(), req.Addr, err)
 		return
 	}
 	// tunnel <-> tunnel, direct forwarding
@@ -1449,9 +1444,10 @@ func (h *socks5Handler) handleUDPTunnel(conn net.Conn, req *gosocks5.Request) {
 	// so we don't need to authenticate it, as it's as explicit as whitelisting
 	req.Write(cc)
 
-	log.Logf("[socks5] udp-tun %s <-> %s", conn.RemoteAddr(), cc.RemoteAddr())
-	transport(conn, cc)
-	log.Logf("[socks5] udp-tun %s >-< %s", conn.RemoteAddr(), cc.RemoteAddr())
+	h.options.logger().Infof("[socks5] udp-tun %s <-> %s", conn.RemoteAddr(), cc.RemoteAddr())
+	s := h.options.session("socks5-udp-tun", conn, principal.Name(), req.Addr.String(), nil)
+	s.end(transport(s.wrapConn(conn), cc))
+	h.options.logger().Infof("[socks5] udp-tun %s >-< %s", conn.RemoteAddr(), cc.RemoteAddr())
 }
 
 func (h *socks5Handler) tunnelServerUDP(cc net.Conn, pc net.PacketConn) (err error) {
@@ -1464,12 +1460,12 @@ func (h *socks5Handler) tunnelServerUDP(cc net.Conn, pc net.PacketConn) (err err
 		for {
 			n, addr, err := pc.ReadFrom(b)
 			if err != nil {
-				// log.Logf("[udp-tun] %s : %s", cc.RemoteAddr(), err)
+				// h.options.logger().Errorf("[udp-tun] %s : %s", cc.RemoteAddr(), err)
 				errc <- err
 				return
 			}
 			if h.options.Bypass.Contains(addr.String()) {
-				log.Log("[socks5] udp-tun bypass read from", addr)
+				h.options.logger().Warnf("[socks5] udp-tun bypass read from %s", addr)
 				continue // bypass
 			}
 
@@ -1477,13 +1473,11 @@ func (h *socks5Handler) tunnelServerUDP(cc net.Conn, pc net.PacketConn) (err err
 			dgram := gosocks5.NewUDPDatagram(
 				gosocks5.NewUDPHeader(uint16(n), 0, toSocksAddr(addr)), b[:n])
 			if err := dgram.Write(cc); err != nil {
-				log.Logf("[socks5] udp-tun %s <- %s : %s", cc.RemoteAddr(), dgram.Header.Addr, err)
+				h.options.logger().Errorf("[socks5] udp-tun %s <- %s : %s", cc.RemoteAddr(), dgram.Header.Addr, err)
 				errc <- err
 				return
 			}
-			if Debug {
-				log.Logf("[socks5] udp-tun %s <<< %s length: %d", cc.RemoteAddr(), dgram.Header.Addr, len(dgram.Data))
-			}
+			h.options.logger().Debugf("[socks5] udp-tun %s <<< %s length: %d", cc.RemoteAddr(), dgram.Header.Addr, len(dgram.Data))
 		}
 	}()
 
@@ -1491,7 +1485,7 @@ func (h *socks5Handler) tunnelServerUDP(cc net.Conn, pc net.PacketConn) (err err
 		for {
 			dgram, err := gosocks5.ReadUDPDatagram(cc)
 			if err != nil {
-				// log.Logf("[udp-tun] %s -> 0 : %s", cc.RemoteAddr(), err)
+				// h.options.logger().Errorf("[udp-tun] %s -> 0 : %s", cc.RemoteAddr(), err)
 				errc <- err
 				return
 			}
@@ -1502,17 +1496,15 @@ func (h *socks5Handler) tunnelServerUDP(cc net.Conn, pc net.PacketConn) (err err
 				continue // drop silently
 			}
 			if h.options.Bypass.Contains(addr.String()) {
-				log.Log("[socks5] udp-tun bypass write to", addr)
+				h.options.logger().Warnf("[socks5] udp-tun bypass write to %s", addr)
 				continue // bypass
 			}
 			if _, err := pc.WriteTo(dgram.Data, addr); err != nil {
-				log.Logf("[socks5] udp-tun %s -> %s : %s", cc.RemoteAddr(), addr, err)
+				h.options.logger().Errorf("[socks5] udp-tun %s -> %s : %s", cc.RemoteAddr(), addr, err)
 				errc <- err
 				return
 			}
-			if Debug {
-				log.Logf("[socks5] udp-tun %s >>> %s length: %d", cc.RemoteAddr(), addr, len(dgram.Data))
-			}
+			h.options.logger().Debugf("[socks5] udp-tun %s >>> %s length: %d", cc.RemoteAddr(), addr, len(dgram.Data))
 		}
 	}()
 
@@ -1523,25 +1515,24 @@ func (h *socks5Handler) tunnelServerUDP(cc net.Conn, pc net.PacketConn) (err err
 	return
 }
 
-func (h *socks5Handler) handleMuxBind(conn net.Conn, req *gosocks5.Request) {
+func (h *socks5Handler) handleMuxBind(conn net.Conn, req *gosocks5.Request, principal *Principal) {
 	if h.options.Chain.IsEmpty() {
 		addr := req.Addr.String()
-		if !Can("rtcp", addr, h.options.Whitelist, h.options.Blacklist) {
-			log.Logf("Unauthorized to tcp mbind to %s", addr)
+		if _, ok := h.options.can("rtcp", addr, principal); !ok {
+			h.options.logger().Warnf("Unauthorized to tcp mbind to %s", addr)
 			return
 		}
-		h.muxBindOn(conn, addr)
+		s := h.options.session("socks5-mbind", conn, principal.Name(), addr, nil)
+		s.end(h.muxBindOn(s.wrapConn(conn), addr))
 		return
 	}
 
 	cc, err := h.options.Chain.Conn()
 	if err != nil {
-		log.Logf("[socks5] mbind %s <- %s : %s", conn.RemoteAddr(), req.Addr, err)
+		h.options.logger().Errorf("[socks5] mbind %s <- %s : %s", conn.RemoteAddr(), req.Addr, err)
 		reply := gosocks5.NewReply(gosocks5.Failure, nil)
 		reply.Write(conn)
-		if Debug {
-			log.Logf("[socks5] mbind %s <- %s\n%s", conn.RemoteAddr(), req.Addr, reply)
-		}
+		h.options.logger().Debugf("[socks5] mbind %s <- %s\n%s", conn.RemoteAddr(), req.Addr, reply)
 		return
 	}
 
@@ -1550,18 +1541,21 @@ func (h *socks5Handler) handleMuxBind(conn net.Conn, req *gosocks5.Request) {
 	// so we don't need to authenticate it, as it's as explicit as whitelisting.
 	defer cc.Close()
 	req.Write(cc)
-	log.Logf("[socks5] mbind %s <-> %s", conn.RemoteAddr(), cc.RemoteAddr())
-	transport(conn, cc)
-	log.Logf("[socks5] mbind %s >-< %s", conn.RemoteAddr(), cc.RemoteAddr())
+	h.options.logger().Infof("[socks5] mbind %s <-> %s", conn.RemoteAddr(), cc.RemoteAddr())
+	s := h.options.session("socks5-mbind", conn, principal.Name(), req.Addr.String(), nil)
+	s.end(transport(s.wrapConn(conn), cc))
+	h.options.logger().Infof("[socks5] mbind %s >-< %s", conn.RemoteAddr(), cc.RemoteAddr())
 }
 
-func (h *socks5Handler) muxBindOn(conn net.Conn, addr string) {
+// muxBindOn binds on the address addr for the client conn, it returns the error if the binding fails,
+// the binding is ended by closing the client conn.
+func (h *socks5Handler) muxBindOn(conn net.Conn, addr string) error {
 	bindAddr, _ := net.ResolveTCPAddr("tcp", addr)
 	ln, err := net.ListenTCP("tcp", bindAddr) // strict mode: if the port already in use, it will return error
 	if err != nil {
-		log.Logf("[socks5] mbind %s -> %s : %s", conn.RemoteAddr(), addr, err)
+		h.options.logger().Errorf("[socks5] mbind %s -> %s : %s", conn.RemoteAddr(), addr, err)
 		gosocks5.NewReply(gosocks5.Failure, nil).Write(conn)
-		return
+		return err
 	}
 	defer ln.Close()
 
@@ -1570,23 +1564,21 @@ func (h *socks5Handler) muxBindOn(conn net.Conn, addr string) {
 	socksAddr.Host, _, _ = net.SplitHostPort(conn.LocalAddr().String())
 	reply := gosocks5.NewReply(gosocks5.Succeeded, socksAddr)
 	if err := reply.Write(conn); err != nil {
-		log.Logf("[socks5] mbind %s <- %s : %s", conn.RemoteAddr(), addr, err)
-		return
+		h.options.logger().Errorf("[socks5] mbind %s <- %s : %s", conn.RemoteAddr(), addr, err)
+		return err
 	}
-	if Debug {
-		log.Logf("[socks5] mbind %s <- %s\n%s", conn.RemoteAddr(), addr, reply)
-	}
-	log.Logf("[socks5] mbind %s - %s BIND ON %s OK", conn.RemoteAddr(), addr, socksAddr)
+	h.options.logger().Debugf("[socks5] mbind %s <- %s\n%s", conn.RemoteAddr(), addr, reply)
+	h.options.logger().Infof("[socks5] mbind %s - %s BIND ON %s OK", conn.RemoteAddr(), addr, socksAddr)
 
 	// Upgrade connection to multiplex stream.
 	s, err := smux.Client(conn, smux.DefaultConfig())
 	if err != nil {
-		log.Logf("[socks5] mbind %s - %s : %s", conn.RemoteAddr(), socksAddr, err)
-		return
+		h.options.logger().Errorf("[socks5] mbind %s - %s : %s", conn.RemoteAddr(), socksAddr, err)
+		return err
 	}
 
-	log.Logf("[socks5] mbind %s <-> %s", conn.RemoteAddr(), socksAddr)
-	defer log.Logf("[socks5] mbind %s >-< %s", conn.RemoteAddr(), socksAddr)
+	h.options.logger().Infof("[socks5] mbind %s <-> %s", conn.RemoteAddr(), socksAddr)
+	defer h.options.logger().Infof("[socks5] mbind %s >-< %s", conn.RemoteAddr(), socksAddr)
 
 	session := &muxSession{
 		conn:    conn,
@@ -1598,7 +1590,7 @@ func (h *socks5Handler) muxBindOn(conn net.Conn, addr string) {
 		for {
 			conn, err := session.Accept()
 			if err != nil {
-				log.Logf("[socks5] mbind accept : %v", err)
+				h.options.logger().Errorf("[socks5] mbind accept : %v", err)
 				ln.Close()
 				return
 			}
@@ -1609,10 +1601,10 @@ func (h *socks5Handler) muxBindOn(conn net.Conn, addr string) {
 	for {
 		cc, err := ln.Accept()
 		if err != nil {
-			log.Logf("[socks5] mbind %s <- %s : %v", conn.RemoteAddr(), socksAddr, err)
-			return
+			h.options.logger().Errorf("[socks5] mbind %s <- %s : %v", conn.RemoteAddr(), socksAddr, err)
+			return nil // the listener is closed with the mux session
 		}
-		log.Logf("[socks5] mbind %s <- %s : ACCEPT peer %s",
+		h.options.logger().Infof("[socks5] mbind %s <- %s : ACCEPT peer %s",
 			conn.RemoteAddr(), socksAddr, cc.RemoteAddr())
 
 		go func(c net.Conn) {
@@ -1620,7 +1612,7 @@ func (h *socks5Handler) muxBindOn(conn net.Conn, addr string) {
 
 			sc, err := session.GetConn()
 			if err != nil {
-				log.Logf("[socks5] mbind %s <- %s : %s", conn.RemoteAddr(), socksAddr, err)
+				h.options.logger().Errorf("[socks5] mbind %s <- %s : %s", conn.RemoteAddr(), socksAddr, err)
 				return
 			}
 			defer sc.Close()
@@ -1673,56 +1665,73 @@ func (h *socks4Handler) Handle(conn net.Conn) {
 
 	req, err := gosocks4.ReadRequest(conn)
 	if err != nil {
-		log.Logf("[socks4] %s -> %s : %s",
+		h.options.logger().Errorf("[socks4] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		return
 	}
 
-	if Debug {
-		log.Logf("[socks4] %s -> %s\n%s",
-			conn.RemoteAddr(), conn.LocalAddr(), req)
+	h.options.logger().Debugf("[socks4] %s -> %s\n%s",
+		conn.RemoteAddr(), conn.LocalAddr(), req)
+
+	// SOCKS4 carries no password, so it is refused if the authentication is required,
+	// the same as SOCKS5 and HTTP without the credentials.
+	if h.options.Authenticator != nil {
+		h.options.logger().Warnf("[socks4] %s - %s : user %s rejected, authentication is required",
+			conn.RemoteAddr(), conn.LocalAddr(), req.Userid)
+		DefaultMetrics.authFailed("socks4")
+		rep := gosocks4.NewReply(gosocks4.RejectedUserid, nil)
+		rep.Write(conn)
+		h.options.logger().Debugf("[socks4] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
+		return
 	}
 
 	switch req.Cmd {
 	case gosocks4.CmdConnect:
-		h.handleConnect(conn, req)
+		h.handleConnect(conn, req, nil)
 
 	case gosocks4.CmdBind:
-		log.Logf("[socks4-bind] %s - %s", conn.RemoteAddr(), req.Addr)
+		h.options.logger().Infof("[socks4-bind] %s - %s", conn.RemoteAddr(), req.Addr)
 		h.handleBind(conn, req)
 
 	default:
-		log.Logf("[socks4] %s - %s : Unrecognized request: %d",
+		h.options.logger().Infof("[socks4] %s - %s : Unrecognized request: %d",
 			conn.RemoteAddr(), conn.LocalAddr(), req.Cmd)
 	}
 }
 
-func (h *socks4Handler) handleConnect(conn net.Conn, req *gosocks4.Request) {
+func (h *socks4Handler) handleConnect(conn net.Conn, req *gosocks4.Request, principal *Principal) {
 	addr := req.Addr.String()
 
-	log.Logf("[socks4] %s -> %s -> %s",
+	h.options.logger().Infof("[socks4] %s -> %s -> %s",
 		conn.RemoteAddr(), h.options.Node.String(), addr)
 
-	if !Can("tcp", addr, h.options.Whitelist, h.options.Blacklist) {
-		log.Logf("[socks4] %s - %s : Unauthorized to tcp connect to %s",
+	checked, ok := h.options.can("tcp", addr, principal)
+	if !ok {
+		h.options.logger().Warnf("[socks4] %s - %s : Unauthorized to tcp connect to %s",
 			conn.RemoteAddr(), conn.LocalAddr(), addr)
 		rep := gosocks4.NewReply(gosocks4.Rejected, nil)
 		rep.Write(conn)
-		if Debug {
-			log.Logf("[socks4] %s <- %s\n%s",
-				conn.RemoteAddr(), conn.LocalAddr(), rep)
-		}
+		h.options.logger().Debugf("[socks4] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
 		return
 	}
 	if h.options.Bypass.Contains(addr) {
-		log.Log("[socks4] %s - %s : Bypass %s",
+		h.options.logger().Warnf("[socks4] %s - %s : Bypass %s",
 			conn.RemoteAddr(), conn.LocalAddr(), addr)
 		rep := gosocks4.NewReply(gosocks4.Rejected, nil)
 		rep.Write(conn)
-		if Debug {
-			log.Logf("[socks4] %s <- %s\n%s",
-				conn.RemoteAddr(), conn.LocalAddr(), rep)
-		}
+		h.options.logger().Debugf("[socks4] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
+		return
+	}
+	if h.options.Traffic.Exceeded(principal.Name()) {
+		h.options.logger().Warnf("[socks4] %s - %s : %s",
+			conn.RemoteAddr(), conn.LocalAddr(), ErrQuotaExceeded)
+		rep := gosocks4.NewReply(gosocks4.Rejected, nil)
+		rep.Write(conn)
+		h.options.logger().Debugf("[socks4] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
 		return
 	}
 
@@ -1738,9 +1747,9 @@ func (h *socks4Handler) handleConnect(conn net.Conn, req *gosocks4.Request) {
 	var cc net.Conn
 	var route *Chain
 	for i := 0; i < retries; i++ {
-		route, err = h.options.Chain.selectRouteFor(addr)
+		route, err = h.options.Chain.selectRouteContext(principal.selectContext(conn.RemoteAddr().String(), addr))
 		if err != nil {
-			log.Logf("[socks4] %s -> %s : %s",
+			h.options.logger().Errorf("[socks4] %s -> %s : %s",
 				conn.RemoteAddr(), conn.LocalAddr(), err)
 			continue
 		}
@@ -1752,45 +1761,44 @@ func (h *socks4Handler) handleConnect(conn net.Conn, req *gosocks4.Request) {
 			fmt.Fprintf(&buf, "%d@%s -> ", nd.ID, nd.String())
 		}
 		fmt.Fprintf(&buf, "%s", addr)
-		log.Log("[route]", buf.String())
+		h.options.logger().Infof("[route] %s", buf.String())
 
-		cc, err = route.Dial(addr,
+		cc, err = route.Dial(dialAddr(route, addr, checked),
 			TimeoutChainOption(h.options.Timeout),
 			HostsChainOption(h.options.Hosts),
 			ResolverChainOption(h.options.Resolver),
+			LoggerChainOption(h.options.Logger),
+			RaceChainOption(h.options.Race, h.options.RaceDelay),
 		)
 		if err == nil {
 			break
 		}
-		log.Logf("[socks4] %s -> %s : %s",
+		h.options.logger().Errorf("[socks4] %s -> %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 	}
 
 	if err != nil {
 		rep := gosocks4.NewReply(gosocks4.Failed, nil)
 		rep.Write(conn)
-		if Debug {
-			log.Logf("[socks4] %s <- %s\n%s",
-				conn.RemoteAddr(), conn.LocalAddr(), rep)
-		}
+		h.options.logger().Debugf("[socks4] %s <- %s\n%s",
+			conn.RemoteAddr(), conn.LocalAddr(), rep)
 		return
 	}
 	defer cc.Close()
 
 	rep := gosocks4.NewReply(gosocks4.Granted, nil)
 	if err := rep.Write(conn); err != nil {
-		log.Logf("[socks4] %s <- %s : %s",
+		h.options.logger().Errorf("[socks4] %s <- %s : %s",
 			conn.RemoteAddr(), conn.LocalAddr(), err)
 		return
 	}
-	if Debug {
-		log.Logf("[socks4] %s <- %s\n%s",
-			conn.RemoteAddr(), conn.LocalAddr(), rep)
-	}
+	h.options.logger().Debugf("[socks4] %s <- %s\n%s",
+		conn.RemoteAddr(), conn.LocalAddr(), rep)
 
-	log.Logf("[socks4] %s <-> %s", conn.RemoteAddr(), addr)
-	transport(conn, cc)
-	log.Logf("[socks4] %s >-< %s", conn.RemoteAddr(), addr)
+	h.options.logger().Infof("[socks4] %s <-> %s", conn.RemoteAddr(), addr)
+	s := h.options.session("socks4", conn, principal.Name(), addr, route)
+	s.end(transport(s.wrapConn(h.options.clientConn(conn, principal)), cc))
+	h.options.logger().Infof("[socks4] %s >-< %s", conn.RemoteAddr(), addr)
 }
 
 func (h *socks4Handler) handleBind(conn net.Conn, req *gosocks4.Request) {
@@ -1798,21 +1806,17 @@ func (h *socks4Handler) handleBind(conn net.Conn, req *gosocks4.Request) {
 	if h.options.Chain.IsEmpty() {
 		reply := gosocks4.NewReply(gosocks4.Rejected, nil)
 		reply.Write(conn)
-		if Debug {
-			log.Logf("[socks4-bind] %s <- %s\n%s", conn.RemoteAddr(), req.Addr, reply)
-		}
+		h.options.logger().Debugf("[socks4-bind] %s <- %s\n%s", conn.RemoteAddr(), req.Addr, reply)
 		return
 	}
 
 	cc, err := h.options.Chain.Conn()
 	// connection error
 	if err != nil && err != ErrEmptyChain {
-		log.Logf("[socks4-bind] %s <- %s : %s", conn.RemoteAddr(), req.Addr, err)
+		h.options.logger().Errorf("[socks4-bind] %s <- %s : %s", conn.RemoteAddr(), req.Addr, err)
 		reply := gosocks4.NewReply(gosocks4.Failed, nil)
 		reply.Write(conn)
-		if Debug {
-			log.Logf("[socks4-bind] %s <- %s\n%s", conn.RemoteAddr(), req.Addr, reply)
-		}
+		h.options.logger().Debugf("[socks4-bind] %s <- %s\n%s", conn.RemoteAddr(), req.Addr, reply)
 		return
 	}
 
@@ -1820,9 +1824,9 @@ func (h *socks4Handler) handleBind(conn net.Conn, req *gosocks4.Request) {
 	// forward request
 	req.Write(cc)
 
-	log.Logf("[socks4-bind] %s <-> %s", conn.RemoteAddr(), cc.RemoteAddr())
+	h.options.logger().Infof("[socks4-bind] %s <-> %s", conn.RemoteAddr(), cc.RemoteAddr())
 	transport(conn, cc)
-	log.Logf("[socks4-bind] %s >-< %s", conn.RemoteAddr(), cc.RemoteAddr())
+	h.options.logger().Infof("[socks4-bind] %s >-< %s", conn.RemoteAddr(), cc.RemoteAddr())
 }
 
 type socks5HandshakeOptions struct {
@@ -0,0 +1,450 @@
+package gost
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-log/log"
+)
+
+var (
+	// ErrQuotaExceeded is an error that implies the traffic quota of the user is exhausted.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// TrafficStats holds the byte counters of the traffic.
+// The inbound bytes are read from the client (upload),
+// the outbound bytes are written to the client (download).
+type TrafficStats struct {
+	In  uint64 `json:"in"`
+	Out uint64 `json:"out"`
+}
+
+// Total returns the sum of the inbound and outbound bytes.
+func (s TrafficStats) Total() uint64 {
+	return s.In + s.Out
+}
+
+// Quota periods.
+const (
+	QuotaDaily   = "daily"
+	QuotaMonthly = "monthly"
+)
+
+// Quota is the max number of bytes (inbound plus outbound) a user can transfer in a period.
+type Quota struct {
+	Limit  int64
+	Period string // daily or monthly
+}
+
+// start returns the start time of the quota period that t belongs to.
+func (q Quota) start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	if q.Period == QuotaMonthly {
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+type userTraffic struct {
+	in, out uint64 // since the start of the process
+	usage   int64  // since the start of the quota period
+	start   time.Time
+}
+
+func (u *userTraffic) add(in, out int) {
+	atomic.AddUint64(&u.in, uint64(in))
+	atomic.AddUint64(&u.out, uint64(out))
+	atomic.AddInt64(&u.usage, int64(in+out))
+}
+
+// Traffic accounts the traffic of a serve node, in total and per authenticated user,
+// and enforces the optional per user traffic quotas.
+// The unauthenticated clients are accounted as a whole under the user name '-'.
+// The quota usage can be persisted to a local file, so it survives restarts.
+//
+// The quota config file format:
+//
+//	reload 10s             # reload period
+//	*     10GB monthly     # default quota for the users not listed
+//	admin 1GB  daily       # quota for user admin
+//	guest 100MB            # the period is daily by default
+//	-     1GB  daily       # quota for the unauthenticated clients
+type Traffic struct {
+	total   userTraffic
+	users   map[string]*userTraffic
+	quotas  map[string]Quota
+	store   string
+	period  time.Duration
+	prev    *Traffic // the traffic taken over the store from
+	stopped chan struct{}
+	saved   chan struct{} // closed when the usage is saved for the last time
+	saveMux sync.Mutex
+	mux     sync.RWMutex
+}
+
+// NewTraffic creates a Traffic, the quota usage will be loaded from and saved to the store file if it is not empty.
+func NewTraffic(store string) (*Traffic, error) {
+	t := &Traffic{
+		users:   make(map[string]*userTraffic),
+		quotas:  make(map[string]Quota),
+		store:   store,
+		stopped: make(chan struct{}),
+		saved:   make(chan struct{}),
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Stats returns the traffic stats of the user since the start of the process,
+// an empty user means the total traffic of the serve node.
+func (t *Traffic) Stats(user string) (stats TrafficStats) {
+	if t == nil {
+		return
+	}
+
+	u := &t.total
+	if user != "" {
+		t.mux.RLock()
+		u = t.users[user]
+		t.mux.RUnlock()
+	}
+	if u == nil {
+		return
+	}
+	stats.In = atomic.LoadUint64(&u.in)
+	stats.Out = atomic.LoadUint64(&u.out)
+	return
+}
+
+// Users returns the sorted list of the users that have traffic.
+func (t *Traffic) Users() []string {
+	if t == nil {
+		return nil
+	}
+
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	users := make([]string, 0, len(t.users))
+	for user := range t.users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// SetQuota sets the quota for the user, the user '*' means the default quota.
+// A non-positive limit removes the quota.
+func (t *Traffic) SetQuota(user string, quota Quota) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if quota.Limit <= 0 {
+		delete(t.quotas, user)
+		return
+	}
+	t.quotas[user] = quota
+}
+
+func (t *Traffic) quota(user string) (Quota, bool) {
+	q, ok := t.quotas[user]
+	if !ok {
+		q, ok = t.quotas["*"]
+	}
+	return q, ok
+}
+
+// Usage returns the traffic of the user in the current quota period and the quota of the user.
+func (t *Traffic) Usage(user string) (usage int64, quota Quota) {
+	if t == nil {
+		return
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if user == "" {
+		user = "-"
+	}
+	quota, _ = t.quota(user)
+	usage = atomic.LoadInt64(&t.user(user).usage)
+	return
+}
+
+// Exceeded reports whether the user has exhausted the quota.
+func (t *Traffic) Exceeded(user string) bool {
+	usage, quota := t.Usage(user)
+	return quota.Limit > 0 && usage >= quota.Limit
+}
+
+// user returns the traffic of the user, and resets the usage if a new quota period begins.
+// The caller must hold the write lock.
+func (t *Traffic) user(name string) *userTraffic {
+	if name == "" {
+		name = "-"
+	}
+	u := t.users[name]
+	if u == nil {
+		u = &userTraffic{}
+		t.users[name] = u
+	}
+
+	q, _ := t.quota(name)
+	if start := q.start(time.Now()); !u.start.Equal(start) {
+		u.start = start
+		atomic.StoreInt64(&u.usage, 0)
+	}
+	return u
+}
+
+// Reload parses the quota config from r, then live reloads the Traffic.
+func (t *Traffic) Reload(r io.Reader) error {
+	var period time.Duration
+	quotas := make(map[string]Quota)
+
+	if r == nil || t.Stopped() {
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ss := splitLine(scanner.Text())
+		if len(ss) < 2 {
+			continue // invalid lines are ignored
+		}
+
+		switch ss[0] {
+		case "reload": // reload option
+			period, _ = time.ParseDuration(ss[1])
+		default:
+			limit, err := ParseBytes(ss[1])
+			if err != nil {
+				return err
+			}
+			q := Quota{Limit: limit, Period: QuotaDaily}
+			if len(ss) > 2 {
+				switch ss[2] {
+				case QuotaDaily, QuotaMonthly:
+					q.Period = ss[2]
+				default:
+					return fmt.Errorf("invalid quota period: %s", ss[2])
+				}
+			}
+			if q.Limit > 0 {
+				quotas[ss[0]] = q
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.period = period
+	t.quotas = quotas
+
+	return nil
+}
+
+// Period returns the reload period.
+func (t *Traffic) Period() time.Duration {
+	if t.Stopped() {
+		return -1
+	}
+
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	return t.period
+}
+
+// Stop stops reloading and saving, the quota usage is saved for the last time.
+func (t *Traffic) Stop() {
+	select {
+	case <-t.stopped:
+	default:
+		close(t.stopped)
+
+		t.saveMux.Lock()
+		defer t.saveMux.Unlock()
+		if err := t.save(); err != nil {
+			log.Logf("[traffic] %s: %v", t.store, err)
+		}
+		close(t.saved)
+	}
+}
+
+// Stopped checks whether the reloader is stopped.
+func (t *Traffic) Stopped() bool {
+	select {
+	case <-t.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+type trafficRecord struct {
+	Start time.Time `json:"start"`
+	Usage int64     `json:"usage"`
+}
+
+func (t *Traffic) load() error {
+	if t.store == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(t.store)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	records := make(map[string]trafficRecord)
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("%s: %v", t.store, err)
+	}
+
+	for user, rec := range records {
+		t.users[user] = &userTraffic{usage: rec.Usage, start: rec.Start}
+	}
+	return nil
+}
+
+// TakeOver makes t take over the store from the traffic prev, which has saved the usage for t to load.
+// The store is not saved by t until prev is stopped and has saved the usage for the last time,
+// so the final save of prev never overwrites the usage saved by t.
+func (t *Traffic) TakeOver(prev *Traffic) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.prev = prev
+}
+
+// Save persists the quota usage to the store file.
+// It is a no-op once the traffic is stopped, or before the traffic taken over from is stopped.
+func (t *Traffic) Save() error {
+	if t == nil || t.store == "" {
+		return nil
+	}
+
+	t.saveMux.Lock()
+	defer t.saveMux.Unlock()
+
+	select {
+	case <-t.saved:
+		return nil
+	default:
+	}
+	return t.save()
+}
+
+func (t *Traffic) save() error {
+	if t.store == "" {
+		return nil
+	}
+
+	t.mux.RLock()
+	prev := t.prev
+	t.mux.RUnlock()
+	if prev != nil {
+		select {
+		case <-prev.saved:
+		default:
+			return nil // the store is still owned by prev
+		}
+	}
+
+	t.mux.RLock()
+	records := make(map[string]trafficRecord)
+	for user, u := range t.users {
+		records[user] = trafficRecord{Start: u.start, Usage: atomic.LoadInt64(&u.usage)}
+	}
+	t.mux.RUnlock()
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so a crash never leaves a truncated store.
+	tmp := t.store + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.store)
+}
+
+// PeriodSave saves the quota usage of the Traffic t periodically until it is stopped.
+func PeriodSave(t *Traffic, period time.Duration) {
+	if t == nil || t.store == "" {
+		return
+	}
+	if period < time.Second {
+		period = time.Second
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.Save(); err != nil {
+				log.Logf("[traffic] %s: %v", t.store, err)
+			}
+		case <-t.stopped:
+			return
+		}
+	}
+}
+
+// wrapConn wraps the client connection conn to account the traffic of the user.
+func (t *Traffic) wrapConn(conn net.Conn, user string) net.Conn {
+	if t == nil {
+		return conn
+	}
+
+	t.mux.Lock()
+	u := t.user(user)
+	t.mux.Unlock()
+
+	return &trafficConn{Conn: conn, total: &t.total, user: u}
+}
+
+type trafficConn struct {
+	net.Conn
+	total *userTraffic
+	user  *userTraffic
+}
+
+func (c *trafficConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.total.add(n, 0)
+	c.user.add(n, 0)
+	return
+}
+
+func (c *trafficConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.total.add(0, n)
+	c.user.add(0, n)
+	return
+}
@@ -0,0 +1,279 @@
+package gost
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var trafficReloadTests = []struct {
+	r      io.Reader
+	period time.Duration
+	quotas map[string]Quota
+	err    bool
+}{
+	{
+		r: nil,
+	},
+	{
+		r: bytes.NewBufferString(""),
+	},
+	{
+		r:      bytes.NewBufferString("reload 10s\n* 10GB monthly"),
+		period: 10 * time.Second,
+		quotas: map[string]Quota{
+			"*":     {Limit: 10 << 30, Period: QuotaMonthly},
+			"admin": {Limit: 10 << 30, Period: QuotaMonthly},
+		},
+	},
+	{
+		r: bytes.NewBufferString("# * 10GB\nadmin 1GB daily\nguest 100MB\n- 1KB monthly\ntest 0"),
+		quotas: map[string]Quota{
+			"admin": {Limit: 1 << 30, Period: QuotaDaily},
+			"guest": {Limit: 100 << 20, Period: QuotaDaily},
+			"":      {Limit: 1 << 10, Period: QuotaMonthly},
+			"test":  {},
+		},
+	},
+	{
+		r:   bytes.NewBufferString("admin 1GB weekly"),
+		err: true,
+	},
+}
+
+func TestTrafficReload(t *testing.T) {
+	for i, tc := range trafficReloadTests {
+		traffic, _ := NewTraffic("")
+		err := traffic.Reload(tc.r)
+		if (err != nil) != tc.err {
+			t.Errorf("#%d test failed: %v", i, err)
+			continue
+		}
+		if traffic.Period() != tc.period {
+			t.Errorf("#%d test failed: period %v, want %v", i, traffic.Period(), tc.period)
+		}
+		for user, quota := range tc.quotas {
+			if _, q := traffic.Usage(user); q != quota {
+				t.Errorf("#%d test failed: %s quota %v, want %v", i, user, q, quota)
+			}
+		}
+	}
+}
+
+func TestTrafficAccounting(t *testing.T) {
+	traffic, _ := NewTraffic("")
+	traffic.SetQuota("admin", Quota{Limit: 100, Period: QuotaDaily})
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go io.Copy(c2, c2) // echo
+
+	conn := traffic.wrapConn(c1, "admin")
+	if traffic.Exceeded("admin") {
+		t.Error("quota should not be exceeded")
+	}
+
+	b := make([]byte, 60)
+	conn.Write(b)
+	io.ReadFull(conn, b)
+
+	if stats := traffic.Stats("admin"); stats.In != 60 || stats.Out != 60 {
+		t.Errorf("wrong stats of admin %+v", stats)
+	}
+	if stats := traffic.Stats(""); stats.Total() != 120 {
+		t.Errorf("wrong total stats %+v", stats)
+	}
+	if users := traffic.Users(); len(users) != 1 || users[0] != "admin" {
+		t.Errorf("wrong users %v", users)
+	}
+	if !traffic.Exceeded("admin") {
+		t.Error("quota should be exceeded")
+	}
+	if traffic.Exceeded("guest") {
+		t.Error("user without quota should never exceed")
+	}
+}
+
+func TestTrafficStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := filepath.Join(dir, "traffic.json")
+
+	traffic, err := NewTraffic(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic.SetQuota("*", Quota{Limit: 1024, Period: QuotaMonthly})
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go io.Copy(ioutil.Discard, c2)
+
+	traffic.wrapConn(c1, "admin").Write(make([]byte, 100))
+	traffic.Stop()
+
+	traffic, err = NewTraffic(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic.SetQuota("*", Quota{Limit: 1024, Period: QuotaMonthly})
+	if usage, _ := traffic.Usage("admin"); usage != 100 {
+		t.Errorf("usage should be loaded from store, got %d", usage)
+	}
+
+	// a new quota period resets the usage.
+	traffic.SetQuota("admin", Quota{Limit: 1024, Period: QuotaDaily})
+	if usage, _ := traffic.Usage("admin"); usage == 100 && time.Now().Day() != 1 {
+		t.Errorf("usage should be reset, got %d", usage)
+	}
+}
+
+func TestTrafficTakeOver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := filepath.Join(dir, "traffic.json")
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go io.Copy(ioutil.Discard, c2)
+
+	prev, err := NewTraffic(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev.SetQuota("*", Quota{Limit: 1024, Period: QuotaMonthly})
+	prev.wrapConn(c1, "admin").Write(make([]byte, 100))
+	prev.Save()
+
+	traffic, err := NewTraffic(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic.TakeOver(prev)
+	traffic.SetQuota("*", Quota{Limit: 1024, Period: QuotaMonthly})
+	traffic.wrapConn(c1, "admin").Write(make([]byte, 10))
+	defer traffic.Stop()
+
+	// the active session of prev is still accounted to it until it is stopped.
+	prev.wrapConn(c1, "admin").Write(make([]byte, 50))
+	if err := traffic.Save(); err != nil {
+		t.Fatal(err)
+	}
+	prev.Stop()
+
+	loaded, _ := NewTraffic(store)
+	loaded.SetQuota("*", Quota{Limit: 1024, Period: QuotaMonthly})
+	if usage, _ := loaded.Usage("admin"); usage != 150 {
+		t.Errorf("the final save of prev is overwritten, got usage %d", usage)
+	}
+
+	// the store is saved by the new traffic after prev is stopped.
+	os.Remove(store)
+	if err := traffic.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store); err != nil {
+		t.Errorf("the store is not saved by the new traffic: %v", err)
+	}
+}
+
+func TestHTTPProxyQuota(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traffic, _ := NewTraffic("")
+	traffic.SetQuota("admin", Quota{Limit: 1, Period: QuotaDaily})
+
+	client := &Client{
+		Connector:   HTTPConnector(url.UserPassword("admin", "123456")),
+		Transporter: TCPTransporter(),
+	}
+	server := &Server{
+		Listener: ln,
+		Handler: HTTPHandler(
+			UsersHandlerOption(url.UserPassword("admin", "123456")),
+			TrafficHandlerOption(traffic),
+		),
+	}
+	go server.Run()
+	defer server.Close()
+
+	if err := proxyRoundtrip(client, server, httpSrv.URL, sendData); err != nil {
+		t.Fatal(err)
+	}
+
+	err = proxyRoundtrip(client, server, httpSrv.URL, sendData)
+	if err == nil || err.Error() != "403 Forbidden" {
+		t.Errorf("got error %v, want 403 Forbidden", err)
+	}
+}
+
+// TestHTTPProxyQuotaUnauthenticated checks the traffic is not charged to the user of
+// the Proxy-Authorization header if there is no authenticator.
+func TestHTTPProxyQuotaUnauthenticated(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	ln, err := TCPListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traffic, _ := NewTraffic("")
+	traffic.SetQuota("admin", Quota{Limit: 1, Period: QuotaDaily})
+
+	server := &Server{
+		Listener: ln,
+		Handler:  HTTPHandler(TrafficHandlerOption(traffic)),
+	}
+	go server.Run()
+	defer server.Close()
+
+	for _, user := range []string{"admin", "test"} {
+		client := &Client{
+			Connector:   HTTPConnector(url.UserPassword(user, "123456")),
+			Transporter: TCPTransporter(),
+		}
+		for i := 0; i < 2; i++ {
+			if err := proxyRoundtrip(client, server, httpSrv.URL, sendData); err != nil {
+				t.Errorf("%s: %v", user, err)
+			}
+		}
+	}
+	for _, user := range traffic.Users() {
+		if user == "admin" || user == "test" {
+			t.Errorf("traffic is charged to user %s", user)
+		}
+	}
+	if usage, _ := traffic.Usage("admin"); usage != 0 {
+		t.Errorf("got usage %d of admin", usage)
+	}
+}
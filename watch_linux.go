@@ -0,0 +1,127 @@
+package gost
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// inotifyWatcher watches the directories of the file and its symlink target by inotify,
+// so the atomic renames of the editors and the symlink swaps of the Kubernetes ConfigMap are caught.
+type inotifyWatcher struct {
+	fd    int
+	file  string
+	names map[string]bool // the base names of the file and its symlink target
+	dirs  map[int]string  // the watched directories by the watch descriptor
+	buf   [64 * (unix.SizeofInotifyEvent + unix.NAME_MAX + 1)]byte
+}
+
+func newFileWatcher(file string) (fileWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		fd:   fd,
+		file: file,
+		dirs: make(map[int]string),
+	}
+	if err := w.update(); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return w, nil
+}
+
+// update watches the directories of the file and its current symlink target.
+func (w *inotifyWatcher) update() error {
+	paths := []string{w.file}
+	if target, err := filepath.EvalSymlinks(w.file); err == nil {
+		paths = append(paths, target)
+	}
+
+	w.names = make(map[string]bool)
+	for _, p := range paths {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		w.names[filepath.Base(p)] = true
+
+		dir := filepath.Dir(p)
+		if w.watched(dir) {
+			continue
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.dirs[wd] = dir
+	}
+	return nil
+}
+
+func (w *inotifyWatcher) watched(dir string) bool {
+	for _, d := range w.dirs {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *inotifyWatcher) Wait(timeout time.Duration) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+	if err == unix.EINTR || n == 0 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	n, err = unix.Read(w.fd, w.buf[:])
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for off := 0; off+unix.SizeofInotifyEvent <= n; {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&w.buf[off]))
+		start := off + unix.SizeofInotifyEvent
+		off = start + int(ev.Len)
+		if off > n {
+			break
+		}
+		name := strings.TrimRight(string(w.buf[start:off]), "\x00")
+
+		switch {
+		case ev.Mask&unix.IN_IGNORED != 0: // the directory is removed
+			delete(w.dirs, int(ev.Wd))
+			changed = true
+		case name == "", // the directory itself
+			w.names[name],
+			strings.HasPrefix(name, ".."): // the data symlink of the ConfigMap
+			changed = true
+		}
+	}
+	if changed {
+		// the symlink target may be swapped, or the file is being replaced.
+		w.update()
+	}
+	return changed, nil
+}
+
+func (w *inotifyWatcher) Close() error {
+	return unix.Close(w.fd)
+}
@@ -0,0 +1,161 @@
+package gost
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReloader records the reloaded contents, its reload period is longer than the test,
+// so the changes are reloaded by the watcher only. The zero period disables the reloading.
+type testReloader struct {
+	period  time.Duration
+	data    []string
+	stopped bool
+	mux     sync.Mutex
+}
+
+func (r *testReloader) Reload(rd io.Reader) error {
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.data = append(r.data, string(b))
+	return nil
+}
+
+func (r *testReloader) Period() time.Duration {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.stopped {
+		return -1
+	}
+	return r.period
+}
+
+func (r *testReloader) last() (int, string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if len(r.data) == 0 {
+		return 0, ""
+	}
+	return len(r.data), r.data[len(r.data)-1]
+}
+
+func TestPeriodReloadWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the ConfigMap layout: hosts -> ..data/hosts, ..data -> ..v1
+	for _, v := range []string{"..v1", "..v2"} {
+		if err := os.Mkdir(filepath.Join(dir, v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, v, "hosts"), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	linked := filepath.Join(dir, "hosts")
+	if err := os.Symlink(filepath.Join("..data", "hosts"), linked); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "bypass")
+	if err := ioutil.WriteFile(file, []byte("v0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &testReloader{period: time.Hour}
+	done := make(chan struct{})
+	go func() {
+		PeriodReload(r, file)
+		close(done)
+	}()
+	lr := &testReloader{period: time.Hour}
+	go PeriodReload(lr, linked)
+	time.Sleep(100 * time.Millisecond) // wait for the watchers
+
+	// a burst of writes within the same second is reloaded once
+	for _, s := range []string{"v1", "v2", "v3"} {
+		if err := ioutil.WriteFile(file, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !waitFor(func() bool { _, s := r.last(); return s == "v3" }) {
+		t.Fatal("the written file is not reloaded")
+	}
+	if n, _ := r.last(); n != 1 {
+		t.Errorf("the burst of writes is reloaded %d times", n)
+	}
+
+	// the atomic rename of an editor
+	tmp := filepath.Join(dir, ".bypass.swp")
+	if err := ioutil.WriteFile(tmp, []byte("v4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { _, s := r.last(); return s == "v4" }) {
+		t.Error("the renamed file is not reloaded")
+	}
+
+	// the symlink swap of the ConfigMap
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { _, s := lr.last(); return s == "..v2" }) {
+		t.Error("the swapped symlink is not reloaded")
+	}
+
+	lr.mux.Lock()
+	lr.stopped = true
+	lr.mux.Unlock()
+	r.mux.Lock()
+	r.stopped = true
+	r.mux.Unlock()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Error("the watcher is not stopped")
+	}
+}
+
+func TestPeriodReloadDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "bypass")
+	if err := ioutil.WriteFile(file, []byte("v0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &testReloader{}
+	done := make(chan struct{})
+	go func() {
+		PeriodReload(r, file)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the reloading with zero period is not disabled")
+	}
+}
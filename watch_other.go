@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package gost
+
+func newFileWatcher(file string) (fileWatcher, error) {
+	return nil, errWatchNotSupported
+}